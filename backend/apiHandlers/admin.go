@@ -0,0 +1,325 @@
+package apiHandlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// AdminHandler handles admin-only tile pipeline inspection and maintenance requests
+type AdminHandler struct {
+	app         core.App
+	authService interfaces.Auth
+	syncOutbox  interfaces.SyncOutboxAdmin
+	live        atomic.Pointer[adminTilePipeline] // Set once PostGIS is reachable
+	syncRunning atomic.Bool
+}
+
+// adminTilePipeline holds the services that need PostGIS
+type adminTilePipeline struct {
+	pipeline   interfaces.TilePipelineAdmin
+	reconciler interfaces.ConsistencyReconciler
+}
+
+// TileStateResponse is the JSON representation of a tile's cache state
+type TileStateResponse struct {
+	Z      int    `json:"z"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Status string `json:"status"`
+	Size   int    `json:"size_bytes"`
+}
+
+// InvalidateRequest selects the tiles to invalidate: a zoom range, optionally limited to a bbox
+type InvalidateRequest struct {
+	BBox    *entities.BoundingBox `json:"bbox"`
+	MinZoom *int                  `json:"min_zoom"`
+	MaxZoom *int                  `json:"max_zoom"`
+}
+
+// NewAdminHandler creates a new admin handler. Tile pipeline endpoints answer 503 until SetTilePipeline is called.
+func NewAdminHandler(app core.App, authService interfaces.Auth, syncOutbox interfaces.SyncOutboxAdmin) *AdminHandler {
+	return &AdminHandler{
+		app:         app,
+		authService: authService,
+		syncOutbox:  syncOutbox,
+	}
+}
+
+// SetTilePipeline enables the tile pipeline endpoints once PostGIS is reachable
+func (h *AdminHandler) SetTilePipeline(pipeline interfaces.TilePipelineAdmin, reconciler interfaces.ConsistencyReconciler) {
+	h.live.Store(&adminTilePipeline{pipeline: pipeline, reconciler: reconciler})
+}
+
+// SetupRoutes adds admin endpoints to the router, restricted to superusers and users with the Admin role
+func (h *AdminHandler) SetupRoutes(e *core.ServeEvent) {
+	g := e.Router.Group("/api/admin")
+	g.Bind(apis.RequireAuth())
+	g.BindFunc(h.requireAdmin)
+
+	g.GET("/tiles/trails/{trailId}", h.HandleTrailTiles).BindFunc(h.requireTilePipeline)
+	g.GET("/tiles/queue", h.HandleQueue).BindFunc(h.requireTilePipeline)
+	g.POST("/tiles/invalidate", h.HandleInvalidate).BindFunc(h.requireTilePipeline)
+	g.POST("/trails/{trailId}/rebuild", h.HandleRebuildTrail).BindFunc(h.requireTilePipeline)
+	g.POST("/sync", h.HandleSyncAll).BindFunc(h.requireTilePipeline)
+	g.POST("/snapshot", h.HandleSnapshot).BindFunc(h.requireTilePipeline)
+
+	g.GET("/sync/events", h.HandleSyncEvents)
+	g.POST("/sync/events/{eventId}/retry", h.HandleRetrySyncEvent)
+	g.DELETE("/sync/events/{eventId}", h.HandleDiscardSyncEvent)
+
+	g.GET("/reconcile", h.HandleDriftReport).BindFunc(h.requireTilePipeline)
+	g.POST("/reconcile", h.HandleReconcile).BindFunc(h.requireTilePipeline)
+}
+
+// requireAdmin rejects authenticated users without the Admin role
+func (h *AdminHandler) requireAdmin(re *core.RequestEvent) error {
+	if re.HasSuperuserAuth() || h.authService.IsAdmin(re.Auth) {
+		return re.Next()
+	}
+	return apis.NewForbiddenError("Admin role required", nil)
+}
+
+// requireTilePipeline rejects tile pipeline requests while PostGIS is unavailable
+func (h *AdminHandler) requireTilePipeline(re *core.RequestEvent) error {
+	if h.live.Load() == nil {
+		return re.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Tile pipeline unavailable, PostGIS is not connected"})
+	}
+	return re.Next()
+}
+
+// HandleTrailTiles lists the tiles covered by a trail with their cache status
+func (h *AdminHandler) HandleTrailTiles(re *core.RequestEvent) error {
+	trailID := re.Request.PathValue("trailId")
+
+	states, err := h.live.Load().pipeline.GetTrailTileStates(re.Request.Context(), trailID)
+	if err != nil {
+		log.Printf("Failed to get tiles for trail %s: %v", trailID, err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get trail tiles"})
+	}
+
+	tiles := make([]TileStateResponse, 0, len(states))
+	counts := make(map[string]int)
+	for _, state := range states {
+		status := state.Status.String()
+		counts[status]++
+		tiles = append(tiles, TileStateResponse{
+			Z:      state.Coords.Z,
+			X:      state.Coords.X,
+			Y:      state.Coords.Y,
+			Status: status,
+			Size:   state.Size,
+		})
+	}
+
+	return re.JSON(http.StatusOK, map[string]any{
+		"trail_id":  trailID,
+		"count":     len(tiles),
+		"by_status": counts,
+		"tiles":     tiles,
+	})
+}
+
+// HandleQueue shows queue depths, counters and the next background tiles
+func (h *AdminHandler) HandleQueue(re *core.RequestEvent) error {
+	limit := 100
+	if v := re.Request.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+		limit = parsed
+	}
+
+	return re.JSON(http.StatusOK, h.live.Load().pipeline.QueueStatus(limit))
+}
+
+// HandleInvalidate invalidates cached and backup tiles in a zoom range and optional bbox, and queues their regeneration
+func (h *AdminHandler) HandleInvalidate(re *core.RequestEvent) error {
+	var req InvalidateRequest
+	if err := re.BindBody(&req); err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	minZoom, maxZoom := 0, 30
+	if req.MinZoom != nil {
+		minZoom = *req.MinZoom
+	}
+	if req.MaxZoom != nil {
+		maxZoom = *req.MaxZoom
+	}
+	if minZoom > maxZoom {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "min_zoom must not exceed max_zoom"})
+	}
+
+	if req.BBox != nil && (req.BBox.West > req.BBox.East || req.BBox.South > req.BBox.North) {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid bbox"})
+	}
+
+	count := h.live.Load().pipeline.InvalidateTileRange(req.BBox, minZoom, maxZoom)
+
+	return re.JSON(http.StatusOK, map[string]any{"invalidated": count})
+}
+
+// HandleRebuildTrail re-syncs a single trail and regenerates its tiles
+func (h *AdminHandler) HandleRebuildTrail(re *core.RequestEvent) error {
+	trailID := re.Request.PathValue("trailId")
+
+	if err := h.live.Load().pipeline.RebuildTrail(re.Request.Context(), h.app, trailID); err != nil {
+		log.Printf("Failed to rebuild trail %s: %v", trailID, err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return re.JSON(http.StatusOK, map[string]string{"status": "rebuilt", "trail_id": trailID})
+}
+
+// HandleSyncAll starts a trail synchronization in the background: incremental by default,
+// or a full clear-and-rebuild with ?mode=full
+func (h *AdminHandler) HandleSyncAll(re *core.RequestEvent) error {
+	mode := re.Request.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "incremental"
+	}
+	if mode != "incremental" && mode != "full" {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid mode, expected incremental or full"})
+	}
+
+	if !h.syncRunning.CompareAndSwap(false, true) {
+		return re.JSON(http.StatusConflict, map[string]string{"error": "Sync already running"})
+	}
+
+	pipeline := h.live.Load().pipeline
+	go func() {
+		defer h.syncRunning.Store(false)
+
+		log.Printf("Admin triggered %s trail sync", mode)
+		var err error
+		if mode == "full" {
+			err = pipeline.RebuildAllTrails(context.Background(), h.app)
+		} else {
+			_, err = pipeline.SyncAllTrails(context.Background(), h.app)
+		}
+		if err != nil {
+			log.Printf("Admin triggered %s sync failed: %v", mode, err)
+		}
+	}()
+
+	return re.JSON(http.StatusAccepted, map[string]string{"status": "sync started", "mode": mode})
+}
+
+// HandleSnapshot writes an MBTiles snapshot now
+func (h *AdminHandler) HandleSnapshot(re *core.RequestEvent) error {
+	if err := h.live.Load().pipeline.TriggerSnapshot(); err != nil {
+		log.Printf("Admin triggered snapshot failed: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return re.JSON(http.StatusOK, map[string]string{"status": "snapshot complete"})
+}
+
+// HandleSyncEvents lists outbox sync events, dead-lettered ones by default
+func (h *AdminHandler) HandleSyncEvents(re *core.RequestEvent) error {
+	status := re.Request.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = entities.SyncStatusDead
+	case "all":
+		status = ""
+	case entities.SyncStatusPending, entities.SyncStatusDead:
+	default:
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid status"})
+	}
+
+	limit := 100
+	if v := re.Request.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+		limit = parsed
+	}
+
+	events, err := h.syncOutbox.ListEvents(status, limit)
+	if err != nil {
+		log.Printf("Failed to list sync events: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list sync events"})
+	}
+
+	stats, err := h.syncOutbox.Stats()
+	if err != nil {
+		log.Printf("Failed to count sync events: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to count sync events"})
+	}
+
+	return re.JSON(http.StatusOK, map[string]any{
+		"stats":  stats,
+		"events": events,
+	})
+}
+
+// HandleRetrySyncEvent makes a sync event due again with a fresh set of attempts
+func (h *AdminHandler) HandleRetrySyncEvent(re *core.RequestEvent) error {
+	eventID := re.Request.PathValue("eventId")
+
+	if err := h.syncOutbox.RetryEvent(eventID); err != nil {
+		log.Printf("Failed to retry sync event %s: %v", eventID, err)
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "Sync event not found"})
+	}
+
+	return re.JSON(http.StatusOK, map[string]string{"status": "scheduled", "event_id": eventID})
+}
+
+// HandleDiscardSyncEvent removes a sync event without applying it
+func (h *AdminHandler) HandleDiscardSyncEvent(re *core.RequestEvent) error {
+	eventID := re.Request.PathValue("eventId")
+
+	if err := h.syncOutbox.DiscardEvent(eventID); err != nil {
+		log.Printf("Failed to discard sync event %s: %v", eventID, err)
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "Sync event not found"})
+	}
+
+	return re.JSON(http.StatusOK, map[string]string{"status": "discarded", "event_id": eventID})
+}
+
+// HandleDriftReport returns the report of the last reconciler run
+func (h *AdminHandler) HandleDriftReport(re *core.RequestEvent) error {
+	report := h.live.Load().reconciler.LastReport()
+	if report == nil {
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "No reconciliation has run yet"})
+	}
+
+	return re.JSON(http.StatusOK, report)
+}
+
+// HandleReconcile runs a reconciliation now and returns its drift report.
+// Drift is only reported unless ?repair=true is given.
+func (h *AdminHandler) HandleReconcile(re *core.RequestEvent) error {
+	repair := false
+	if v := re.Request.URL.Query().Get("repair"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid repair flag"})
+		}
+		repair = parsed
+	}
+
+	report, err := h.live.Load().reconciler.Run(re.Request.Context(), repair)
+	if errors.Is(err, interfaces.ErrReconcileRunning) {
+		return re.JSON(http.StatusConflict, map[string]string{"error": "Reconciliation already running"})
+	}
+	if err != nil {
+		log.Printf("Admin triggered reconciliation failed: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return re.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,79 @@
+package apiHandlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Change feed batch sizes
+const (
+	defaultChangesLimit = 500
+	maxChangesLimit     = 1000
+)
+
+// ChangesHandler serves the change feed for clients syncing trails, ratings and comments incrementally
+type ChangesHandler struct {
+	changes interfaces.ChangeFeed
+}
+
+// NewChangesHandler creates a new change feed handler
+func NewChangesHandler(changes interfaces.ChangeFeed) *ChangesHandler {
+	return &ChangesHandler{
+		changes: changes,
+	}
+}
+
+// SetupRoutes adds the change feed endpoint to the router
+func (h *ChangesHandler) SetupRoutes(e *core.ServeEvent) {
+	e.Router.GET("/api/changes", h.HandleChanges)
+}
+
+// HandleChanges returns the changes after the since cursor, with the cursor of the next batch.
+// Without since, only the current cursor is returned: clients take it before their initial full
+// download, then follow the feed from there. A 410 response means the cursor is too old and the
+// client has to download everything again.
+func (h *ChangesHandler) HandleChanges(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+	ctx := re.Request.Context()
+	re.Response.Header().Set("Cache-Control", "no-store")
+
+	if query.Get("since") == "" {
+		cursor, err := h.changes.Cursor(ctx)
+		if err != nil {
+			log.Printf("Failed to get change cursor: %v", err)
+			return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get change cursor"})
+		}
+		return re.JSON(http.StatusOK, entities.ChangeFeed{Cursor: cursor, Changes: []entities.ChangeEntry{}})
+	}
+
+	since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+	if err != nil || since < 0 {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "since must be a cursor returned by this endpoint"})
+	}
+
+	limit := defaultChangesLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxChangesLimit {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxChangesLimit)})
+		}
+	}
+
+	feed, err := h.changes.Changes(ctx, since, limit)
+	if errors.Is(err, interfaces.ErrCursorExpired) {
+		return re.JSON(http.StatusGone, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		log.Printf("Failed to get changes since %d: %v", since, err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get changes"})
+	}
+
+	return re.JSON(http.StatusOK, feed)
+}
@@ -0,0 +1,74 @@
+package apiHandlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"bike-map/interfaces"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxStatsTrailIDs bounds the number of trail IDs accepted in a single stats request
+const maxStatsTrailIDs = 500
+
+// EngagementHandler serves trail engagement stats (ratings, comments) separately from the geometry tiles
+type EngagementHandler struct {
+	engagementService interfaces.Engagement
+}
+
+// NewEngagementHandler creates a new engagement handler
+func NewEngagementHandler(engagementService interfaces.Engagement) *EngagementHandler {
+	return &EngagementHandler{
+		engagementService: engagementService,
+	}
+}
+
+// SetupRoutes adds engagement endpoints to the router
+func (h *EngagementHandler) SetupRoutes(e *core.ServeEvent) {
+	e.Router.GET("/api/engagement/stats", h.HandleStats)
+}
+
+// HandleStats returns engagement stats keyed by trail ID.
+// The optional ids query parameter (comma separated) limits the result to those trails.
+func (h *EngagementHandler) HandleStats(re *core.RequestEvent) error {
+	var trailIDs []string
+	if v := re.Request.URL.Query().Get("ids"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				trailIDs = append(trailIDs, id)
+			}
+		}
+		if len(trailIDs) > maxStatsTrailIDs {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": "Too many trail ids"})
+		}
+	}
+
+	stats, err := h.engagementService.GetEngagementStatsBulk(re.Request.Context(), trailIDs)
+	if err != nil {
+		log.Printf("Failed to get engagement stats: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get engagement stats"})
+	}
+
+	body, err := json.Marshal(map[string]any{"stats": stats})
+	if err != nil {
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to encode engagement stats"})
+	}
+
+	// Stats change on every rating or comment: let clients revalidate cheaply instead of caching
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	re.Response.Header().Set("ETag", etag)
+	re.Response.Header().Set("Cache-Control", "no-cache")
+
+	if re.Request.Header.Get("If-None-Match") == etag {
+		re.Response.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return re.Blob(http.StatusOK, "application/json", body)
+}
@@ -2,10 +2,13 @@ package apiHandlers
 
 import (
 	"bike-map/entities"
+	"bike-map/interfaces"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -14,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -23,22 +27,167 @@ type SnapshotInfo struct {
 	SizeBytes int64     `json:"size_bytes"`
 	SizeMB    float64   `json:"size_mb"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// From the snapshot manifest, missing while it is being written
+	SHA256   string                     `json:"sha256,omitempty"`
+	Manifest *entities.SnapshotManifest `json:"manifest,omitempty"`
+}
+
+// SignedURLRequest is the body of a signed download URL request
+type SignedURLRequest struct {
+	URL string `json:"url"` // Download URL to sign, e.g. /api/mbtiles/download/latest
+}
+
+// SignedURLResponse is a download URL usable without credentials until it expires
+type SignedURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// downloadLimit is how a download route counts against the per-user rate limit
+type downloadLimit int
+
+const (
+	limitNone     downloadLimit = iota // Not rate limited
+	limitStarts                        // Every download started counts, resumes of an admitted download do not
+	limitRequests                      // Every request counts: the file is built on demand
+)
+
+// downloadKeyStore is the request store key of the rate limit key of an authorized download
+const downloadKeyStore = "downloadKey"
+
+// signablePaths are the download routes signed URLs may be issued for
+var signablePaths = []string{"/api/mbtiles/download/", "/api/mbtiles/extract", "/api/mbtiles/delta", "/api/mbtiles/bundle", "/api/pmtiles/download/"}
+
 type MBTilesHandler struct {
 	snapshotDir string
+	extractor   interfaces.MBTilesExtractor
+	bundler     interfaces.OfflineBundler
+	downloads   interfaces.SnapshotDownloadTracker
+	access      interfaces.DownloadAccess
 }
 
-func NewMBTilesHandler(snapshotDir string) *MBTilesHandler {
+func NewMBTilesHandler(
+	snapshotDir string,
+	extractor interfaces.MBTilesExtractor,
+	bundler interfaces.OfflineBundler,
+	downloads interfaces.SnapshotDownloadTracker,
+	access interfaces.DownloadAccess,
+) *MBTilesHandler {
 	return &MBTilesHandler{
 		snapshotDir: snapshotDir,
+		extractor:   extractor,
+		bundler:     bundler,
+		downloads:   downloads,
+		access:      access,
 	}
 }
 
 func (h *MBTilesHandler) SetupRoutes(e *core.ServeEvent) {
 	e.Router.GET("/api/mbtiles/latest", h.HandleLatest)
-	e.Router.GET("/api/mbtiles/download/latest", h.HandleDownloadLatest)
-	e.Router.GET("/api/mbtiles/download/{filename}", h.HandleDownload)
+	e.Router.GET("/api/mbtiles/download/latest", h.HandleDownloadLatest).BindFunc(h.authorizeDownload(limitStarts))
+	e.Router.GET("/api/mbtiles/download/{filename}", h.HandleDownload).BindFunc(h.authorizeDownload(limitStarts))
+	e.Router.GET("/api/mbtiles/extract", h.HandleExtract).BindFunc(h.authorizeDownload(limitRequests))
+	e.Router.GET("/api/mbtiles/delta", h.HandleDelta).BindFunc(h.authorizeDownload(limitRequests))
+	e.Router.GET("/api/mbtiles/regions", h.HandleRegions)
+	e.Router.GET("/api/mbtiles/bundle", h.HandleBundle).BindFunc(h.authorizeDownload(limitRequests))
+	e.Router.GET("/api/mbtiles/bundle/manifest", h.HandleBundleManifest).BindFunc(h.authorizeDownload(limitRequests))
+	e.Router.POST("/api/mbtiles/download-url", h.HandleSignURL).Bind(apis.RequireAuth())
+	e.Router.GET("/api/pmtiles/latest", h.HandlePMTilesLatest)
+	// Map clients read the archive with many Range requests: not rate limited
+	e.Router.GET("/api/pmtiles/download/latest", h.HandlePMTilesDownload).BindFunc(h.authorizeDownload(limitNone))
+}
+
+// authorizeDownload enforces the download access mode and the per-user rate limit of the route.
+// Requests are identified by their auth record, the user of a signed URL or the client IP.
+// Downloads limited by starts are counted by the handler, which knows the file being resumed.
+func (h *MBTilesHandler) authorizeDownload(limit downloadLimit) func(re *core.RequestEvent) error {
+	return func(re *core.RequestEvent) error {
+		var key string
+		switch {
+		case re.Auth != nil:
+			key = "user:" + re.Auth.Id
+		case h.access.SignedURLs() && re.Request.URL.Query().Has("sig"):
+			userID, err := h.access.Verify(re.Request.URL)
+			if errors.Is(err, interfaces.ErrSignatureExpired) {
+				return re.JSON(http.StatusForbidden, map[string]string{"error": "Download link expired"})
+			}
+			if err != nil {
+				return re.JSON(http.StatusForbidden, map[string]string{"error": "Invalid download link"})
+			}
+			key = "user:" + userID
+		case h.access.RequiresAuth():
+			return re.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required to download"})
+		default:
+			key = "ip:" + re.RealIP()
+		}
+
+		re.Set(downloadKeyStore, key)
+		if limit == limitRequests {
+			if ok, err := h.allowDownload(re, key, ""); !ok {
+				return err
+			}
+		}
+
+		return re.Next()
+	}
+}
+
+// allowDownload counts a download against the rate limit of key. If over the limit, it responds
+// 429 and returns false with the error of the response.
+func (h *MBTilesHandler) allowDownload(re *core.RequestEvent, key, etag string) (bool, error) {
+	ok, retryAfter := h.access.Allow(key, etag)
+	if !ok {
+		re.Response.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		return false, re.JSON(http.StatusTooManyRequests, map[string]string{"error": "Download limit reached, try again later"})
+	}
+	return true, nil
+}
+
+// resumesDownload reports whether a request resumes a download of the file with etag that its
+// client was admitted to: a Range request, with an If-Range matching etag if any, so that
+// only part of that same file is sent
+func (h *MBTilesHandler) resumesDownload(re *core.RequestEvent, etag string) bool {
+	header := re.Request.Header
+	if header.Get("Range") == "" {
+		return false
+	}
+	if ifRange := header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return false
+	}
+	key, _ := re.Get(downloadKeyStore).(string)
+	return h.access.Admitted(key, etag)
+}
+
+// HandleSignURL issues a signed, expiring URL for a download route, for download managers
+// that cannot send credentials
+func (h *MBTilesHandler) HandleSignURL(re *core.RequestEvent) error {
+	if !h.access.SignedURLs() {
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "Signed download URLs are disabled"})
+	}
+
+	var req SignedURLRequest
+	if err := re.BindBody(&req); err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || target.IsAbs() || !isSignablePath(target.Path) {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "url must be a download route"})
+	}
+
+	signed, expiresAt := h.access.Sign(target, re.Auth.Id)
+	return re.JSON(http.StatusOK, SignedURLResponse{URL: signed.String(), ExpiresAt: expiresAt})
+}
+
+// isSignablePath reports whether path is a download route
+func isSignablePath(path string) bool {
+	for _, prefix := range signablePaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *MBTilesHandler) HandleLatest(re *core.RequestEvent) error {
@@ -63,31 +212,7 @@ func (h *MBTilesHandler) HandleDownloadLatest(re *core.RequestEvent) error {
 		return re.String(http.StatusNotFound, "No snapshots available")
 	}
 
-	// Download the latest file
-	filePath := filepath.Join(h.snapshotDir, snapshot.Filename)
-
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Error opening file %s: %v", snapshot.Filename, err)
-		return re.String(http.StatusInternalServerError, "Failed to open file")
-	}
-	defer file.Close()
-
-	// Set headers
-	re.Response.Header().Set("Content-Type", "application/x-mbtiles")
-	re.Response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, snapshot.Filename))
-	re.Response.Header().Set("Content-Length", fmt.Sprintf("%d", snapshot.SizeBytes))
-	re.Response.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Stream file to client
-	re.Response.WriteHeader(http.StatusOK)
-	_, err = io.Copy(re.Response, file)
-	if err != nil {
-		log.Printf("Error streaming file %s: %v", snapshot.Filename, err)
-	}
-
-	return nil
+	return h.serveSnapshot(re, snapshot.Filename)
 }
 
 func (h *MBTilesHandler) HandleDownload(re *core.RequestEvent) error {
@@ -116,33 +241,293 @@ func (h *MBTilesHandler) HandleDownload(re *core.RequestEvent) error {
 		return re.String(http.StatusForbidden, "Access denied")
 	}
 
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
+	return h.serveSnapshot(re, filename)
+}
+
+// serveSnapshot streams a snapshot with Range and conditional request support, so interrupted
+// downloads can be resumed. The ETag is the snapshot checksum when its manifest is available.
+func (h *MBTilesHandler) serveSnapshot(re *core.RequestEvent, filename string) error {
+	// Protects the file from retention cleanup until the download completes
+	end := h.downloads.Begin(filename)
+	defer end()
+
+	file, err := os.Open(filepath.Join(h.snapshotDir, filename))
+	if os.IsNotExist(err) {
 		return re.String(http.StatusNotFound, "File not found")
 	}
-
-	// Open file
-	file, err := os.Open(filePath)
 	if err != nil {
 		log.Printf("Error opening file %s: %v", filename, err)
 		return re.String(http.StatusInternalServerError, "Failed to open file")
 	}
 	defer file.Close()
 
-	// Set headers
-	re.Response.Header().Set("Content-Type", "application/x-mbtiles")
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return re.String(http.StatusInternalServerError, "Failed to read file")
+	}
+
+	// The stream outlives the server write timeout on slow connections
+	_ = http.NewResponseController(re.Response).SetWriteDeadline(time.Time{})
+
+	etag := fmt.Sprintf(`"%x-%x"`, fileInfo.ModTime().UnixNano(), fileInfo.Size())
+	manifest := h.readManifest(filename)
+	if manifest != nil && manifest.SHA256 != "" {
+		etag = fmt.Sprintf(`"%s"`, manifest.SHA256)
+	}
+
+	// Resuming a download admitted earlier does not count against the rate limit again
+	if !h.resumesDownload(re, etag) {
+		key, _ := re.Get(downloadKeyStore).(string)
+		if ok, err := h.allowDownload(re, key, etag); !ok {
+			return err
+		}
+	}
+
+	header := re.Response.Header()
+	header.Set("Content-Type", "application/x-mbtiles")
+	header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, ETag, X-Checksum-SHA256")
+	header.Set("ETag", etag)
+	if manifest != nil && manifest.SHA256 != "" {
+		header.Set("X-Checksum-SHA256", manifest.SHA256)
+	}
+
+	http.ServeContent(re.Response, re.Request, filename, fileInfo.ModTime(), file)
+	return nil
+}
+
+// HandleExtract streams an MBTiles file holding only the tiles of an area, up to a maximum zoom.
+// The area is one of bbox=west,south,east,north, trail=<id> or region=<name>.
+func (h *MBTilesHandler) HandleExtract(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+
+	var req entities.MBTilesExtractRequest
+	areas := 0
+	if value := query.Get("bbox"); value != "" {
+		bbox, err := parseBBoxParam(value)
+		if err != nil {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		req.BBox = bbox
+		areas++
+	}
+	if req.TrailID = query.Get("trail"); req.TrailID != "" {
+		areas++
+	}
+	if req.Region = query.Get("region"); req.Region != "" {
+		areas++
+	}
+	if areas != 1 {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "Exactly one of bbox, trail or region is required"})
+	}
+
+	if value := query.Get("maxzoom"); value != "" {
+		maxZoom, err := strconv.Atoi(value)
+		if err != nil || maxZoom <= 0 {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid maxzoom"})
+		}
+		req.MaxZoom = maxZoom
+	}
+
+	extract, err := h.extractor.Extract(re.Request.Context(), req)
+	switch {
+	case errors.Is(err, interfaces.ErrNoSnapshot):
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "No snapshots available"})
+	case errors.Is(err, interfaces.ErrUnknownRegion):
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "Unknown region"})
+	case errors.Is(err, interfaces.ErrTrailNotFound):
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "Trail not found"})
+	case errors.Is(err, interfaces.ErrTrailLookupUnavailable):
+		return re.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Trail lookup is temporarily unavailable"})
+	case err != nil:
+		log.Printf("Error building MBTiles extract: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build extract"})
+	}
+
+	return h.serveBuiltFile(re, extract.Path, extract.Filename, "application/x-mbtiles", extract.Cached)
+}
+
+// HandleDelta streams the tiles changed since a client's snapshot, identified by the journal_epoch
+// and journal_seq values of its metadata (?epoch=...&since=...). Responds 410 Gone when the
+// delta cannot be computed and the client should download the latest snapshot instead.
+func (h *MBTilesHandler) HandleDelta(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+
+	epoch := query.Get("epoch")
+	since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+	if epoch == "" || err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "epoch and since are required"})
+	}
+
+	delta, err := h.extractor.Delta(re.Request.Context(), epoch, since)
+	switch {
+	case errors.Is(err, interfaces.ErrNoSnapshot):
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "No snapshots available"})
+	case errors.Is(err, interfaces.ErrDeltaUnavailable):
+		return re.JSON(http.StatusGone, map[string]string{"error": "Delta unavailable, download the latest snapshot"})
+	case err != nil:
+		log.Printf("Error building MBTiles delta: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build delta"})
+	}
+
+	return h.serveBuiltFile(re, delta.Path, delta.Filename, "application/x-mbtiles", delta.Cached)
+}
+
+// HandleBundle streams an offline bundle ZIP of an area: an MBTiles extract, the trails as GeoJSON
+// with elevation profiles, their latest comments and GPX files, and a manifest. The area is one of
+// bbox=west,south,east,north, region=<name> or trails=<id>,<id>... The ETag is the bundle version,
+// so apps can check for updates with If-None-Match.
+func (h *MBTilesHandler) HandleBundle(re *core.RequestEvent) error {
+	bundle, err := h.bundle(re)
+	if err != nil || bundle == nil {
+		return err
+	}
+
+	re.Response.Header().Set("ETag", fmt.Sprintf(`"%s"`, bundle.Manifest.Version))
+	return h.serveBuiltFile(re, bundle.Path, bundle.Filename, "application/zip", bundle.Cached)
+}
+
+// HandleBundleManifest returns the manifest of an offline bundle, building the bundle if needed.
+// Rate limited like bundle downloads, since it builds the same bundle.
+func (h *MBTilesHandler) HandleBundleManifest(re *core.RequestEvent) error {
+	bundle, err := h.bundle(re)
+	if err != nil || bundle == nil {
+		return err
+	}
+
+	re.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	return re.JSON(http.StatusOK, bundle.Manifest)
+}
+
+// bundle parses a bundle request and returns the bundle. A nil bundle means the error response was sent.
+func (h *MBTilesHandler) bundle(re *core.RequestEvent) (*entities.OfflineBundle, error) {
+	query := re.Request.URL.Query()
+
+	var req entities.OfflineBundleRequest
+	areas := 0
+	if value := query.Get("bbox"); value != "" {
+		bbox, err := parseBBoxParam(value)
+		if err != nil {
+			return nil, re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		req.BBox = bbox
+		areas++
+	}
+	if req.TrailIDs = parseIDListParam(query.Get("trails")); len(req.TrailIDs) > 0 {
+		areas++
+	}
+	if req.Region = query.Get("region"); req.Region != "" {
+		areas++
+	}
+	if areas != 1 {
+		return nil, re.JSON(http.StatusBadRequest, map[string]string{"error": "Exactly one of bbox, trails or region is required"})
+	}
+
+	if value := query.Get("maxzoom"); value != "" {
+		maxZoom, err := strconv.Atoi(value)
+		if err != nil || maxZoom <= 0 {
+			return nil, re.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid maxzoom"})
+		}
+		req.MaxZoom = maxZoom
+	}
+
+	bundle, err := h.bundler.Bundle(re.Request.Context(), req)
+	switch {
+	case errors.Is(err, interfaces.ErrNoSnapshot):
+		return nil, re.JSON(http.StatusNotFound, map[string]string{"error": "No snapshots available"})
+	case errors.Is(err, interfaces.ErrUnknownRegion):
+		return nil, re.JSON(http.StatusNotFound, map[string]string{"error": "Unknown region"})
+	case errors.Is(err, interfaces.ErrTrailNotFound):
+		return nil, re.JSON(http.StatusNotFound, map[string]string{"error": "Trail not found"})
+	case errors.Is(err, interfaces.ErrBundleTooLarge):
+		return nil, re.JSON(http.StatusBadRequest, map[string]string{"error": "Too many trails in the area, select a smaller one"})
+	case errors.Is(err, interfaces.ErrTrailLookupUnavailable):
+		return nil, re.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Trail lookup is temporarily unavailable"})
+	case err != nil:
+		log.Printf("Error building offline bundle: %v", err)
+		return nil, re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build bundle"})
+	}
+	return bundle, nil
+}
+
+// serveBuiltFile serves an extract, delta or bundle from the cache
+func (h *MBTilesHandler) serveBuiltFile(re *core.RequestEvent, path, filename, contentType string, cached bool) error {
+	// Opened right away: a cache eviction while streaming does not affect the download
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening %s: %v", path, err)
+		return re.String(http.StatusInternalServerError, "Failed to open file")
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return re.String(http.StatusInternalServerError, "Failed to read file")
+	}
+
+	cacheStatus := "miss"
+	if cached {
+		cacheStatus = "hit"
+	}
+
+	re.Response.Header().Set("Content-Type", contentType)
 	re.Response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-	re.Response.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
 	re.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	re.Response.Header().Set("X-Extract-Cache", cacheStatus)
+
+	http.ServeContent(re.Response, re.Request, filename, fileInfo.ModTime(), file)
+	return nil
+}
+
+// HandleRegions lists the named regions available for extracts
+func (h *MBTilesHandler) HandleRegions(re *core.RequestEvent) error {
+	re.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	return re.JSON(http.StatusOK, h.extractor.Regions())
+}
+
+// HandlePMTilesLatest returns information about the PMTiles archive of the latest snapshot
+func (h *MBTilesHandler) HandlePMTilesLatest(re *core.RequestEvent) error {
+	re.Response.Header().Set("Access-Control-Allow-Origin", "*")
+
+	fileInfo, err := os.Stat(filepath.Join(h.snapshotDir, entities.PMTilesLatestFilename))
+	if err != nil {
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "No PMTiles archive available"})
+	}
+
+	return re.JSON(http.StatusOK, SnapshotInfo{
+		Filename:  entities.PMTilesLatestFilename,
+		Timestamp: fileInfo.ModTime().Unix(),
+		SizeBytes: fileInfo.Size(),
+		SizeMB:    float64(fileInfo.Size()) / (1024 * 1024),
+		CreatedAt: fileInfo.ModTime(),
+	})
+}
 
-	// Stream file to client
-	re.Response.WriteHeader(http.StatusOK)
-	_, err = io.Copy(re.Response, file)
+// HandlePMTilesDownload serves the PMTiles archive. PMTiles readers fetch tiles with
+// Range requests, so the archive can be used as a map source directly from this URL.
+func (h *MBTilesHandler) HandlePMTilesDownload(re *core.RequestEvent) error {
+	// The archive is replaced by rename: the opened file stays consistent while it is served
+	file, err := os.Open(filepath.Join(h.snapshotDir, entities.PMTilesLatestFilename))
 	if err != nil {
-		log.Printf("Error streaming file %s: %v", filename, err)
+		return re.String(http.StatusNotFound, "No PMTiles archive available")
 	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return re.String(http.StatusInternalServerError, "Failed to read file")
+	}
+
+	// The stream outlives the server write timeout on slow connections
+	_ = http.NewResponseController(re.Response).SetWriteDeadline(time.Time{})
 
+	re.Response.Header().Set("Content-Type", "application/vnd.pmtiles")
+	re.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	re.Response.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, ETag")
+	re.Response.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, fileInfo.ModTime().UnixNano(), fileInfo.Size()))
+
+	http.ServeContent(re.Response, re.Request, entities.PMTilesLatestFilename, fileInfo.ModTime(), file)
 	return nil
 }
 
@@ -199,7 +584,26 @@ func (h *MBTilesHandler) getLatestSnapshot() (*SnapshotInfo, error) {
 		return snapshots[i].Timestamp > snapshots[j].Timestamp
 	})
 
-	return &snapshots[0], nil
+	latest := &snapshots[0]
+	if latest.Manifest = h.readManifest(latest.Filename); latest.Manifest != nil {
+		latest.SHA256 = latest.Manifest.SHA256
+	}
+	return latest, nil
+}
+
+// readManifest reads the manifest of a snapshot, or returns nil if it has none (yet)
+func (h *MBTilesHandler) readManifest(filename string) *entities.SnapshotManifest {
+	data, err := os.ReadFile(filepath.Join(h.snapshotDir, entities.SnapshotManifestFilename(filename)))
+	if err != nil {
+		return nil
+	}
+
+	var manifest entities.SnapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		log.Printf("Warning: Invalid manifest for %s: %v", filename, err)
+		return nil
+	}
+	return &manifest
 }
 
 // isValidFilename validates that filename matches expected pattern
@@ -0,0 +1,44 @@
+package apiHandlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsHandler exposes Prometheus metrics
+type MetricsHandler struct {
+	token string
+}
+
+// NewMetricsHandler creates a new metrics handler, an empty token leaves the endpoint open
+func NewMetricsHandler(token string) *MetricsHandler {
+	return &MetricsHandler{
+		token: token,
+	}
+}
+
+// SetupRoutes adds the metrics endpoint to the router
+func (h *MetricsHandler) SetupRoutes(e *core.ServeEvent) {
+	promHandler := apis.WrapStdHandler(promhttp.Handler())
+
+	e.Router.GET("/metrics", func(re *core.RequestEvent) error {
+		if !h.isAuthorized(re) {
+			return re.String(http.StatusUnauthorized, "Unauthorized")
+		}
+		return promHandler(re)
+	})
+}
+
+// isAuthorized checks the scraper's bearer token when one is configured
+func (h *MetricsHandler) isAuthorized(re *core.RequestEvent) bool {
+	if h.token == "" {
+		return true
+	}
+
+	expected := "Bearer " + h.token
+	return subtle.ConstantTimeCompare([]byte(re.Request.Header.Get("Authorization")), []byte(expected)) == 1
+}
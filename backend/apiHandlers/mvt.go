@@ -1,7 +1,6 @@
 package apiHandlers
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -66,19 +65,29 @@ func (h *MVTHandler) handleMVTRequestWithPath(re *core.RequestEvent) error {
 	coords := entities.TileCoordinates{X: x, Y: y, Z: z}
 
 	// Get tile from cache (handles generation logic internally)
-	data, err := h.cache.GetTile(context.Background(), coords)
+	data, status, err := h.cache.ServeTile(re.Request.Context(), coords)
 	if err != nil {
+		if re.Request.Context().Err() != nil {
+			// Client disconnected, nothing to write
+			return nil
+		}
 		re.Response.WriteHeader(http.StatusBadRequest)
 		re.Response.Write([]byte(err.Error()))
 		return nil
 	}
 
+	stale := status == interfaces.TileInvalidated
+	if stale {
+		// Tile is being regenerated, tell clients and caches not to keep it long
+		re.Response.Header().Set("X-Tile-Stale", "true")
+	}
+
 	if len(data) == 0 {
 		re.Response.WriteHeader(http.StatusNoContent)
 		return nil
 	}
 
-	h.setMVTHeaders(re)
+	h.setMVTHeaders(re, stale)
 	re.Response.WriteHeader(http.StatusOK)
 	re.Response.Write(data)
 	return nil
@@ -120,10 +129,14 @@ func (h *MVTHandler) setCORSHeaders(re *core.RequestEvent) {
 }
 
 // setMVTHeaders sets MVT-specific headers including cache control
-func (h *MVTHandler) setMVTHeaders(re *core.RequestEvent) {
+func (h *MVTHandler) setMVTHeaders(re *core.RequestEvent, stale bool) {
 	// Set standard MVT headers
 	re.Response.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
 
 	// Set proper caching headers for tiles
+	if stale {
+		re.Response.Header().Set("Cache-Control", "public, max-age=10") // Refetch soon, regeneration in progress
+		return
+	}
 	re.Response.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
 }
@@ -0,0 +1,96 @@
+package apiHandlers
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"bike-map/entities"
+)
+
+// parseBBoxParam parses a "west,south,east,north" WGS84 bounding box query parameter
+func parseBBoxParam(value string) (*entities.BoundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be west,south,east,north")
+	}
+
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bbox must be west,south,east,north")
+		}
+		v[i] = f
+	}
+
+	bbox := &entities.BoundingBox{West: v[0], South: v[1], East: v[2], North: v[3]}
+	if bbox.West < -180 || bbox.East > 180 || bbox.South < -90 || bbox.North > 90 ||
+		bbox.West >= bbox.East || bbox.South >= bbox.North {
+		return nil, fmt.Errorf("bbox is out of range or empty")
+	}
+	return bbox, nil
+}
+
+// parseIDListParam parses a comma separated list of record IDs, without empty or duplicate entries
+func parseIDListParam(value string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// parseFloatParam parses an optional number query parameter, nil when absent
+func parseFloatParam(query url.Values, name string) (*float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%s must be a number", name)
+	}
+	return &f, nil
+}
+
+// parsePageParams parses the limit and offset query parameters of a paginated list
+func parsePageParams(query url.Values, defaultLimit, maxLimit int) (limit, offset int, err error) {
+	limit = defaultLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+// parsePointParams parses the optional lat and lng query parameters, nil when both are absent
+func parsePointParams(query url.Values) (*entities.GeoPoint, error) {
+	if query.Get("lat") == "" && query.Get("lng") == "" {
+		return nil, nil
+	}
+
+	lat, errLat := strconv.ParseFloat(query.Get("lat"), 64)
+	lng, errLng := strconv.ParseFloat(query.Get("lng"), 64)
+	if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil, fmt.Errorf("lat and lng must be a WGS84 position")
+	}
+	return &entities.GeoPoint{Lat: lat, Lng: lng}, nil
+}
@@ -0,0 +1,84 @@
+package apiHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bike-map/interfaces"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// tileEventsHeartbeat keeps idle streams alive through proxies
+const tileEventsHeartbeat = 25 * time.Second
+
+// TileEventsHandler streams tile invalidation events to map clients over Server-Sent Events
+type TileEventsHandler struct {
+	events interfaces.TileEventSource
+}
+
+// NewTileEventsHandler creates a new tile events handler
+func NewTileEventsHandler(events interfaces.TileEventSource) *TileEventsHandler {
+	return &TileEventsHandler{
+		events: events,
+	}
+}
+
+// SetupRoutes adds the tile event stream to the router
+func (h *TileEventsHandler) SetupRoutes(e *core.ServeEvent) {
+	e.Router.GET("/api/tiles/events", h.HandleEvents)
+}
+
+// HandleEvents streams tile events until the client disconnects. Tile events list the tiles,
+// or carry their bbox when a batch is too large to list.
+// Events are not replayed: a client that reconnects should reload its tiles.
+func (h *TileEventsHandler) HandleEvents(re *core.RequestEvent) error {
+	events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	// The stream outlives the server write timeout
+	_ = http.NewResponseController(re.Response).SetWriteDeadline(time.Time{})
+
+	header := re.Response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	header.Set("Access-Control-Allow-Origin", "*")
+	re.Response.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(re.Response, "retry: 3000\n\n")
+	if err := re.Flush(); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(tileEventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-re.Request.Context().Done():
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				// Disconnected by the broker (client too slow or server stopping)
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(re.Response, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+
+		case <-heartbeat.C:
+			fmt.Fprint(re.Response, ": ping\n\n")
+		}
+
+		if err := re.Flush(); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package apiHandlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Trail search limits
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchTerms     = 8
+	maxSearchLength    = 200
+)
+
+// Trail area query limits
+const (
+	defaultAreaLimit    = 50
+	maxAreaLimit        = 200
+	defaultNearbyRadius = 10000  // Meters
+	maxNearbyRadius     = 100000 // Meters
+)
+
+// Elevation profile points returned with trail details
+const (
+	defaultProfilePoints = 200
+	maxProfilePoints     = 1000
+)
+
+// trailLevels are the accepted values of the level filter
+var trailLevels = map[string]bool{
+	string(entities.LevelS0): true,
+	string(entities.LevelS1): true,
+	string(entities.LevelS2): true,
+	string(entities.LevelS3): true,
+	string(entities.LevelS4): true,
+	string(entities.LevelS5): true,
+}
+
+// TrailsHandler serves trail details and the trail queries answered by PostGIS:
+// full-text search and queries by location
+type TrailsHandler struct {
+	details interfaces.TrailDetails
+	live    atomic.Pointer[trailQueries] // Set once PostGIS is reachable
+}
+
+// trailQueries holds the services that need PostGIS
+type trailQueries struct {
+	search  interfaces.TrailSearch
+	spatial interfaces.TrailSpatial
+}
+
+// NewTrailsHandler creates a new trails handler. Query endpoints answer 503 until SetTrailQueries is called.
+func NewTrailsHandler(details interfaces.TrailDetails) *TrailsHandler {
+	return &TrailsHandler{
+		details: details,
+	}
+}
+
+// SetTrailQueries enables the endpoints once PostGIS is reachable
+func (h *TrailsHandler) SetTrailQueries(search interfaces.TrailSearch, spatial interfaces.TrailSpatial) {
+	h.live.Store(&trailQueries{search: search, spatial: spatial})
+}
+
+// SetupRoutes adds trail query endpoints to the router
+func (h *TrailsHandler) SetupRoutes(e *core.ServeEvent) {
+	e.Router.GET("/api/search/trails", h.HandleSearch).BindFunc(h.requireTrailQueries)
+	e.Router.GET("/api/trails", h.HandleInBBox).BindFunc(h.requireTrailQueries)
+	e.Router.GET("/api/trails/nearby", h.HandleNearby).BindFunc(h.requireTrailQueries)
+	e.Router.GET("/api/trails/{trailId}/details", h.HandleDetails)
+}
+
+// requireTrailQueries rejects requests while PostGIS is unavailable
+func (h *TrailsHandler) requireTrailQueries(re *core.RequestEvent) error {
+	if h.live.Load() == nil {
+		return re.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Trail queries unavailable, PostGIS is not connected"})
+	}
+	return re.Next()
+}
+
+// HandleDetails returns a trail record with its computed stats, elevation profile (at most
+// profile_points points, default 200), rating distribution and comment count
+func (h *TrailsHandler) HandleDetails(re *core.RequestEvent) error {
+	profilePoints := defaultProfilePoints
+	if v := re.Request.URL.Query().Get("profile_points"); v != "" {
+		var err error
+		profilePoints, err = strconv.Atoi(v)
+		if err != nil || profilePoints < 3 || profilePoints > maxProfilePoints {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("profile_points must be between 3 and %d", maxProfilePoints)})
+		}
+	}
+
+	details, err := h.details.GetTrailDetails(re.Request.Context(), re.Request.PathValue("trailId"), profilePoints)
+	if errors.Is(err, interfaces.ErrTrailNotFound) {
+		return re.JSON(http.StatusNotFound, map[string]string{"error": "Trail not found"})
+	}
+	if err != nil {
+		log.Printf("Failed to get trail details: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get trail details"})
+	}
+
+	return re.JSON(http.StatusOK, details)
+}
+
+// HandleSearch searches trails by name, tags and description, best matches first.
+// Query parameters: q (search text, the last word matched as a prefix), level (comma separated),
+// min_distance/max_distance (m), min_gain/max_gain (elevation gain in m), min_rating, bbox,
+// limit and offset. Without q, at least one filter is required and matches are sorted by name.
+func (h *TrailsHandler) HandleSearch(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+
+	text := query.Get("q")
+	if len(text) > maxSearchLength {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "q is too long"})
+	}
+
+	filters, err := parseTrailFilters(query)
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	search := entities.TrailSearchQuery{Terms: searchTerms(text), TrailFilters: *filters}
+	if len(search.Terms) == 0 && !search.HasFilters() {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "q or a filter is required"})
+	}
+
+	search.Limit, search.Offset, err = parsePageParams(query, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	results, err := h.live.Load().search.SearchTrails(re.Request.Context(), search)
+	if err != nil {
+		log.Printf("Failed to search trails: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to search trails"})
+	}
+
+	return re.JSON(http.StatusOK, results)
+}
+
+// HandleNearby lists the trails passing within radius meters (default 10 km) of lat/lng, nearest
+// first. sort=trailhead sorts by the distance to the trailhead instead. Accepts the search filters,
+// limit and offset.
+func (h *TrailsHandler) HandleNearby(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+
+	point, err := parsePointParams(query)
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if point == nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "lat and lng are required"})
+	}
+
+	area := entities.TrailAreaQuery{Point: point, RadiusM: defaultNearbyRadius}
+	radius, err := parseFloatParam(query, "radius")
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if radius != nil {
+		if *radius <= 0 || *radius > maxNearbyRadius {
+			return re.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("radius must be between 0 and %d meters", maxNearbyRadius)})
+		}
+		area.RadiusM = *radius
+	}
+
+	switch query.Get("sort") {
+	case "", "distance":
+	case "trailhead":
+		area.SortByTrailhead = true
+	default:
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "sort must be distance or trailhead"})
+	}
+
+	return h.findTrails(re, area)
+}
+
+// HandleInBBox lists the trails intersecting bbox, by name. With lat/lng, results carry their
+// distance to that point and are sorted nearest first. Accepts the search filters, limit and offset.
+func (h *TrailsHandler) HandleInBBox(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+	if query.Get("bbox") == "" {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": "bbox is required"})
+	}
+
+	point, err := parsePointParams(query)
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return h.findTrails(re, entities.TrailAreaQuery{Point: point})
+}
+
+// findTrails completes an area query with the filters and page of the request, and runs it
+func (h *TrailsHandler) findTrails(re *core.RequestEvent, area entities.TrailAreaQuery) error {
+	query := re.Request.URL.Query()
+
+	filters, err := parseTrailFilters(query)
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	area.TrailFilters = *filters
+
+	area.Limit, area.Offset, err = parsePageParams(query, defaultAreaLimit, maxAreaLimit)
+	if err != nil {
+		return re.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	results, err := h.live.Load().spatial.FindTrails(re.Request.Context(), area)
+	if err != nil {
+		log.Printf("Failed to find trails: %v", err)
+		return re.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to find trails"})
+	}
+
+	return re.JSON(http.StatusOK, results)
+}
+
+// searchTerms splits search text into lowercase words, dropping punctuation and
+// text search operators
+func searchTerms(text string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// parseTrailFilters parses the level, distance, elevation gain, rating and bbox filters
+func parseTrailFilters(query url.Values) (*entities.TrailFilters, error) {
+	filters := &entities.TrailFilters{}
+
+	if v := query.Get("level"); v != "" {
+		for _, level := range strings.Split(v, ",") {
+			level = strings.ToUpper(strings.TrimSpace(level))
+			if !trailLevels[level] {
+				return nil, fmt.Errorf("level must be a comma separated list of S0 to S5")
+			}
+			filters.Levels = append(filters.Levels, level)
+		}
+	}
+
+	var err error
+	numbers := []struct {
+		name   string
+		target **float64
+	}{
+		{"min_distance", &filters.MinDistance},
+		{"max_distance", &filters.MaxDistance},
+		{"min_gain", &filters.MinGain},
+		{"max_gain", &filters.MaxGain},
+		{"min_rating", &filters.MinRating},
+	}
+	for _, n := range numbers {
+		if *n.target, err = parseFloatParam(query, n.name); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("bbox"); v != "" {
+		if filters.BBox, err = parseBBoxParam(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return filters, nil
+}
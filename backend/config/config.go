@@ -1,24 +1,91 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/cron"
 )
 
 // Config holds all application configuration
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	OAuth    OAuthConfig
-	Admin    AdminConfig
-	MBTiles  MBTilesConfig
+	Server     ServerConfig
+	Database   DatabaseConfig
+	OAuth      OAuthConfig
+	Admin      AdminConfig
+	MBTiles    MBTilesConfig
+	Tiles      TilesConfig
+	Metrics    MetricsConfig
+	Sync       SyncConfig
+	Reconciler ReconcilerConfig
+	Cluster    ClusterConfig
+}
+
+// ClusterConfig holds multi-instance coordination configuration
+type ClusterConfig struct {
+	Enabled    bool   // Share tile invalidations over PostgreSQL LISTEN/NOTIFY and elect a snapshot leader
+	InstanceID string // Identifies this instance in notifications and logs
+}
+
+// ReconcilerConfig holds PocketBase/PostGIS consistency reconciler configuration
+type ReconcilerConfig struct {
+	Schedule   string // Cron expression for scheduled runs (empty = disabled)
+	AutoRepair bool   // Repair drift found by scheduled runs
+}
+
+// SyncConfig holds PocketBase → PostGIS sync outbox and change log configuration
+type SyncConfig struct {
+	PollIntervalSeconds int // How often the outbox worker looks for due events
+	MaxAttempts         int // Attempts before an event is dead-lettered
+	BaseBackoffSeconds  int // Delay before the first retry, doubled on each attempt
+	MaxBackoffSeconds   int // Upper bound of the retry delay
+
+	ChangeLogRetentionDays int // Days change feed entries are kept, 0 to keep them forever
+}
+
+// MetricsConfig holds Prometheus metrics endpoint configuration
+type MetricsConfig struct {
+	Token string // Bearer token required to scrape /metrics (empty = open)
+}
+
+// TilesConfig holds tile serving configuration
+type TilesConfig struct {
+	ServeStale            bool // Serve invalidated tiles immediately and regenerate in the background
+	RequestTimeoutSeconds int  // Max time a request waits for a tile that was never generated
+	Workers               int  // Number of concurrent tile generation workers
+	BackgroundQueueSize   int  // Max distinct tiles pending background generation
+	QueryTimeoutSeconds   int  // Statement timeout for a single tile generation query
 }
 
 // MBTilesConfig holds MBTiles backup storage configuration
 type MBTilesConfig struct {
-	Path                  string // Directory for snapshots (not full file path)
-	SnapshotStableSeconds int
+	Path                   string // Directory for snapshots (not full file path)
+	SnapshotStableSeconds  int
+	PMTiles                bool   // Also export snapshots as a PMTiles archive
+	Regions                string // Named extract regions: "name:west,south,east,north;..."
+	ExtractCacheSize       int    // Regional extracts kept on disk for repeated downloads
+	ExtractCacheMaxMB      int    // Total size of the extracts, deltas and bundles kept on disk
+	RetentionKeep          int    // Snapshots kept on disk, 0 for no limit
+	RetentionMaxAgeMinutes int    // Minutes after which older snapshots are removed, 0 for no limit
+	DownloadAccess         string // Who may download snapshots and extracts, see DownloadAccess* constants
+	DownloadSigningKey     string // HMAC key of signed download URLs
+	DownloadURLTTLSeconds  int    // Validity of signed download URLs
+	DownloadRateLimit      int    // Downloads started per user and hour, 0 for no limit
+}
+
+// Download access modes
+const (
+	DownloadAccessPublic = "public" // Anyone
+	DownloadAccessAuth   = "auth"   // Authenticated users
+	DownloadAccessSigned = "signed" // Authenticated users and holders of a signed expiring URL
+)
+
+// Region is a named WGS84 bounding box for regional extracts
+type Region struct {
+	West, South, East, North float64
 }
 
 // ServerConfig holds server-related configuration
@@ -76,12 +143,57 @@ func Load() *Config {
 			Password: getEnv("ADMIN_PASSWORD", ""),
 		},
 		MBTiles: MBTilesConfig{
-			Path:                  getEnv("MBTILES_PATH", "./data"),
-			SnapshotStableSeconds: getEnvInt("MBTILES_SNAPSHOT_STABLE_SECONDS", 30),
+			Path:                   getEnv("MBTILES_PATH", "./data"),
+			SnapshotStableSeconds:  getEnvInt("MBTILES_SNAPSHOT_STABLE_SECONDS", 30),
+			PMTiles:                getEnvBool("MBTILES_PMTILES_ENABLED", false),
+			Regions:                getEnv("MBTILES_REGIONS", ""),
+			ExtractCacheSize:       getEnvInt("MBTILES_EXTRACT_CACHE_SIZE", 50),
+			ExtractCacheMaxMB:      getEnvInt("MBTILES_EXTRACT_CACHE_MAX_MB", 2048),
+			RetentionKeep:          getEnvInt("MBTILES_RETENTION_KEEP", 0),
+			RetentionMaxAgeMinutes: getEnvInt("MBTILES_RETENTION_MAX_AGE_MINUTES", 15),
+			DownloadAccess:         getEnv("MBTILES_DOWNLOAD_ACCESS", DownloadAccessPublic),
+			DownloadSigningKey:     getEnv("MBTILES_DOWNLOAD_SIGNING_KEY", ""),
+			DownloadURLTTLSeconds:  getEnvInt("MBTILES_DOWNLOAD_URL_TTL_SECONDS", 3600),
+			DownloadRateLimit:      getEnvInt("MBTILES_DOWNLOAD_RATE_LIMIT", 0),
+		},
+		Tiles: TilesConfig{
+			ServeStale:            getEnvBool("TILES_SERVE_STALE", true),
+			RequestTimeoutSeconds: getEnvInt("TILES_REQUEST_TIMEOUT_SECONDS", 5),
+			Workers:               getEnvInt("TILES_WORKERS", 8),
+			BackgroundQueueSize:   getEnvInt("TILES_BACKGROUND_QUEUE_SIZE", 1000000),
+			QueryTimeoutSeconds:   getEnvInt("TILES_QUERY_TIMEOUT_SECONDS", 10),
+		},
+		Metrics: MetricsConfig{
+			Token: getEnv("METRICS_TOKEN", ""),
+		},
+		Sync: SyncConfig{
+			PollIntervalSeconds: getEnvInt("SYNC_OUTBOX_POLL_SECONDS", 5),
+			MaxAttempts:         getEnvInt("SYNC_OUTBOX_MAX_ATTEMPTS", 10),
+			BaseBackoffSeconds:  getEnvInt("SYNC_OUTBOX_BASE_BACKOFF_SECONDS", 5),
+			MaxBackoffSeconds:   getEnvInt("SYNC_OUTBOX_MAX_BACKOFF_SECONDS", 3600),
+
+			ChangeLogRetentionDays: getEnvInt("CHANGE_LOG_RETENTION_DAYS", 30),
+		},
+		Reconciler: ReconcilerConfig{
+			Schedule:   getEnv("RECONCILER_SCHEDULE", "*/30 * * * *"),
+			AutoRepair: getEnvBool("RECONCILER_AUTO_REPAIR", false),
+		},
+		Cluster: ClusterConfig{
+			Enabled:    getEnvBool("CLUSTER_ENABLED", false),
+			InstanceID: getEnv("INSTANCE_ID", defaultInstanceID()),
 		},
 	}
 }
 
+// defaultInstanceID identifies the process by host name and PID
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "bikemap"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 // getEnv gets an environment variable with a fallback default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -101,8 +213,105 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvBool gets a boolean environment variable with a fallback default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+		log.Printf("Warning: Invalid boolean value for %s: %s, using default %t", key, value, defaultValue)
+	}
+	return defaultValue
+}
+
 // Validate checks if required configuration values are present
 func (c *Config) Validate() error {
-	// Add validation logic if needed
+	if c.Tiles.RequestTimeoutSeconds <= 0 {
+		return fmt.Errorf("TILES_REQUEST_TIMEOUT_SECONDS must be positive, got %d", c.Tiles.RequestTimeoutSeconds)
+	}
+	if c.Tiles.QueryTimeoutSeconds <= 0 {
+		return fmt.Errorf("TILES_QUERY_TIMEOUT_SECONDS must be positive, got %d", c.Tiles.QueryTimeoutSeconds)
+	}
+	if c.Tiles.Workers <= 0 {
+		return fmt.Errorf("TILES_WORKERS must be positive, got %d", c.Tiles.Workers)
+	}
+	if c.Sync.PollIntervalSeconds <= 0 {
+		return fmt.Errorf("SYNC_OUTBOX_POLL_SECONDS must be positive, got %d", c.Sync.PollIntervalSeconds)
+	}
+	if c.Sync.MaxAttempts <= 0 {
+		return fmt.Errorf("SYNC_OUTBOX_MAX_ATTEMPTS must be positive, got %d", c.Sync.MaxAttempts)
+	}
+	if c.Sync.BaseBackoffSeconds <= 0 || c.Sync.MaxBackoffSeconds < c.Sync.BaseBackoffSeconds {
+		return fmt.Errorf("SYNC_OUTBOX_BASE_BACKOFF_SECONDS must be positive and not exceed SYNC_OUTBOX_MAX_BACKOFF_SECONDS")
+	}
+	if c.Sync.ChangeLogRetentionDays < 0 {
+		return fmt.Errorf("CHANGE_LOG_RETENTION_DAYS must not be negative, got %d", c.Sync.ChangeLogRetentionDays)
+	}
+	if c.MBTiles.ExtractCacheSize <= 0 {
+		return fmt.Errorf("MBTILES_EXTRACT_CACHE_SIZE must be positive, got %d", c.MBTiles.ExtractCacheSize)
+	}
+	if c.MBTiles.ExtractCacheMaxMB <= 0 {
+		return fmt.Errorf("MBTILES_EXTRACT_CACHE_MAX_MB must be positive, got %d", c.MBTiles.ExtractCacheMaxMB)
+	}
+	if c.MBTiles.RetentionKeep < 0 || c.MBTiles.RetentionMaxAgeMinutes < 0 {
+		return fmt.Errorf("MBTILES_RETENTION_KEEP and MBTILES_RETENTION_MAX_AGE_MINUTES must not be negative")
+	}
+	switch c.MBTiles.DownloadAccess {
+	case DownloadAccessPublic, DownloadAccessAuth:
+	case DownloadAccessSigned:
+		if len(c.MBTiles.DownloadSigningKey) < 32 {
+			return fmt.Errorf("MBTILES_DOWNLOAD_SIGNING_KEY must be at least 32 characters for signed downloads")
+		}
+	default:
+		return fmt.Errorf("MBTILES_DOWNLOAD_ACCESS must be one of public, auth or signed, got %q", c.MBTiles.DownloadAccess)
+	}
+	if c.MBTiles.DownloadURLTTLSeconds <= 0 {
+		return fmt.Errorf("MBTILES_DOWNLOAD_URL_TTL_SECONDS must be positive, got %d", c.MBTiles.DownloadURLTTLSeconds)
+	}
+	if c.MBTiles.DownloadRateLimit < 0 {
+		return fmt.Errorf("MBTILES_DOWNLOAD_RATE_LIMIT must not be negative, got %d", c.MBTiles.DownloadRateLimit)
+	}
+	if _, err := ParseRegions(c.MBTiles.Regions); err != nil {
+		return fmt.Errorf("MBTILES_REGIONS is invalid: %w", err)
+	}
+	if c.Reconciler.Schedule != "" {
+		if _, err := cron.NewSchedule(c.Reconciler.Schedule); err != nil {
+			return fmt.Errorf("RECONCILER_SCHEDULE is not a valid cron expression: %w", err)
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// ParseRegions parses named regions ("name:west,south,east,north;..."), keyed by lowercase name
+func ParseRegions(spec string) (map[string]Region, error) {
+	regions := make(map[string]Region)
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, coords, ok := strings.Cut(item, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		parts := strings.Split(coords, ",")
+		if !ok || name == "" || len(parts) != 4 {
+			return nil, fmt.Errorf("expected name:west,south,east,north, got %q", item)
+		}
+
+		var values [4]float64
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid coordinate in region %q: %w", name, err)
+			}
+			values[i] = v
+		}
+
+		region := Region{West: values[0], South: values[1], East: values[2], North: values[3]}
+		if region.West >= region.East || region.South >= region.North {
+			return nil, fmt.Errorf("region %q has an empty bounding box", name)
+		}
+		regions[name] = region
+	}
+	return regions, nil
+}
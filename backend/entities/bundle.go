@@ -0,0 +1,96 @@
+package entities
+
+import "time"
+
+// OfflineBundleFormatVersion is the layout version of offline bundles, bumped on incompatible changes
+const OfflineBundleFormatVersion = 1
+
+// OfflineBundleRequest selects the area of an offline bundle: a bounding box, a named region
+// or a list of trails
+type OfflineBundleRequest struct {
+	BBox     *BoundingBox
+	Region   string
+	TrailIDs []string
+	MaxZoom  int // Maximum zoom of the tiles, 0 for the backup's maximum
+}
+
+// OfflineBundle is a built offline bundle ZIP
+type OfflineBundle struct {
+	Path     string // Local path of the cached ZIP
+	Filename string // Download file name
+	Manifest *OfflineBundleManifest
+	Cached   bool
+}
+
+// OfflineBundleManifest is the manifest.json of an offline bundle
+type OfflineBundleManifest struct {
+	FormatVersion int                 `json:"format_version"`
+	Version       string              `json:"version"` // Changes whenever any content of the bundle changes
+	CreatedAt     time.Time           `json:"created_at"`
+	Region        string              `json:"region,omitempty"`
+	BBox          BoundingBox         `json:"bbox"`
+	MaxZoom       int                 `json:"max_zoom"`
+	Snapshot      string              `json:"snapshot"`                // Snapshot the tiles were extracted from
+	JournalEpoch  string              `json:"journal_epoch,omitempty"` // Tile deltas can be requested from this position
+	JournalSeq    int64               `json:"journal_seq,omitempty"`
+	TrailCount    int                 `json:"trail_count"`
+	CommentCount  int                 `json:"comment_count"`
+	Files         []OfflineBundleFile `json:"files"`
+}
+
+// OfflineBundleFile describes a file of an offline bundle
+type OfflineBundleFile struct {
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+// TrailComment is a comment with its author's display name, as shipped in offline bundles
+type TrailComment struct {
+	ID       string    `json:"id"`
+	TrailID  string    `json:"trail_id"`
+	UserID   string    `json:"user_id"`
+	UserName string    `json:"user_name"`
+	Comment  string    `json:"comment"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// OfflineTrailProperties are the GeoJSON properties of a trail in offline bundles
+type OfflineTrailProperties struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Description   string         `json:"description"`
+	Level         string         `json:"level"`
+	Tags          []string       `json:"tags"`
+	OwnerID       string         `json:"owner_id"`
+	Ridden        bool           `json:"ridden"`
+	Created       time.Time      `json:"created"`
+	Updated       time.Time      `json:"updated"`
+	GPXFile       string         `json:"gpx_file"` // Path of the GPX file within the bundle
+	DistanceM     float64        `json:"distance_m"`
+	Elevation     *ElevationData `json:"elevation"`
+	RatingAverage float64        `json:"rating_average"`
+	RatingCount   int            `json:"rating_count"`
+	CommentCount  int            `json:"comment_count"`
+}
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature
+type GeoJSONFeature struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id,omitempty"`
+	Geometry   GeoJSONGeometry `json:"geometry"`
+	Properties any             `json:"properties"`
+}
+
+// GeoJSONGeometry is a GeoJSON geometry ([lng, lat] or [lng, lat, elevation] positions)
+type GeoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
@@ -0,0 +1,22 @@
+package entities
+
+import "time"
+
+// ChangeEntry is a trail, rating or comment change in the change feed
+type ChangeEntry struct {
+	Seq        int64          `json:"seq"`
+	EntityType string         `json:"entity_type"` // trail, rating, comment
+	Action     string         `json:"action"`      // created, updated, deleted
+	TrailID    string         `json:"trail_id"`
+	RecordID   string         `json:"record_id"`
+	ChangedAt  time.Time      `json:"changed_at"`
+	Record     map[string]any `json:"record,omitempty"` // Current record, omitted for tombstones (deleted)
+}
+
+// ChangeFeed is a batch of changes after a cursor. Each record appears at most once,
+// with its latest change in the batch.
+type ChangeFeed struct {
+	Cursor  int64         `json:"cursor"`   // Pass as since to get the next batch
+	HasMore bool          `json:"has_more"` // More changes are waiting after the cursor
+	Changes []ChangeEntry `json:"changes"`
+}
@@ -0,0 +1,42 @@
+package entities
+
+// TrailGeometry is the geometry-derived data of a trail stored in the generator
+type TrailGeometry struct {
+	DistanceM  float64
+	BBox       BoundingBox
+	Start      GeoPoint
+	End        GeoPoint
+	PointCount int
+	Elevation  *ElevationData // Nil when the GPX file has no elevation
+}
+
+// TrailStats are the computed stats of a trail, as found in its MVT feature attributes
+type TrailStats struct {
+	DistanceM      float64     `json:"distance_m"`
+	BBox           BoundingBox `json:"bbox"`
+	Start          GeoPoint    `json:"start"`
+	End            GeoPoint    `json:"end"`
+	PointCount     int         `json:"point_count"`
+	ElevationGain  float64     `json:"elevation_gain_m"`
+	ElevationLoss  float64     `json:"elevation_loss_m"`
+	MinElevation   *float64    `json:"min_elevation_m"` // Nil without elevation profile
+	MaxElevation   *float64    `json:"max_elevation_m"`
+	StartElevation *float64    `json:"start_elevation_m"`
+	EndElevation   *float64    `json:"end_elevation_m"`
+}
+
+// RatingSummary is the rating average of a trail with the number of ratings per star
+type RatingSummary struct {
+	Average      float64     `json:"average"`
+	Count        int         `json:"count"`
+	Distribution map[int]int `json:"distribution"` // Stars (1-5) to number of ratings
+}
+
+// TrailDetails combines a trail record with its computed stats and engagement
+type TrailDetails struct {
+	Record           map[string]any   `json:"record"`
+	Stats            *TrailStats      `json:"stats"` // Nil until the trail is synced, or while PostGIS is unavailable
+	ElevationProfile []ElevationPoint `json:"elevation_profile"`
+	Ratings          RatingSummary    `json:"ratings"`
+	CommentCount     int              `json:"comment_count"`
+}
@@ -31,10 +31,3 @@ type EngagementStats struct {
 	RatingAvg    float64 `json:"rating_average"`
 	CommentCount int     `json:"comment_count"`
 }
-
-// EngagementStatsData contains engagement statistics for updating
-type EngagementStatsData struct {
-	RatingAvg    float64
-	RatingCount  int
-	CommentCount int
-}
\ No newline at end of file
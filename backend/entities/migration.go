@@ -0,0 +1,13 @@
+package entities
+
+import "time"
+
+// SchemaMigrationStatus describes a PostGIS schema migration and whether it is applied
+type SchemaMigrationStatus struct {
+	Version          int        `json:"version"`
+	Name             string     `json:"name"`
+	Applied          bool       `json:"applied"`
+	AppliedAt        *time.Time `json:"applied_at,omitempty"`
+	ChecksumMismatch bool       `json:"checksum_mismatch"` // Embedded file changed after it was applied
+	Unknown          bool       `json:"unknown"`           // Applied, but not embedded in this build
+}
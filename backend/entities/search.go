@@ -0,0 +1,91 @@
+package entities
+
+// TrailFilters holds the attribute and area filters shared by trail queries.
+// Nil filters are not applied.
+type TrailFilters struct {
+	Levels      []string
+	MinDistance *float64 // Meters
+	MaxDistance *float64
+	MinGain     *float64 // Elevation gain in meters
+	MaxGain     *float64
+	MinRating   *float64
+	BBox        *BoundingBox // Trails whose bounding box intersects it
+
+	// Rating averages by trail ID from the PocketBase rating_average records, which PostGIS
+	// does not hold. Required by MinRating and used to order equally ranked search results.
+	RatingAverages map[string]float64
+}
+
+// HasFilters reports whether any filter is set
+func (f TrailFilters) HasFilters() bool {
+	return len(f.Levels) > 0 || f.MinDistance != nil || f.MaxDistance != nil ||
+		f.MinGain != nil || f.MaxGain != nil || f.MinRating != nil || f.BBox != nil
+}
+
+// TrailSearchQuery holds the search text and filters of a trail search
+type TrailSearchQuery struct {
+	Terms []string // Search words, the last one matched as a prefix; empty to filter only
+	TrailFilters
+	Limit  int
+	Offset int
+}
+
+// TrailSearchResult is a trail matching a search, with its bounding box for the client to fly to
+type TrailSearchResult struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Level         string       `json:"level"`
+	Tags          []string     `json:"tags"`
+	DistanceM     float64      `json:"distance_m"`
+	ElevationGain float64      `json:"elevation_gain_m"`
+	ElevationLoss float64      `json:"elevation_loss_m"`
+	RatingAverage float64      `json:"rating_average"` // From PocketBase
+	RatingCount   int          `json:"rating_count"`
+	Ridden        bool         `json:"ridden"`
+	Rank          float64      `json:"rank"`
+	BBox          *BoundingBox `json:"bbox"`
+}
+
+// TrailSearchResults is a page of search results, best matches first
+type TrailSearchResults struct {
+	Total   int                 `json:"total"` // Matches across all pages
+	Results []TrailSearchResult `json:"results"`
+}
+
+// GeoPoint is a WGS84 position
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// TrailAreaQuery selects trails within a radius of a point, or within the filters' bbox.
+// With a point, results carry their distance to it and are sorted by that distance.
+type TrailAreaQuery struct {
+	Point           *GeoPoint
+	RadiusM         float64 // Max distance between the point and the trail, 0 for no limit
+	SortByTrailhead bool    // Sort by the distance to the trailhead instead of the nearest point
+	TrailFilters
+	Limit  int
+	Offset int
+}
+
+// TrailSummary is the compact representation of a trail returned by area queries
+type TrailSummary struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Level                string    `json:"level"`
+	DistanceM            float64   `json:"distance_m"`
+	ElevationGain        float64   `json:"elevation_gain_m"`
+	RatingAverage        float64   `json:"rating_average"` // From PocketBase
+	RatingCount          int       `json:"rating_count"`
+	Trailhead            *GeoPoint `json:"trailhead"`
+	DistanceToTrailM     *float64  `json:"distance_to_trail_m,omitempty"`     // From the query point to NearestPoint
+	DistanceToTrailheadM *float64  `json:"distance_to_trailhead_m,omitempty"` // From the query point to the trailhead
+	NearestPoint         *GeoPoint `json:"nearest_point,omitempty"`           // Point of the trail closest to the query point
+}
+
+// TrailSummaries is a page of area query results
+type TrailSummaries struct {
+	Total   int            `json:"total"` // Matches across all pages
+	Results []TrailSummary `json:"results"`
+}
@@ -0,0 +1,34 @@
+package entities
+
+import (
+	"strings"
+	"time"
+)
+
+// SnapshotSource describes the trail data the tiles of a snapshot were generated from
+type SnapshotSource struct {
+	Bounds        *BoundingBox // Trails extent, nil when there are no trails
+	TrailCount    int
+	TrailRevision string // Fingerprint of the sync hashes of all trails
+}
+
+// SnapshotManifest is written next to each complete snapshot (bikemap-<timestamp>.json)
+type SnapshotManifest struct {
+	Filename      string       `json:"filename"`
+	CreatedAt     time.Time    `json:"created_at"`
+	SizeBytes     int64        `json:"size_bytes"`
+	SHA256        string       `json:"sha256"`
+	TileCount     int          `json:"tile_count"`
+	MinZoom       int          `json:"min_zoom"`
+	MaxZoom       int          `json:"max_zoom"`
+	Bounds        *BoundingBox `json:"bounds"`
+	TrailCount    int          `json:"trail_count"`
+	TrailRevision string       `json:"trail_revision"`
+	JournalEpoch  string       `json:"journal_epoch"` // Change journal position, see /api/mbtiles/delta
+	JournalSeq    int64        `json:"journal_seq"`
+}
+
+// SnapshotManifestFilename returns the manifest file name of a snapshot file name
+func SnapshotManifestFilename(snapshotFilename string) string {
+	return strings.TrimSuffix(snapshotFilename, ".mbtiles") + ".json"
+}
@@ -0,0 +1,94 @@
+package entities
+
+import "time"
+
+// Sync event entity types
+const (
+	SyncEntityTrail   = "trail"
+	SyncEntityRating  = "rating"
+	SyncEntityComment = "comment"
+)
+
+// Sync event actions
+const (
+	SyncActionCreated = "created"
+	SyncActionUpdated = "updated"
+	SyncActionDeleted = "deleted"
+)
+
+// Sync event statuses
+const (
+	SyncStatusPending = "pending" // Waiting for its first or next attempt
+	SyncStatusDead    = "dead"    // Gave up after the maximum number of attempts
+)
+
+// SyncEvent is a PocketBase → PostGIS sync event recorded in the outbox
+type SyncEvent struct {
+	ID            string    `json:"id"`
+	EntityType    string    `json:"entity_type"` // trail, rating, comment
+	Action        string    `json:"action"`      // created, updated, deleted
+	TrailID       string    `json:"trail_id"`
+	RecordID      string    `json:"record_id"` // ID of the trail, rating or comment record
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error"`
+	Created       time.Time `json:"created"`
+}
+
+// TrailSyncReport summarizes an incremental PocketBase → PostGIS trail sync
+type TrailSyncReport struct {
+	Total     int `json:"total"`     // Trails in PocketBase
+	Unchanged int `json:"unchanged"` // Trails whose sync hash matched
+	Updated   int `json:"updated"`   // New or changed trails re-imported
+	Deleted   int `json:"deleted"`   // PostGIS trails no longer in PocketBase
+	Failed    int `json:"failed"`
+	Tiles     int `json:"tiles"` // Tiles invalidated and queued for regeneration
+}
+
+// SyncOutboxStats reports the number of outbox events per status
+type SyncOutboxStats struct {
+	Pending int `json:"pending"`
+	Dead    int `json:"dead"`
+}
+
+// Drift kinds found by the consistency reconciler
+const (
+	DriftMissingInPostGIS      = "missing_in_postgis"      // Trail in PocketBase but not in PostGIS
+	DriftOrphanInPostGIS       = "orphan_in_postgis"       // Trail in PostGIS but not in PocketBase
+	DriftStaleImport           = "stale_import"            // PostGIS trail imported from an older PocketBase record
+	DriftGeometryMismatch      = "geometry_mismatch"       // PostGIS geometry changed since it was imported
+	DriftMissingTileIndex      = "missing_tile_index"      // Trail geometry without trail_tiles rows
+	DriftRatingAverageMismatch = "rating_average_mismatch" // rating_average record differs from trail_ratings
+)
+
+// GeneratorTrailState is the reconciliation view of a trail stored in the generator
+type GeneratorTrailState struct {
+	SyncHash      string
+	GeometryValid bool // Geometry unchanged since import (or imported before geometry hashes existed)
+	HasGeometry   bool
+	TileCount     int
+}
+
+// DriftIssue is a single inconsistency between PocketBase and PostGIS
+type DriftIssue struct {
+	Kind        string `json:"kind"`
+	TrailID     string `json:"trail_id"`
+	Detail      string `json:"detail,omitempty"`
+	Repaired    bool   `json:"repaired"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// DriftReport is the result of a reconciler run
+type DriftReport struct {
+	StartedAt      time.Time      `json:"started_at"`
+	DurationMs     int64          `json:"duration_ms"`
+	AutoRepair     bool           `json:"auto_repair"`
+	TrailsPB       int            `json:"trails_pocketbase"`
+	TrailsPostGIS  int            `json:"trails_postgis"`
+	SkippedPending int            `json:"skipped_pending"` // Trails with sync events not applied yet
+	Counts         map[string]int `json:"counts"`          // Issues per drift kind
+	Repaired       int            `json:"repaired"`
+	RepairFailed   int            `json:"repair_failed"`
+	Issues         []DriftIssue   `json:"issues"`
+}
@@ -1,8 +1,70 @@
 package entities
 
 // TileCoordinates represents the coordinates of a tile
-type TileCoordinates struct{
-	X, Y, Z int
+type TileCoordinates struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
 }
 
-const MBtilesFilePrefix = "bikemap-"
\ No newline at end of file
+const MBtilesFilePrefix = "bikemap-"
+
+// PMTilesLatestFilename is the PMTiles archive exported from the latest snapshot
+const PMTilesLatestFilename = MBtilesFilePrefix + "latest.pmtiles"
+
+// TileQueueStats reports the state of the background tile queue
+type TileQueueStats struct {
+	Pending   int    `json:"pending"`   // Tiles waiting for generation
+	Queued    uint64 `json:"queued"`    // Tiles accepted since startup
+	Coalesced uint64 `json:"coalesced"` // Pushes merged into an already pending tile
+	Dropped   uint64 `json:"dropped"`   // Tiles rejected or evicted because the queue was full
+}
+
+// TileRequestStats reports priority requests that did not run to completion
+type TileRequestStats struct {
+	Cancelled uint64 `json:"cancelled"` // Waiters that left before their tile was ready (client disconnected)
+	Abandoned uint64 `json:"abandoned"` // Generations skipped or aborted because every waiter left
+	Timeouts  uint64 `json:"timeouts"`  // Generations cut off by the tile query timeout
+}
+
+// TileQueueStatus is a point-in-time view of the tile generation queues
+type TileQueueStatus struct {
+	PriorityPending int               `json:"priority_pending"`
+	Background      TileQueueStats    `json:"background"`
+	Requests        TileRequestStats  `json:"requests"`
+	NextTiles       []TileCoordinates `json:"next_tiles"` // Next background tiles, in processing order
+}
+
+// Tile event types pushed to map clients
+const (
+	TileEventInvalidated  = "tiles_invalidated" // Tiles are stale and will be regenerated
+	TileEventReady        = "tiles_ready"       // Previously invalidated tiles have been regenerated
+	TileEventTrailChanged = "trail_changed"     // A trail was created, updated or deleted
+)
+
+// TileEvent is a tile invalidation event pushed to map clients
+type TileEvent struct {
+	Type    string            `json:"type"`
+	Seq     uint64            `json:"seq"` // Increases with every event
+	TrailID string            `json:"trail_id,omitempty"`
+	Action  string            `json:"action,omitempty"` // created, updated, deleted (trail_changed only)
+	BBox    *BoundingBox      `json:"bbox,omitempty"`   // Area of the trail before and after the change, or of tiles too many to list
+	Tiles   []TileCoordinates `json:"tiles,omitempty"`
+}
+
+// MBTilesExtractRequest selects the tiles of a regional extract: an area given as a bbox,
+// a trail or a named region, and the maximum zoom level
+type MBTilesExtractRequest struct {
+	BBox    *BoundingBox
+	TrailID string
+	Region  string
+	MaxZoom int // 0 means the backup's maximum zoom
+}
+
+// MBTilesExtract is a regional MBTiles extract built from a snapshot
+type MBTilesExtract struct {
+	Path     string // File on disk, kept in the extract cache
+	Filename string // Suggested download name
+	MaxZoom  int
+	Cached   bool // Served from the cache rather than built for this request
+}
@@ -46,8 +46,6 @@ type Trail struct {
 	ElevationJSON string
 	CreatedAt     interface{}
 	UpdatedAt     interface{}
-	RatingAvg     float64
-	RatingCount   int
-	CommentCount  int
 	Ridden        bool
+	SyncHash      string // Fingerprint of the PocketBase record the trail was imported from
 }
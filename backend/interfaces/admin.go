@@ -0,0 +1,45 @@
+package interfaces
+
+import (
+	"context"
+	"errors"
+
+	"bike-map/entities"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// TileState is the cache state of a single tile
+type TileState struct {
+	Coords entities.TileCoordinates
+	Status TileStatus
+	Size   int // Size of the cached tile data in bytes
+}
+
+// TilePipelineAdmin - inspection and maintenance of the tile pipeline (used by admin handlers)
+type TilePipelineAdmin interface {
+	GetTrailTileStates(ctx context.Context, trailID string) ([]TileState, error)
+	InvalidateTileRange(bbox *entities.BoundingBox, minZoom, maxZoom int) int
+	RebuildTrail(ctx context.Context, app core.App, trailID string) error
+	SyncAllTrails(ctx context.Context, app core.App) (entities.TrailSyncReport, error)
+	RebuildAllTrails(ctx context.Context, app core.App) error
+	TriggerSnapshot() error
+	QueueStatus(limit int) entities.TileQueueStatus
+}
+
+// ErrReconcileRunning is returned by ConsistencyReconciler.Run while another run is in progress
+var ErrReconcileRunning = errors.New("reconciliation already running")
+
+// ConsistencyReconciler - PocketBase/PostGIS drift detection and repair (used by admin handlers)
+type ConsistencyReconciler interface {
+	Run(ctx context.Context, repair bool) (*entities.DriftReport, error)
+	LastReport() *entities.DriftReport
+}
+
+// SyncOutboxAdmin - inspection of PocketBase → PostGIS sync events that failed or are waiting for a retry
+type SyncOutboxAdmin interface {
+	ListEvents(status string, limit int) ([]entities.SyncEvent, error)
+	RetryEvent(id string) error
+	DiscardEvent(id string) error
+	Stats() (entities.SyncOutboxStats, error)
+}
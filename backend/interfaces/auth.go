@@ -6,5 +6,6 @@ import "github.com/pocketbase/pocketbase/core"
 type Auth interface {
 	CanCreateTrails(user *core.Record) bool
 	CanManageUsers(user *core.Record) bool
+	IsAdmin(user *core.Record) bool
 	GetDefaultRole() string
 }
@@ -0,0 +1,18 @@
+package interfaces
+
+import "bike-map/entities"
+
+// ClusterCoordinator - shares tile invalidations between instances and elects the snapshot leader
+type ClusterCoordinator interface {
+	PublishInvalidation(tiles []entities.TileCoordinates)
+	PublishTrailChange(trailID, action string, bbox *entities.BoundingBox)
+	IsLeader() bool
+}
+
+// ClusterEventHandler - applies changes published by other instances to this instance
+type ClusterEventHandler interface {
+	ApplyRemoteInvalidation(tiles []entities.TileCoordinates)
+	ApplyRemoteTrailChange(trailID, action string, bbox *entities.BoundingBox)
+	// ApplyRemoteResync is called when notifications may have been missed (listener reconnected)
+	ApplyRemoteResync()
+}
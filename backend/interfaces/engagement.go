@@ -13,4 +13,9 @@ type Engagement interface {
 	UpdateRatingAverage(app core.App, trailID string) error
 	DeleteRatingAverage(app core.App, trailID string) error
 	GetEngagementStats(ctx context.Context, trailID string) (*entities.EngagementStats, error)
+	GetEngagementStatsBulk(ctx context.Context, trailIDs []string) (map[string]*entities.EngagementStats, error)
+	ComputeRatingAverages(ctx context.Context) (map[string]*entities.RatingAverage, error)
+	GetRatingAverageRecords(ctx context.Context) (map[string]*entities.RatingAverage, error)
+	GetRatingDistribution(ctx context.Context, trailID string) (map[int]int, error)
+	GetLatestComments(ctx context.Context, trailIDs []string, perTrail int) (map[string][]entities.TrailComment, error)
 }
@@ -0,0 +1,60 @@
+package interfaces
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"bike-map/entities"
+)
+
+// Errors returned by MBTilesExtractor
+var (
+	ErrNoSnapshot             = errors.New("no snapshot available")
+	ErrUnknownRegion          = errors.New("unknown region")
+	ErrTrailNotFound          = errors.New("trail not found")
+	ErrTrailLookupUnavailable = errors.New("trail lookup unavailable")
+	ErrDeltaUnavailable       = errors.New("delta unavailable, a full download is required")
+	ErrBundleTooLarge         = errors.New("too many trails for an offline bundle")
+)
+
+// MBTilesExtractor - builds regional extracts and deltas from the latest snapshot (used by handlers)
+type MBTilesExtractor interface {
+	Extract(ctx context.Context, req entities.MBTilesExtractRequest) (*entities.MBTilesExtract, error)
+	Delta(ctx context.Context, epoch string, since int64) (*entities.MBTilesExtract, error)
+	Regions() map[string]entities.BoundingBox
+}
+
+// OfflineBundler - builds offline bundles of tiles, trails, comments and GPX files (used by handlers)
+type OfflineBundler interface {
+	Bundle(ctx context.Context, req entities.OfflineBundleRequest) (*entities.OfflineBundle, error)
+}
+
+// SnapshotDownloadTracker - protects snapshots from retention cleanup while they are downloaded (used by handlers)
+type SnapshotDownloadTracker interface {
+	Begin(filename string) (end func())
+}
+
+// Errors returned by DownloadAccess
+var (
+	ErrInvalidSignature = errors.New("invalid download signature")
+	ErrSignatureExpired = errors.New("download signature expired")
+)
+
+// DownloadAccess - access control and rate limiting of snapshot downloads (used by handlers)
+type DownloadAccess interface {
+	// RequiresAuth reports whether anonymous downloads are rejected
+	RequiresAuth() bool
+	// SignedURLs reports whether signed download URLs are issued and accepted
+	SignedURLs() bool
+	// Sign returns target with an expiring signature granting userID access to it
+	Sign(target *url.URL, userID string) (*url.URL, time.Time)
+	// Verify checks the signature of a request URL and returns the user it was issued to
+	Verify(u *url.URL) (string, error)
+	// Allow records a download started by key, and returns how long to wait if over the limit.
+	// etag identifies the downloaded file for later resumes, empty if it cannot be resumed.
+	Allow(key, etag string) (bool, time.Duration)
+	// Admitted reports whether key started a download of the file with etag within the rate window
+	Admitted(key, etag string) bool
+}
@@ -16,6 +16,20 @@ const (
 	TileInvalidated                   // Needs regeneration
 )
 
+// String returns the lowercase name of the status
+func (s TileStatus) String() string {
+	switch s {
+	case TileEmpty:
+		return "empty"
+	case TileValid:
+		return "valid"
+	case TileInvalidated:
+		return "invalidated"
+	default:
+		return "not_found"
+	}
+}
+
 // MVTProvider - base interface for MVT operations (read-only)
 type MVTProvider interface {
 	GetTile(ctx context.Context, c entities.TileCoordinates) ([]byte, error)
@@ -31,6 +45,13 @@ type MVTCache interface {
 	ClearAllTiles() error
 	InvalidateTiles(tiles []entities.TileCoordinates) error
 	GetTileWithStatus(c entities.TileCoordinates) ([]byte, TileStatus, error)
+	ListTiles(minZoom, maxZoom int) []entities.TileCoordinates
+	// ServeTile is GetTile for HTTP handlers: it also reports the status of the
+	// returned data, TileInvalidated meaning a stale tile was served
+	ServeTile(ctx context.Context, c entities.TileCoordinates) ([]byte, TileStatus, error)
+	// SetFallbackCurrent tells whether the fallback tiles are up to date, in which case
+	// they are served as valid instead of stale
+	SetFallbackCurrent(current bool)
 }
 
 // MVTBackup - backup storage for tiles (e.g., mbtiles)
@@ -38,7 +59,15 @@ type MVTBackup interface {
 	MVTProvider
 	StoreTile(c entities.TileCoordinates, data []byte) error
 	ClearAllTiles() error
+	// SetSource records the trail data the tiles are generated from, written to the metadata
+	// and snapshot manifests. Nil bounds mean the whole world.
+	SetSource(source entities.SnapshotSource) error
 	Snapshot() error
+	// ListTiles returns the tiles held in the zoom range
+	ListTiles(minZoom, maxZoom int) ([]entities.TileCoordinates, error)
+	// LoadedTrailRevision returns the trail revision of the snapshot the backup was loaded
+	// from, empty if unknown
+	LoadedTrailRevision() string
 }
 
 // MVTGenerator - generates MVT tiles and manages trail data
@@ -48,11 +77,31 @@ type MVTGenerator interface {
 	UpdateTrail(ctx context.Context, trail entities.Trail) error
 	DeleteTrail(ctx context.Context, trailID string) error
 	ClearAllTrails(ctx context.Context) error
+	GetTrailSyncHashes(ctx context.Context) (map[string]string, error)
+	GetTrailStates(ctx context.Context) (map[string]entities.GeneratorTrailState, error)
 
 	GetTrailTiles(ctx context.Context, trailID string) ([]entities.TileCoordinates, error)
+	GetTrailBBox(ctx context.Context, trailID string) (*entities.BoundingBox, error)
+	GetTrailGeometry(ctx context.Context, trailID string) (*entities.TrailGeometry, error)
+	GetTrailsExtent(ctx context.Context) (*entities.BoundingBox, error)
+	GetTrailIDsInBBox(ctx context.Context, bbox entities.BoundingBox) ([]string, error)
+	GetAllTiles(ctx context.Context) ([]entities.TileCoordinates, error)
+}
+
+// TileEventPublisher - publishes tile invalidation events to map clients (used by orchestration)
+type TileEventPublisher interface {
+	TrailChanged(trailID, action string, bbox *entities.BoundingBox)
+	TilesInvalidated(tiles []entities.TileCoordinates)
+	TilesReady(tiles []entities.TileCoordinates)
+}
+
+// TileEventSource - streams tile events to map clients (used by handlers)
+type TileEventSource interface {
+	Subscribe() (<-chan entities.TileEvent, func())
 }
 
 // TileRequester - requests priority tile generation (used by handlers)
 type TileRequester interface {
-	RequestTile(coords entities.TileCoordinates) ([]byte, error)
+	RequestTile(ctx context.Context, coords entities.TileCoordinates) ([]byte, error)
+	RequestTileAsync(coords entities.TileCoordinates)
 }
@@ -0,0 +1,22 @@
+package interfaces
+
+import (
+	"context"
+
+	"bike-map/entities"
+)
+
+// TrailSearch interface for full-text trail search
+type TrailSearch interface {
+	SearchTrails(ctx context.Context, query entities.TrailSearchQuery) (*entities.TrailSearchResults, error)
+}
+
+// TrailSpatial interface for trail queries by location
+type TrailSpatial interface {
+	FindTrails(ctx context.Context, query entities.TrailAreaQuery) (*entities.TrailSummaries, error)
+}
+
+// TrailDetails interface for the combined record, stats and engagement of a trail
+type TrailDetails interface {
+	GetTrailDetails(ctx context.Context, trailID string, profilePoints int) (*entities.TrailDetails, error)
+}
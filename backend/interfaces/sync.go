@@ -2,13 +2,17 @@ package interfaces
 
 import (
 	"context"
+	"errors"
+
+	"bike-map/entities"
 
 	"github.com/pocketbase/pocketbase/core"
 )
 
 // SyncTrails interface for PostGIS synchronization operations
 type SyncTrails interface {
-	SyncAllTrails(ctx context.Context, app core.App) error
+	SyncAllTrails(ctx context.Context, app core.App) (entities.TrailSyncReport, error)
+	RebuildAllTrails(ctx context.Context, app core.App) error
 
 	HandleTrailCreated(ctx context.Context, app core.App, trailID string) error
 	HandleTrailUpdated(ctx context.Context, app core.App, trailID string) error
@@ -21,3 +25,12 @@ type SyncTrails interface {
 	HandleCommentCreated(ctx context.Context, app core.App, trailID string) error
 	HandleCommentDeleted(ctx context.Context, app core.App, trailID string) error
 }
+
+// ErrCursorExpired is returned for a change feed cursor older than the retained change log
+var ErrCursorExpired = errors.New("cursor expired, a full resync is required")
+
+// ChangeFeed interface for incremental client sync of trails, ratings and comments
+type ChangeFeed interface {
+	Cursor(ctx context.Context) (int64, error)
+	Changes(ctx context.Context, since int64, limit int) (*entities.ChangeFeed, error)
+}
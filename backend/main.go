@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 
 	"bike-map/config"
@@ -19,19 +20,29 @@ func main() {
 
 	// Initialize PocketBase app
 	app := pocketbase.New()
+	app.RootCmd.AddCommand(newPostGISCommand(cfg))
 
-	// Initialize application service with all dependencies
-	appService, err := services.NewAppService(cfg, app)
-	if err != nil {
-		log.Fatalf("Failed to initialize application service: %v", err)
-	}
-	defer appService.Close()
-
-	// Setup PocketBase hooks
-	appService.SetupHooks()
+	// The application service connects to PostGIS and starts the tile workers, so it is only
+	// built for the server: other commands such as "postgis status" must not touch the schema
+	var appService *services.AppService
+	defer func() {
+		if appService != nil {
+			appService.Close()
+		}
+	}()
 
 	// OnServe runs after database is ready
 	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		// Initialize application service with all dependencies
+		var err error
+		appService, err = services.NewAppService(cfg, app)
+		if err != nil {
+			return fmt.Errorf("failed to initialize application service: %w", err)
+		}
+
+		// Setup PocketBase hooks
+		appService.SetupHooks()
+
 		// Setup collections (requires DB)
 		if err := appService.SetupCollections(); err != nil {
 			return err
@@ -43,6 +54,18 @@ func main() {
 		// Perform initial trail sync
 		appService.SyncAllTrailsAtStartup()
 
+		// Apply sync events recorded by hooks (including leftovers from a previous run)
+		appService.StartSyncOutbox()
+
+		// Periodically check PocketBase and PostGIS for drift
+		appService.ScheduleReconciler()
+
+		// Periodically drop expired change feed entries
+		appService.ScheduleChangeLogPruning()
+
+		// Without PostGIS, tiles are served from the MBTiles backup until it is reachable
+		appService.StartPostGISReconnect()
+
 		return e.Next()
 	})
 
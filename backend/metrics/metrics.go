@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "bikemap"
+
+// Tile pipeline metrics
+var (
+	// TileRequests counts tile requests by cache status at lookup time (valid, empty, invalidated, backup, miss)
+	TileRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tile_requests_total",
+		Help:      "Tile requests by cache status at lookup (valid, empty, invalidated, backup, miss).",
+	}, []string{"status"})
+
+	// TileGenerationDuration observes generate_mvt_tile latency per zoom level and queue
+	TileGenerationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tile_generation_seconds",
+		Help:      "Tile generation latency by zoom level and queue (priority, background).",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms .. ~10s
+	}, []string{"zoom", "queue"})
+
+	// TileGenerationErrors counts failed tile generations per queue
+	TileGenerationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tile_generation_errors_total",
+		Help:      "Failed tile generations by queue (priority, background).",
+	}, []string{"queue"})
+
+	// TileSizeBytes observes the size of generated tiles
+	TileSizeBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tile_size_bytes",
+		Help:      "Size of generated tiles in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8), // 256B .. 4MB
+	})
+
+	// TileLastGenerated is the unix time of the last completed tile generation (stuck worker detection)
+	TileLastGenerated = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tile_last_generated_timestamp_seconds",
+		Help:      "Unix time of the last completed tile generation.",
+	})
+)
+
+// Snapshot metrics
+var (
+	// Snapshots counts MBTiles snapshot attempts by result (success, failure)
+	Snapshots = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshots_total",
+		Help:      "MBTiles snapshot attempts by result (success, failure).",
+	}, []string{"result"})
+
+	snapshotSizeBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_size_bytes",
+		Help:      "Size of the latest MBTiles snapshot in bytes.",
+	})
+
+	snapshotTiles = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_tiles",
+		Help:      "Number of tiles in the latest MBTiles snapshot.",
+	})
+
+	lastSnapshot atomic.Int64 // unix time of the latest successful snapshot
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_age_seconds",
+		Help:      "Seconds since the latest successful MBTiles snapshot, -1 before the first one.",
+	}, func() float64 {
+		last := lastSnapshot.Load()
+		if last == 0 {
+			return -1
+		}
+		return time.Since(time.Unix(last, 0)).Seconds()
+	})
+)
+
+// Sync metrics
+var (
+	// SyncEvents counts PocketBase to PostGIS sync events by type (trail, rating, comment) and action
+	SyncEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_events_total",
+		Help:      "PocketBase to PostGIS sync events by type (trail, rating, comment) and action.",
+	}, []string{"type", "action"})
+
+	// SyncFailures counts failed sync events by type (trail, rating, comment) and action
+	SyncFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_failures_total",
+		Help:      "Failed PocketBase to PostGIS sync events by type (trail, rating, comment) and action.",
+	}, []string{"type", "action"})
+)
+
+// Reconciler metrics
+var (
+	// DriftIssues is the number of PocketBase/PostGIS inconsistencies per kind found by the last reconciler run
+	DriftIssues = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "drift_issues",
+		Help:      "PocketBase/PostGIS inconsistencies per kind found by the last reconciler run.",
+	}, []string{"kind"})
+)
+
+// ObserveSnapshot records a successful snapshot
+func ObserveSnapshot(sizeBytes int64, tiles int) {
+	Snapshots.WithLabelValues("success").Inc()
+	snapshotSizeBytes.Set(float64(sizeBytes))
+	snapshotTiles.Set(float64(tiles))
+	lastSnapshot.Store(time.Now().Unix())
+}
+
+// ObserveSync records a sync event and its failure, if any
+func ObserveSync(eventType, action string, err error) {
+	SyncEvents.WithLabelValues(eventType, action).Inc()
+	if err != nil {
+		SyncFailures.WithLabelValues(eventType, action).Inc()
+	}
+}
+
+// RegisterGaugeFunc registers a gauge whose value is read at scrape time
+func RegisterGaugeFunc(name, help string, fn func() float64) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// RegisterCounterFunc registers a counter whose value is read at scrape time
+func RegisterCounterFunc(name, help string, fn func() float64) {
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"bike-map/config"
+	"bike-map/services"
+
+	"github.com/spf13/cobra"
+)
+
+// newPostGISCommand creates the "postgis" command group for PostGIS schema maintenance
+func newPostGISCommand(cfg *config.Config) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "postgis",
+		Short: "Manages the PostGIS schema migrations",
+	}
+
+	command.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Lists applied and pending PostGIS schema migrations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withPostGISMigrator(cfg, func(migrator *services.PostGISMigrator) error {
+				statuses, err := migrator.Status(cmd.Context())
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+				pending := 0
+				for _, s := range statuses {
+					state, appliedAt := "pending", "-"
+					switch {
+					case s.Unknown:
+						state = "applied (unknown to this build)"
+					case s.ChecksumMismatch:
+						state = "applied (modified since)"
+					case s.Applied:
+						state = "applied"
+					default:
+						pending++
+					}
+					if s.AppliedAt != nil {
+						appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+
+				fmt.Printf("\n%d pending migration(s)\n", pending)
+				return nil
+			})
+		},
+	})
+
+	command.AddCommand(&cobra.Command{
+		Use:   "migrate",
+		Short: "Applies pending PostGIS schema migrations (also done on server startup)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withPostGISMigrator(cfg, func(migrator *services.PostGISMigrator) error {
+				applied, err := migrator.Migrate(cmd.Context())
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Applied %d migration(s)\n", applied)
+				return nil
+			})
+		},
+	})
+
+	return command
+}
+
+// withPostGISMigrator runs fn with a migrator on a dedicated PostGIS connection
+func withPostGISMigrator(cfg *config.Config, fn func(*services.PostGISMigrator) error) error {
+	db, err := services.OpenPostGIS(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := services.NewPostGISMigrator(db)
+	if err != nil {
+		return err
+	}
+	return fn(migrator)
+}
@@ -2,14 +2,27 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"bike-map/apiHandlers"
 	"bike-map/config"
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/metrics"
 
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// PostGIS reconnection backoff while serving from the MBTiles backup
+const (
+	postgisReconnectMinDelay = 5 * time.Second
+	postgisReconnectMaxDelay = 5 * time.Minute
+)
+
 // AppService coordinates all application services with proper dependency injection
 type AppService struct {
 	// Configuration
@@ -24,22 +37,44 @@ type AppService struct {
 	engagementService    *EngagementService
 	orchestrationService *OrchestrationService
 	hookManagerService   *HookManagerService
+	syncOutbox           *SyncOutboxService
+	changeLog            *ChangeLogService
+	tileEvents           *TileEventBroker
+	cluster              *ClusterService
+	reconciler           *ReconcilerService
 	postgisService       *MVTGeneratorPostgis // MVTGenerator
 	mvtService           *MVTMemoryStorage    // MVTCache
 	mbtilesBackup        *MVTBackupMBTiles    // MVTBackup
+	mbtilesExtracts      *MBTilesExtractService
+	snapshotDownloads    *SnapshotDownloads
+	offlineBundles       *OfflineBundleService
+	trailDetails         *TrailDetailsService
 
 	// Handlers
-	mvtHandler     *apiHandlers.MVTHandler
-	authHandler    *apiHandlers.AuthHandler
-	metaHandler    *apiHandlers.MetaHandler
-	mbtilesHandler *apiHandlers.MBTilesHandler
+	mvtHandler        *apiHandlers.MVTHandler
+	tileEventsHandler *apiHandlers.TileEventsHandler
+	authHandler       *apiHandlers.AuthHandler
+	metaHandler       *apiHandlers.MetaHandler
+	mbtilesHandler    *apiHandlers.MBTilesHandler
+	metricsHandler    *apiHandlers.MetricsHandler
+	adminHandler      *apiHandlers.AdminHandler
+	engagementHandler *apiHandlers.EngagementHandler
+	changesHandler    *apiHandlers.ChangesHandler
+	trailsHandler     *apiHandlers.TrailsHandler
+
+	// Guards the PostGIS-backed services, set by a background reconnect
+	mu       sync.Mutex
+	live     atomic.Bool // PostGIS connected, tiles are generated live
+	stopChan chan struct{}
+	wg       sync.WaitGroup
 }
 
 // NewAppService creates a new application service with all dependencies properly wired
 func NewAppService(cfg *config.Config, app core.App) (*AppService, error) {
 	a := &AppService{
-		config: cfg,
-		app:    app,
+		config:   cfg,
+		app:      app,
+		stopChan: make(chan struct{}),
 	}
 
 	if err := a.initializeServices(); err != nil {
@@ -57,66 +92,315 @@ func (a *AppService) initializeServices() error {
 	// Initialize collection service
 	a.collectionService = NewCollectionService(a.config, a.authService)
 
-	// Initialize PostGIS service (MVTGenerator - owns the database connection)
-	var err error
-	a.postgisService, err = NewPostGISService(a.config)
-	if err != nil {
-		log.Printf("Failed to initialize PostGIS service: %v", err)
-		log.Printf("PostGIS sync and MVT endpoints will not be available")
-		return nil // Continue without PostGIS - PocketBase will still work
-	}
-
 	// Initialize MVT service (MVTCache - memory cache)
-	a.mvtService = NewMVTService()
+	a.mvtService = NewMVTService(a.config.Tiles.ServeStale)
+
+	// Snapshot downloads in progress, protected from retention cleanup
+	a.snapshotDownloads = NewSnapshotDownloads()
 
-	// Initialize MBTiles backup
-	a.mbtilesBackup, err = NewMVTBackupMBTiles(a.config.MBTiles.Path)
+	// Initialize MBTiles backup, seeded from the latest snapshot so tiles can be served
+	// before they are regenerated, or read-only while PostGIS is unavailable
+	var err error
+	a.mbtilesBackup, err = NewMVTBackupMBTiles(MBTilesBackupConfig{
+		snapshotDir:  a.config.MBTiles.Path,
+		pmtiles:      a.config.MBTiles.PMTiles,
+		retainCount:  a.config.MBTiles.RetentionKeep,
+		retainMaxAge: time.Duration(a.config.MBTiles.RetentionMaxAgeMinutes) * time.Minute,
+		downloads:    a.snapshotDownloads,
+	})
 	if err != nil {
 		log.Printf("Failed to initialize MBTiles backup: %v", err)
 		log.Printf("Tile backup will not be available")
+	} else {
+		if err := a.mbtilesBackup.LoadLatestSnapshot(); err != nil {
+			log.Printf("Failed to load latest MBTiles snapshot: %v", err)
+		}
+		a.mvtService.SetFallback(a.mbtilesBackup)
 	}
 
-	// Initialize MBTiles download handler
-	a.mbtilesHandler = apiHandlers.NewMBTilesHandler(a.config.MBTiles.Path)
+	// Initialize regional extracts of the latest snapshot
+	regions, err := config.ParseRegions(a.config.MBTiles.Regions)
+	if err != nil {
+		return fmt.Errorf("invalid MBTiles regions: %w", err)
+	}
+	extractCfg := MBTilesExtractConfig{
+		snapshotDir:   a.config.MBTiles.Path,
+		cacheSize:     a.config.MBTiles.ExtractCacheSize,
+		cacheMaxBytes: int64(a.config.MBTiles.ExtractCacheMaxMB) * 1024 * 1024,
+		regions:       make(map[string]entities.BoundingBox, len(regions)),
+		minZoom:       6, // Zoom range of the MBTiles backup
+		maxZoom:       18,
+	}
+	for name, r := range regions {
+		extractCfg.regions[name] = entities.BoundingBox{West: r.West, South: r.South, East: r.East, North: r.North}
+	}
+	a.mbtilesExtracts = NewMBTilesExtractService(extractCfg)
 
 	// Initialize engagement service
 	a.engagementService = NewEngagementService(a.app)
 
-	// Initialize OrchestrationService if PostGIS (MVTGenerator) is available
-	if a.postgisService != nil {
-		// Build snapshot config
-		snapshotCfg := SnapshotConfig{
-			stableSeconds: a.config.MBTiles.SnapshotStableSeconds,
-			snapshotDir:   a.config.MBTiles.Path,
-		}
+	// Initialize trail details (PocketBase record, PostGIS stats and engagement combined)
+	a.trailDetails = NewTrailDetailsService(a.app, a.engagementService)
+
+	// Initialize offline bundles (extract, trails, comments and GPX files of an area)
+	a.offlineBundles = NewOfflineBundleService(a.app, a.mbtilesExtracts, a.engagementService)
 
-		a.orchestrationService = NewOrchestrationService(
-			a.postgisService,
-			a.engagementService,
-			a.mvtService,
-			a.mbtilesBackup,
-			snapshotCfg,
-		)
-		// Wire TileRequester into MVTService (breaks circular dependency)
-		a.mvtService.SetTileRequester(a.orchestrationService)
+	// Initialize MBTiles download handler, with optional access control and rate limiting
+	downloadAccess := NewDownloadAccessService(DownloadAccessConfig{
+		mode:       a.config.MBTiles.DownloadAccess,
+		signingKey: []byte(a.config.MBTiles.DownloadSigningKey),
+		urlTTL:     time.Duration(a.config.MBTiles.DownloadURLTTLSeconds) * time.Second,
+		rateLimit:  a.config.MBTiles.DownloadRateLimit,
+	})
+	a.mbtilesHandler = apiHandlers.NewMBTilesHandler(a.config.MBTiles.Path, a.mbtilesExtracts, a.offlineBundles, a.snapshotDownloads, downloadAccess)
+
+	// Initialize tile event broker (pushes invalidations to map clients)
+	a.tileEvents = NewTileEventBroker()
+
+	// Initialize sync outbox (PocketBase → PostGIS events with retries).
+	// Events are recorded while PostGIS is unavailable and applied once it is reachable.
+	outboxCfg := SyncOutboxConfig{
+		pollInterval: time.Duration(a.config.Sync.PollIntervalSeconds) * time.Second,
+		maxAttempts:  a.config.Sync.MaxAttempts,
+		baseBackoff:  time.Duration(a.config.Sync.BaseBackoffSeconds) * time.Second,
+		maxBackoff:   time.Duration(a.config.Sync.MaxBackoffSeconds) * time.Second,
 	}
+	a.syncOutbox = NewSyncOutboxService(a.app, outboxCfg)
+
+	// Initialize change log (change feed for clients syncing incrementally)
+	a.changeLog = NewChangeLogService(a.app, ChangeLogConfig{
+		retention: time.Duration(a.config.Sync.ChangeLogRetentionDays) * 24 * time.Hour,
+	})
 
 	// Initialize hook manager service
 	a.hookManagerService = NewHookManagerService(
 		a.authService,
-		a.orchestrationService,
+		a.syncOutbox,
+		a.changeLog,
 	)
 
 	// Initialize handlers
-	if a.mvtService != nil && a.orchestrationService != nil {
-		a.mvtHandler = apiHandlers.NewMVTHandler(a.mvtService)
-	}
+	a.mvtHandler = apiHandlers.NewMVTHandler(a.mvtService)
+	a.tileEventsHandler = apiHandlers.NewTileEventsHandler(a.tileEvents)
+	a.adminHandler = apiHandlers.NewAdminHandler(a.app, a.authService, a.syncOutbox)
 	a.authHandler = apiHandlers.NewAuthHandler(a.authService)
 	a.metaHandler = apiHandlers.NewMetaHandler(a.app)
+	a.engagementHandler = apiHandlers.NewEngagementHandler(a.engagementService)
+	a.changesHandler = apiHandlers.NewChangesHandler(a.changeLog)
+	a.trailsHandler = apiHandlers.NewTrailsHandler(a.trailDetails)
+	a.metricsHandler = apiHandlers.NewMetricsHandler(a.config.Metrics.Token)
+
+	a.registerMetrics()
+
+	// Initialize the live tile pipeline if PostGIS (MVTGenerator) is available
+	if err := a.connectPostGIS(); err != nil {
+		log.Printf("Failed to initialize PostGIS service: %v", err)
+		log.Printf("Serving read-only tiles from the MBTiles backup until PostGIS is reachable")
+	}
+
+	return nil
+}
+
+// connectPostGIS connects to PostGIS and starts live tile generation: orchestration,
+// cluster coordination and reconciliation. Tiles are served from the cache from then on.
+func (a *AppService) connectPostGIS() error {
+	// Initialize PostGIS service (MVTGenerator - owns the database connection)
+	postgisService, err := NewPostGISService(a.config)
+	if err != nil {
+		return err
+	}
+
+	// Initialize cluster coordination (cache coherence and snapshot leader between instances)
+	clusterCfg := ClusterConfig{
+		enabled:    a.config.Cluster.Enabled,
+		instanceID: a.config.Cluster.InstanceID,
+	}
+	cluster, err := NewClusterService(a.config, clusterCfg)
+	if err != nil {
+		postgisService.Close()
+		return fmt.Errorf("failed to initialize cluster coordination: %w", err)
+	}
+
+	// Build tile worker config
+	workerCfg := TileWorkerConfig{
+		workers:             a.config.Tiles.Workers,
+		backgroundQueueSize: a.config.Tiles.BackgroundQueueSize,
+		requestTimeout:      time.Duration(a.config.Tiles.RequestTimeoutSeconds) * time.Second,
+	}
+
+	// Build snapshot config
+	snapshotCfg := SnapshotConfig{
+		stableSeconds: a.config.MBTiles.SnapshotStableSeconds,
+		snapshotDir:   a.config.MBTiles.Path,
+	}
+
+	// A backup that failed to initialize is a nil pointer: pass a nil interface instead, which
+	// is what the orchestration service checks for
+	var backup interfaces.MVTBackup
+	if a.mbtilesBackup != nil {
+		backup = a.mbtilesBackup
+	}
+
+	orchestrationService := NewOrchestrationService(
+		postgisService,
+		a.engagementService,
+		a.mvtService,
+		backup,
+		a.tileEvents,
+		cluster,
+		workerCfg,
+		snapshotCfg,
+	)
+
+	if err := cluster.Start(orchestrationService); err != nil {
+		orchestrationService.Stop()
+		postgisService.Close()
+		return fmt.Errorf("failed to start cluster coordination: %w", err)
+	}
+
+	// Initialize consistency reconciler (PocketBase ↔ PostGIS drift detection)
+	reconcilerCfg := ReconcilerConfig{
+		schedule:   a.config.Reconciler.Schedule,
+		autoRepair: a.config.Reconciler.AutoRepair,
+	}
+	reconciler := NewReconcilerService(a.app, orchestrationService, a.syncOutbox, a.engagementService, postgisService, reconcilerCfg)
+
+	a.mu.Lock()
+	a.postgisService = postgisService
+	a.cluster = cluster
+	a.orchestrationService = orchestrationService
+	a.reconciler = reconciler
+	a.mu.Unlock()
+
+	// Wire TileRequester into MVTService (breaks circular dependency)
+	a.mvtService.SetTileRequester(orchestrationService)
+	a.adminHandler.SetTilePipeline(orchestrationService, reconciler)
+	a.mbtilesExtracts.SetGenerator(postgisService)
+	a.offlineBundles.SetGenerator(postgisService)
+	a.trailDetails.SetGenerator(postgisService)
+	trailQueries := NewTrailQueryService(postgisService, postgisService, a.engagementService)
+	a.trailsHandler.SetTrailQueries(trailQueries, trailQueries)
+	a.live.Store(true)
+	a.registerPipelineMetrics(orchestrationService, cluster)
 
 	return nil
 }
 
+// StartPostGISReconnect retries connecting to PostGIS in the background when the app started
+// without it, then switches from the MBTiles backup to live tile generation
+func (a *AppService) StartPostGISReconnect() {
+	if orchestrator, _ := a.pipeline(); orchestrator != nil {
+		return
+	}
+
+	a.wg.Add(1)
+	go a.reconnectPostGIS()
+}
+
+// reconnectPostGIS retries with exponential backoff until PostGIS is reachable or the app stops
+func (a *AppService) reconnectPostGIS() {
+	defer a.wg.Done()
+
+	delay := postgisReconnectMinDelay
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-time.After(delay):
+		}
+
+		if err := a.connectPostGIS(); err != nil {
+			delay = min(delay*2, postgisReconnectMaxDelay)
+			log.Printf("PostGIS still unavailable, retrying in %s: %v", delay, err)
+			continue
+		}
+
+		log.Println("PostGIS reachable, switching to live tile generation")
+		a.SyncAllTrailsAtStartup()
+		a.StartSyncOutbox()
+		a.ScheduleReconciler()
+		return
+	}
+}
+
+// pipeline returns the PostGIS-backed services, nil until PostGIS is connected.
+// They are set by the background reconnect, so they are always read under the lock.
+func (a *AppService) pipeline() (*OrchestrationService, *ReconcilerService) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.orchestrationService, a.reconciler
+}
+
+// registerMetrics registers gauges read from the services at scrape time
+func (a *AppService) registerMetrics() {
+	if a.mvtService != nil {
+		metrics.RegisterGaugeFunc("cache_entries", "Tiles held in the memory cache.", func() float64 {
+			entries, _ := a.mvtService.Stats()
+			return float64(entries)
+		})
+		metrics.RegisterGaugeFunc("cache_bytes", "Tile data held in the memory cache in bytes.", func() float64 {
+			_, bytes := a.mvtService.Stats()
+			return float64(bytes)
+		})
+	}
+
+	metrics.RegisterGaugeFunc("tile_pipeline_live", "1 if tiles are generated from PostGIS, 0 if served read-only from the MBTiles backup.", func() float64 {
+		if a.live.Load() {
+			return 1
+		}
+		return 0
+	})
+
+	if a.tileEvents != nil {
+		metrics.RegisterGaugeFunc("tile_event_subscribers", "Map clients connected to the tile event stream.", func() float64 {
+			return float64(a.tileEvents.SubscriberCount())
+		})
+	}
+
+	if a.syncOutbox != nil {
+		metrics.RegisterGaugeFunc("sync_outbox_pending", "Sync events waiting to be applied to PostGIS.", func() float64 {
+			stats, _ := a.syncOutbox.Stats()
+			return float64(stats.Pending)
+		})
+		metrics.RegisterGaugeFunc("sync_outbox_dead", "Sync events that failed every attempt.", func() float64 {
+			stats, _ := a.syncOutbox.Stats()
+			return float64(stats.Dead)
+		})
+	}
+}
+
+// registerPipelineMetrics registers gauges read from the PostGIS-backed services at scrape time
+func (a *AppService) registerPipelineMetrics(o *OrchestrationService, cluster *ClusterService) {
+	metrics.RegisterGaugeFunc("priority_queue_depth", "Priority tile requests waiting for a worker.", func() float64 {
+		return float64(o.PriorityQueueLen())
+	})
+	metrics.RegisterGaugeFunc("background_queue_depth", "Tiles pending background generation.", func() float64 {
+		return float64(o.BackgroundQueueStats().Pending)
+	})
+	metrics.RegisterCounterFunc("background_queue_dropped_total", "Tiles rejected or evicted because the background queue was full.", func() float64 {
+		return float64(o.BackgroundQueueStats().Dropped)
+	})
+	metrics.RegisterCounterFunc("background_queue_coalesced_total", "Tiles merged into an already pending background tile.", func() float64 {
+		return float64(o.BackgroundQueueStats().Coalesced)
+	})
+	metrics.RegisterCounterFunc("tile_requests_cancelled_total", "Priority tile waiters that left before their tile was ready.", func() float64 {
+		return float64(o.TileRequestStats().Cancelled)
+	})
+	metrics.RegisterCounterFunc("tile_generations_abandoned_total", "Tile generations skipped or aborted because every waiter left.", func() float64 {
+		return float64(o.TileRequestStats().Abandoned)
+	})
+	metrics.RegisterCounterFunc("tile_generation_timeouts_total", "Tile generations cut off by the query timeout.", func() float64 {
+		return float64(o.TileRequestStats().Timeouts)
+	})
+
+	metrics.RegisterGaugeFunc("cluster_leader", "1 if this instance is the cluster leader writing MBTiles snapshots.", func() float64 {
+		if cluster.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+}
+
 // SetupCollections initializes all required collections
 func (a *AppService) SetupCollections() error {
 	if err := a.collectionService.EnsureTrailsCollection(a.app); err != nil {
@@ -135,6 +419,14 @@ func (a *AppService) SetupCollections() error {
 		return err
 	}
 
+	if err := a.collectionService.EnsureSyncOutboxCollection(a.app); err != nil {
+		return err
+	}
+
+	if err := a.collectionService.EnsureChangeLogCollection(a.app); err != nil {
+		return err
+	}
+
 	if err := a.collectionService.ConfigureUsersCollection(a.app); err != nil {
 		return err
 	}
@@ -167,6 +459,10 @@ func (a *AppService) SetupRoutes(e *core.ServeEvent) {
 		a.mvtHandler.SetupRoutes(e)
 	}
 
+	if a.tileEventsHandler != nil {
+		a.tileEventsHandler.SetupRoutes(e)
+	}
+
 	if a.authHandler != nil {
 		a.authHandler.SetupRoutes(e, a.app)
 	}
@@ -175,6 +471,26 @@ func (a *AppService) SetupRoutes(e *core.ServeEvent) {
 		a.mbtilesHandler.SetupRoutes(e)
 	}
 
+	if a.metricsHandler != nil {
+		a.metricsHandler.SetupRoutes(e)
+	}
+
+	if a.adminHandler != nil {
+		a.adminHandler.SetupRoutes(e)
+	}
+
+	if a.engagementHandler != nil {
+		a.engagementHandler.SetupRoutes(e)
+	}
+
+	if a.changesHandler != nil {
+		a.changesHandler.SetupRoutes(e)
+	}
+
+	if a.trailsHandler != nil {
+		a.trailsHandler.SetupRoutes(e)
+	}
+
 	// Add custom CORS handling
 	e.Router.GET("/*", func(re *core.RequestEvent) error {
 		re.Response.Header().Set("Access-Control-Allow-Origin", "*")
@@ -184,27 +500,93 @@ func (a *AppService) SetupRoutes(e *core.ServeEvent) {
 	})
 }
 
-// SyncAllTrailsAtStartup performs initial sync of all trails to generator and generates tiles
+// SyncAllTrailsAtStartup incrementally syncs changed trails to the generator and queues
+// generation of the tiles the MBTiles backup lacks, or of all tiles if its snapshot is outdated
 func (a *AppService) SyncAllTrailsAtStartup() {
-	if a.orchestrationService == nil {
+	orchestrator, _ := a.pipeline()
+	if orchestrator == nil {
 		return
 	}
 
-	log.Println("Starting initial sync of all trails...")
-	if err := a.orchestrationService.SyncAllTrails(context.Background(), a.app); err != nil {
+	ctx := context.Background()
+
+	// Compared before the sync changes the trails
+	snapshotCurrent := orchestrator.SnapshotCurrent(ctx)
+
+	log.Println("Starting incremental sync of trails...")
+	report, err := orchestrator.SyncAllTrails(ctx, a.app)
+	if err != nil {
 		log.Printf("Failed to sync trails at startup: %v", err)
-	} else {
-		log.Println("Successfully synced all trails and generated tiles at startup")
+	}
+
+	// Tiles of the trails synced are invalidated in the cache, the other backup tiles are up to date
+	// unless some trails failed to sync
+	a.mvtService.SetFallbackCurrent(snapshotCurrent && err == nil && report.Failed == 0)
+
+	if err := orchestrator.WarmUpTiles(ctx, snapshotCurrent); err != nil {
+		log.Printf("Failed to queue tile warm-up at startup: %v", err)
+	}
+}
+
+// StartSyncOutbox starts applying recorded sync events, including those left over from a previous run
+func (a *AppService) StartSyncOutbox() {
+	if orchestrator, _ := a.pipeline(); orchestrator != nil {
+		a.syncOutbox.Start(orchestrator)
+	}
+}
+
+// ScheduleReconciler registers the periodic PocketBase/PostGIS consistency check
+func (a *AppService) ScheduleReconciler() {
+	_, reconciler := a.pipeline()
+	if reconciler == nil {
+		return
+	}
+
+	if err := reconciler.Schedule(); err != nil {
+		log.Printf("Failed to schedule consistency reconciler: %v", err)
+	}
+}
+
+// ScheduleChangeLogPruning registers the periodic removal of expired change log entries
+func (a *AppService) ScheduleChangeLogPruning() {
+	if a.changeLog == nil {
+		return
+	}
+
+	if err := a.changeLog.Schedule(); err != nil {
+		log.Printf("Failed to schedule change log pruning: %v", err)
 	}
 }
 
 // Close cleans up all service resources
 func (a *AppService) Close() error {
+	// Stop reconnecting to PostGIS first, so the services below are no longer replaced
+	close(a.stopChan)
+	a.wg.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Stop the sync outbox worker before the services it calls
+	if a.syncOutbox != nil {
+		a.syncOutbox.Stop()
+	}
+
 	// Stop the tile worker
 	if a.orchestrationService != nil {
 		a.orchestrationService.Stop()
 	}
 
+	// Stop listening to other instances and hand over the snapshot leadership
+	if a.cluster != nil {
+		a.cluster.Stop()
+	}
+
+	// Disconnect map clients listening for tile events
+	if a.tileEvents != nil {
+		a.tileEvents.Stop()
+	}
+
 	if a.postgisService != nil {
 		if err := a.postgisService.Close(); err != nil {
 			log.Printf("Error closing PostGIS service: %v", err)
@@ -31,6 +31,12 @@ func (a *AuthService) CanManageUsers(user *core.Record) bool {
 	return role.CanManageUsers()
 }
 
+// IsAdmin checks if the user has the Admin role
+func (a *AuthService) IsAdmin(user *core.Record) bool {
+	role := entities.UserRole(user.GetString("role"))
+	return role == entities.RoleAdmin
+}
+
 // GetDefaultRole returns the default role for new users
 func (a *AuthService) GetDefaultRole() string {
 	return string(entities.RoleViewer)
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	changeLogCollection = "change_log"
+	changeLogPruneJobID = "change_log_prune"
+	changeLogPruneCron  = "15 * * * *" // Hourly
+)
+
+// changeLogCollections maps change feed entity types to their PocketBase collections
+var changeLogCollections = map[string]string{
+	entities.SyncEntityTrail:   "trails",
+	entities.SyncEntityRating:  "trail_ratings",
+	entities.SyncEntityComment: "trail_comments",
+}
+
+// ChangeLogConfig holds change log configuration
+type ChangeLogConfig struct {
+	retention time.Duration // Age after which entries are pruned, 0 to keep them forever
+}
+
+// ChangeLogService keeps a monotonic log of trail, rating and comment changes for clients
+// that sync incrementally. Entries are numbered in the transaction of the record change, so
+// a cursor (the last sequence number seen) never skips a committed change.
+type ChangeLogService struct {
+	app core.App
+	cfg ChangeLogConfig
+}
+
+// NewChangeLogService creates a new change log service
+func NewChangeLogService(app core.App, cfg ChangeLogConfig) *ChangeLogService {
+	return &ChangeLogService{
+		app: app,
+		cfg: cfg,
+	}
+}
+
+// Record appends a change to the log. txApp should be the transaction the record change runs in:
+// writes are serialized, so sequence numbers are assigned in commit order without gaps.
+func (s *ChangeLogService) Record(txApp core.App, entityType, action, trailID, recordID string) error {
+	collection, err := txApp.FindCachedCollectionByNameOrId(changeLogCollection)
+	if err != nil {
+		return fmt.Errorf("failed to find change_log collection: %w", err)
+	}
+
+	var last int64
+	if err := txApp.DB().Select("COALESCE(MAX(seq), 0)").From(changeLogCollection).Row(&last); err != nil {
+		return fmt.Errorf("failed to get last change sequence: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("seq", last+1)
+	record.Set("entity_type", entityType)
+	record.Set("action", action)
+	record.Set("trail_id", trailID)
+	record.Set("record_id", recordID)
+
+	if err := txApp.Save(record); err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	return nil
+}
+
+// Cursor returns the sequence number of the latest change, 0 if nothing changed yet
+func (s *ChangeLogService) Cursor(ctx context.Context) (int64, error) {
+	var cursor int64
+	err := s.app.DB().
+		Select("COALESCE(MAX(seq), 0)").
+		From(changeLogCollection).
+		WithContext(ctx).
+		Row(&cursor)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get change cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// Changes returns up to limit log entries after since, collapsed to the latest change of each
+// record and carrying the current record. Records deleted meanwhile are returned as tombstones.
+// Returns interfaces.ErrCursorExpired when entries after since were already pruned.
+func (s *ChangeLogService) Changes(ctx context.Context, since int64, limit int) (*entities.ChangeFeed, error) {
+	var bounds struct {
+		First int64 `db:"first"`
+		Last  int64 `db:"last"`
+	}
+	err := s.app.DB().
+		Select("COALESCE(MIN(seq), 0) AS first", "COALESCE(MAX(seq), 0) AS last").
+		From(changeLogCollection).
+		WithContext(ctx).
+		One(&bounds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get change log bounds: %w", err)
+	}
+
+	// A cursor ahead of the log comes from another (or a restored) database
+	if since < 0 || since > bounds.Last || (bounds.First > 0 && since < bounds.First-1) {
+		return nil, interfaces.ErrCursorExpired
+	}
+
+	var rows []struct {
+		Seq        int64          `db:"seq"`
+		EntityType string         `db:"entity_type"`
+		Action     string         `db:"action"`
+		TrailID    string         `db:"trail_id"`
+		RecordID   string         `db:"record_id"`
+		Created    types.DateTime `db:"created"`
+	}
+	err = s.app.DB().
+		Select("seq", "entity_type", "action", "trail_id", "record_id", "created").
+		From(changeLogCollection).
+		Where(dbx.NewExp("seq > {:since}", dbx.Params{"since": since})).
+		OrderBy("seq ASC").
+		Limit(int64(limit) + 1).
+		WithContext(ctx).
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load changes: %w", err)
+	}
+
+	feed := &entities.ChangeFeed{Cursor: since, Changes: []entities.ChangeEntry{}}
+	if len(rows) > limit {
+		feed.HasMore = true
+		rows = rows[:limit]
+	}
+	if len(rows) == 0 {
+		return feed, nil
+	}
+	feed.Cursor = rows[len(rows)-1].Seq
+
+	// Keep the latest change of each record, in log order
+	latest := make(map[string]int, len(rows))
+	for i, row := range rows {
+		latest[row.EntityType+"/"+row.RecordID] = i
+	}
+	ids := make(map[string][]string)
+	for i, row := range rows {
+		if latest[row.EntityType+"/"+row.RecordID] != i {
+			continue
+		}
+		feed.Changes = append(feed.Changes, entities.ChangeEntry{
+			Seq:        row.Seq,
+			EntityType: row.EntityType,
+			Action:     row.Action,
+			TrailID:    row.TrailID,
+			RecordID:   row.RecordID,
+			ChangedAt:  row.Created.Time(),
+		})
+		if row.Action != entities.SyncActionDeleted {
+			ids[row.EntityType] = append(ids[row.EntityType], row.RecordID)
+		}
+	}
+
+	records, err := s.currentRecords(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range feed.Changes {
+		change := &feed.Changes[i]
+		if change.Action == entities.SyncActionDeleted {
+			continue
+		}
+		record, ok := records[change.EntityType+"/"+change.RecordID]
+		if !ok {
+			// Deleted after this batch: its own deletion follows in a later batch
+			change.Action = entities.SyncActionDeleted
+			continue
+		}
+		change.Record = record.PublicExport()
+	}
+
+	return feed, nil
+}
+
+// currentRecords loads the records still present, keyed by "entity_type/id"
+func (s *ChangeLogService) currentRecords(ids map[string][]string) (map[string]*core.Record, error) {
+	records := make(map[string]*core.Record)
+	for entityType, recordIDs := range ids {
+		collection, ok := changeLogCollections[entityType]
+		if !ok {
+			continue
+		}
+
+		found, err := s.app.FindRecordsByIds(collection, recordIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load changed %s records: %w", collection, err)
+		}
+		for _, record := range found {
+			records[entityType+"/"+record.Id] = record
+		}
+	}
+	return records, nil
+}
+
+// Prune removes entries older than the retention period. The latest entry is always kept,
+// so the cursor does not go back to 0 once the log is pruned.
+func (s *ChangeLogService) Prune() (int64, error) {
+	if s.cfg.retention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := types.NowDateTime().Add(-s.cfg.retention)
+	result, err := s.app.DB().NewQuery(
+		"DELETE FROM " + changeLogCollection + " WHERE created < {:cutoff} AND seq < (SELECT MAX(seq) FROM " + changeLogCollection + ")",
+	).Bind(dbx.Params{"cutoff": cutoff.String()}).Execute()
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune change log: %w", err)
+	}
+
+	removed, _ := result.RowsAffected()
+	return removed, nil
+}
+
+// Schedule registers the periodic pruning of expired entries
+func (s *ChangeLogService) Schedule() error {
+	if s.cfg.retention <= 0 {
+		log.Println("Change log pruning disabled")
+		return nil
+	}
+
+	err := s.app.Cron().Add(changeLogPruneJobID, changeLogPruneCron, func() {
+		removed, err := s.Prune()
+		if err != nil {
+			log.Printf("Scheduled change log pruning failed: %v", err)
+			return
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d change log entries", removed)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule change log pruning: %w", err)
+	}
+
+	log.Printf("Change log pruning scheduled (retention: %s)", s.cfg.retention)
+	return nil
+}
+
+// Compile-time check to ensure ChangeLogService implements interfaces.ChangeFeed
+var _ interfaces.ChangeFeed = (*ChangeLogService)(nil)
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bike-map/config"
+	"bike-map/entities"
+	"bike-map/interfaces"
+
+	"github.com/lib/pq"
+)
+
+const (
+	clusterChannel          = "bikemap_tiles"
+	clusterLeaderLockKey    = 7317207 // Advisory lock held by the snapshot leader
+	clusterElectionInterval = 10 * time.Second
+	clusterTilesPerMessage  = 300 // Keeps NOTIFY payloads well below the 8000 byte limit
+)
+
+// Cluster message types
+const (
+	clusterMessageTiles = "tiles"
+	clusterMessageTrail = "trail"
+)
+
+// clusterMessage is the NOTIFY payload exchanged between instances
+type clusterMessage struct {
+	Origin  string                `json:"origin"`
+	Type    string                `json:"type"`
+	Tiles   []string              `json:"tiles,omitempty"` // "z/x/y"
+	TrailID string                `json:"trail_id,omitempty"`
+	Action  string                `json:"action,omitempty"`
+	BBox    *entities.BoundingBox `json:"bbox,omitempty"`
+}
+
+// ClusterConfig holds multi-instance coordination configuration
+type ClusterConfig struct {
+	enabled    bool
+	instanceID string
+}
+
+// ClusterService keeps the per-process tile caches of several backend instances coherent.
+// Invalidations are published with PostgreSQL NOTIFY and applied by every other instance,
+// and a session-level advisory lock elects the single instance writing MBTiles snapshots.
+// When disabled, the instance works alone and is always the leader.
+type ClusterService struct {
+	cfg     ClusterConfig
+	connStr string
+
+	db       *sql.DB
+	listener *pq.Listener
+	lockConn *sql.Conn // Session holding the leader lock
+	leader   atomic.Bool
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewClusterService creates a new cluster service
+func NewClusterService(appCfg *config.Config, cfg ClusterConfig) (*ClusterService, error) {
+	c := &ClusterService{
+		cfg:      cfg,
+		connStr:  postgresConnString(appCfg),
+		stopChan: make(chan struct{}),
+	}
+
+	if !cfg.enabled {
+		c.leader.Store(true)
+		return c, nil
+	}
+
+	db, err := sql.Open("postgres", c.connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cluster connection: %w", err)
+	}
+	db.SetMaxOpenConns(3)
+	c.db = db
+
+	return c, nil
+}
+
+// Start listens for notifications from other instances and starts the leader election
+func (c *ClusterService) Start(handler interfaces.ClusterEventHandler) error {
+	if !c.cfg.enabled {
+		return nil
+	}
+
+	c.listener = pq.NewListener(c.connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Cluster listener: %v", err)
+		}
+	})
+	if err := c.listener.Listen(clusterChannel); err != nil {
+		c.listener.Close()
+		return fmt.Errorf("failed to listen on %s: %w", clusterChannel, err)
+	}
+
+	c.wg.Add(2)
+	go c.listen(handler)
+	go c.elect()
+
+	log.Printf("Cluster coordination started (instance: %s)", c.cfg.instanceID)
+	return nil
+}
+
+// Stop stops listening, releases the leader lock and closes the connections
+func (c *ClusterService) Stop() {
+	if !c.cfg.enabled {
+		return
+	}
+
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+	})
+	c.wg.Wait()
+
+	if c.listener != nil {
+		c.listener.Close()
+	}
+	c.releaseLeadership()
+	c.db.Close()
+}
+
+// IsLeader reports whether this instance writes MBTiles snapshots
+func (c *ClusterService) IsLeader() bool {
+	return c.leader.Load()
+}
+
+// InstanceID returns the identifier of this instance
+func (c *ClusterService) InstanceID() string {
+	return c.cfg.instanceID
+}
+
+// PublishInvalidation notifies other instances of invalidated tiles
+func (c *ClusterService) PublishInvalidation(tiles []entities.TileCoordinates) {
+	if !c.cfg.enabled || len(tiles) == 0 {
+		return
+	}
+
+	for start := 0; start < len(tiles); start += clusterTilesPerMessage {
+		chunk := tiles[start:min(start+clusterTilesPerMessage, len(tiles))]
+
+		keys := make([]string, len(chunk))
+		for i, t := range chunk {
+			keys[i] = fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+		}
+		c.notify(clusterMessage{Type: clusterMessageTiles, Tiles: keys})
+	}
+}
+
+// PublishTrailChange notifies other instances of a trail creation, update or deletion
+func (c *ClusterService) PublishTrailChange(trailID, action string, bbox *entities.BoundingBox) {
+	if !c.cfg.enabled {
+		return
+	}
+	c.notify(clusterMessage{Type: clusterMessageTrail, TrailID: trailID, Action: action, BBox: bbox})
+}
+
+// notify sends a message to all instances. Failures are logged: the other instances
+// catch up when their tiles are invalidated again or their cache entries expire.
+func (c *ClusterService) notify(msg clusterMessage) {
+	msg.Origin = c.cfg.instanceID
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode cluster message: %v", err)
+		return
+	}
+
+	if _, err := c.db.Exec(`SELECT pg_notify($1, $2)`, clusterChannel, string(payload)); err != nil {
+		log.Printf("Failed to publish cluster message: %v", err)
+	}
+}
+
+// listen applies notifications from other instances
+func (c *ClusterService) listen(handler interfaces.ClusterEventHandler) {
+	defer c.wg.Done()
+
+	// Detects dead connections the listener would otherwise only notice on the next notification
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+
+		case <-ping.C:
+			go c.listener.Ping()
+
+		case n := <-c.listener.NotificationChannel():
+			if n == nil {
+				// Reconnected: notifications sent meanwhile are lost
+				log.Println("Cluster listener reconnected, invalidating local tile cache")
+				handler.ApplyRemoteResync()
+				continue
+			}
+			c.apply(handler, n.Extra)
+		}
+	}
+}
+
+// apply decodes a notification and hands it to the handler, ignoring this instance's own messages
+func (c *ClusterService) apply(handler interfaces.ClusterEventHandler, payload string) {
+	var msg clusterMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		log.Printf("Ignoring invalid cluster message: %v", err)
+		return
+	}
+	if msg.Origin == c.cfg.instanceID {
+		return
+	}
+
+	switch msg.Type {
+	case clusterMessageTiles:
+		tiles := make([]entities.TileCoordinates, 0, len(msg.Tiles))
+		for _, key := range msg.Tiles {
+			if tile, ok := parseTileKey(key); ok {
+				tiles = append(tiles, tile)
+			}
+		}
+		handler.ApplyRemoteInvalidation(tiles)
+	case clusterMessageTrail:
+		handler.ApplyRemoteTrailChange(msg.TrailID, msg.Action, msg.BBox)
+	}
+}
+
+// elect tries to take the leader lock, and checks the session still holds it once taken
+func (c *ClusterService) elect() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(clusterElectionInterval)
+	defer ticker.Stop()
+
+	for {
+		c.electOnce()
+
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// electOnce runs a single election round
+func (c *ClusterService) electOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterElectionInterval)
+	defer cancel()
+
+	if c.lockConn == nil {
+		conn, err := c.db.Conn(ctx)
+		if err != nil {
+			log.Printf("Leader election: failed to connect: %v", err)
+			return
+		}
+		c.lockConn = conn
+	}
+
+	if c.leader.Load() {
+		// The lock lives as long as the session: losing the connection means losing leadership
+		if err := c.lockConn.PingContext(ctx); err != nil {
+			log.Printf("Instance %s lost leadership: %v", c.cfg.instanceID, err)
+			c.leader.Store(false)
+			c.lockConn.Close()
+			c.lockConn = nil
+		}
+		return
+	}
+
+	var acquired bool
+	if err := c.lockConn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, clusterLeaderLockKey).Scan(&acquired); err != nil {
+		log.Printf("Leader election: failed to try lock: %v", err)
+		c.lockConn.Close()
+		c.lockConn = nil
+		return
+	}
+
+	if acquired {
+		c.leader.Store(true)
+		log.Printf("Instance %s is now the snapshot leader", c.cfg.instanceID)
+	}
+}
+
+// releaseLeadership gives up the leader lock so another instance can take over right away
+func (c *ClusterService) releaseLeadership() {
+	if c.lockConn == nil {
+		return
+	}
+
+	if c.leader.Load() {
+		if _, err := c.lockConn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, clusterLeaderLockKey); err != nil {
+			log.Printf("Failed to release leader lock: %v", err)
+		}
+		c.leader.Store(false)
+	}
+	c.lockConn.Close()
+	c.lockConn = nil
+}
+
+// parseTileKey parses a "z/x/y" tile key
+func parseTileKey(key string) (entities.TileCoordinates, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return entities.TileCoordinates{}, false
+	}
+
+	z, errZ := strconv.Atoi(parts[0])
+	x, errX := strconv.Atoi(parts[1])
+	y, errY := strconv.Atoi(parts[2])
+	if errZ != nil || errX != nil || errY != nil {
+		return entities.TileCoordinates{}, false
+	}
+	return entities.TileCoordinates{Z: z, X: x, Y: y}, true
+}
+
+// Compile-time check to ensure ClusterService implements interfaces.ClusterCoordinator
+var _ interfaces.ClusterCoordinator = (*ClusterService)(nil)
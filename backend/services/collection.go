@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"bike-map/config"
+	"bike-map/entities"
 
 	"github.com/pocketbase/pocketbase/core"
 )
@@ -460,6 +461,183 @@ func (c *CollectionService) EnsureRatingAverageCollection(app core.App) error {
 	return nil
 }
 
+// EnsureSyncOutboxCollection creates the sync_outbox collection if it doesn't exist.
+// It holds PocketBase → PostGIS sync events until they are applied (see SyncOutboxService).
+func (c *CollectionService) EnsureSyncOutboxCollection(app core.App) error {
+	// Check if sync_outbox collection already exists
+	existing, err := app.FindCollectionByNameOrId("sync_outbox")
+	if err == nil {
+		// Collection already exists, add the indexes of the due events scan if missing
+		if addSyncOutboxIndexes(existing) {
+			if err := app.Save(existing); err != nil {
+				return fmt.Errorf("failed to add sync_outbox indexes: %w", err)
+			}
+			log.Println("✅ Added sync_outbox indexes")
+		}
+		return nil
+	}
+
+	// Create new collection
+	collection := core.NewBaseCollection("sync_outbox")
+
+	// Access rules - nil rules restrict all API access to superusers (events are managed by the backend)
+	collection.ListRule = nil
+	collection.ViewRule = nil
+	collection.CreateRule = nil
+	collection.UpdateRule = nil
+	collection.DeleteRule = nil
+
+	// Define schema fields
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "updated",
+		OnCreate: true,
+		OnUpdate: true,
+	})
+
+	// Event type (trail, rating, comment) and action (created, updated, deleted)
+	collection.Fields.Add(&core.TextField{
+		Name:     "entity_type",
+		Required: true,
+		Max:      20,
+	})
+	collection.Fields.Add(&core.TextField{
+		Name:     "action",
+		Required: true,
+		Max:      20,
+	})
+
+	// Plain text IDs: the referenced records may already be deleted when the event is processed
+	collection.Fields.Add(&core.TextField{
+		Name:     "trail_id",
+		Required: true,
+	})
+	collection.Fields.Add(&core.TextField{
+		Name: "record_id",
+	})
+
+	collection.Fields.Add(&core.SelectField{
+		Name:      "status",
+		Values:    []string{entities.SyncStatusPending, entities.SyncStatusDead},
+		MaxSelect: 1,
+		Required:  true,
+	})
+
+	// Retry state
+	collection.Fields.Add(&core.NumberField{
+		Name:    "attempts",
+		Min:     float64Ptr(0),
+		OnlyInt: true,
+	})
+	collection.Fields.Add(&core.DateField{
+		Name: "next_attempt_at",
+	})
+	collection.Fields.Add(&core.TextField{
+		Name: "last_error",
+	})
+
+	// Index for listing events by status
+	collection.AddIndex("idx_sync_outbox_status_created", false, "status,created", "")
+	addSyncOutboxIndexes(collection)
+
+	// Save collection
+	if err := app.Save(collection); err != nil {
+		return fmt.Errorf("failed to create sync_outbox collection: %w", err)
+	}
+
+	log.Println("✅ Created sync_outbox collection successfully")
+	return nil
+}
+
+// addSyncOutboxIndexes adds the indexes of the worker's due events scan: events by retry time,
+// and the earlier or dead events of a trail. It reports whether any index was missing.
+func addSyncOutboxIndexes(collection *core.Collection) bool {
+	indexes := []struct{ name, columns string }{
+		{"idx_sync_outbox_status_next_attempt", "status,next_attempt_at"},
+		{"idx_sync_outbox_trail_status", "trail_id,status,created"},
+	}
+
+	added := false
+	for _, index := range indexes {
+		if collection.GetIndex(index.name) == "" {
+			collection.AddIndex(index.name, false, index.columns, "")
+			added = true
+		}
+	}
+	return added
+}
+
+// EnsureChangeLogCollection creates the change_log collection if it doesn't exist.
+// It holds the numbered trail, rating and comment changes served by the change feed (see ChangeLogService).
+func (c *CollectionService) EnsureChangeLogCollection(app core.App) error {
+	// Check if change_log collection already exists
+	_, err := app.FindCollectionByNameOrId("change_log")
+	if err == nil {
+		// Collection already exists
+		return nil
+	}
+
+	// Create new collection
+	collection := core.NewBaseCollection("change_log")
+
+	// Access rules - nil rules restrict all API access to superusers (clients read it through /api/changes)
+	collection.ListRule = nil
+	collection.ViewRule = nil
+	collection.CreateRule = nil
+	collection.UpdateRule = nil
+	collection.DeleteRule = nil
+
+	// Define schema fields
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	// Sequence number, the change feed cursor
+	collection.Fields.Add(&core.NumberField{
+		Name:     "seq",
+		Required: true,
+		Min:      float64Ptr(1),
+		OnlyInt:  true,
+	})
+
+	// Change type (trail, rating, comment) and action (created, updated, deleted)
+	collection.Fields.Add(&core.TextField{
+		Name:     "entity_type",
+		Required: true,
+		Max:      20,
+	})
+	collection.Fields.Add(&core.TextField{
+		Name:     "action",
+		Required: true,
+		Max:      20,
+	})
+
+	// Plain text IDs: tombstones outlive the records they refer to
+	collection.Fields.Add(&core.TextField{
+		Name:     "trail_id",
+		Required: true,
+	})
+	collection.Fields.Add(&core.TextField{
+		Name:     "record_id",
+		Required: true,
+	})
+
+	// Unique index for the cursor scans, also guarding against duplicate sequence numbers
+	collection.AddIndex("idx_change_log_seq", true, "seq", "")
+
+	// Save collection
+	if err := app.Save(collection); err != nil {
+		return fmt.Errorf("failed to create change_log collection: %w", err)
+	}
+
+	log.Println("✅ Created change_log collection successfully")
+	return nil
+}
+
 // Helper function to create float64 pointer
 func float64Ptr(f float64) *float64 {
 	return &f
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"bike-map/config"
+	"bike-map/interfaces"
+)
+
+// downloadRateWindow is the period over which downloads are counted for the rate limit
+const downloadRateWindow = time.Hour
+
+// DownloadAccessConfig holds snapshot download access configuration
+type DownloadAccessConfig struct {
+	mode       string // config.DownloadAccess* constant
+	signingKey []byte
+	urlTTL     time.Duration
+	rateLimit  int // Downloads started per user and window, 0 for no limit
+}
+
+// DownloadAccessService restricts snapshot downloads to authenticated users or signed
+// expiring URLs, and limits the number of downloads each user starts per hour.
+// Signed URLs let download managers that cannot send credentials fetch large files.
+type DownloadAccessService struct {
+	cfg DownloadAccessConfig
+
+	mu        sync.Mutex
+	started   map[string][]time.Time // Download start times within the window, oldest first
+	admitted  map[string]time.Time   // Last start of each resumable download, by key and ETag
+	lastSweep time.Time
+}
+
+// NewDownloadAccessService creates a new download access service
+func NewDownloadAccessService(cfg DownloadAccessConfig) *DownloadAccessService {
+	return &DownloadAccessService{
+		cfg:       cfg,
+		started:   make(map[string][]time.Time),
+		admitted:  make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// RequiresAuth reports whether anonymous downloads are rejected
+func (s *DownloadAccessService) RequiresAuth() bool {
+	return s.cfg.mode != config.DownloadAccessPublic
+}
+
+// SignedURLs reports whether signed download URLs are issued and accepted
+func (s *DownloadAccessService) SignedURLs() bool {
+	return s.cfg.mode == config.DownloadAccessSigned
+}
+
+// Sign returns target with an expiring signature granting userID access to it.
+// The signature covers the path and every query parameter.
+func (s *DownloadAccessService) Sign(target *url.URL, userID string) (*url.URL, time.Time) {
+	expires := time.Now().Add(s.cfg.urlTTL).Truncate(time.Second)
+
+	query := target.Query()
+	query.Del("sig")
+	query.Set("uid", userID)
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", s.signature(target.Path, query))
+
+	signed := &url.URL{Path: target.Path, RawQuery: query.Encode()}
+	return signed, expires
+}
+
+// Verify checks the signature of a request URL and returns the user it was issued to
+func (s *DownloadAccessService) Verify(u *url.URL) (string, error) {
+	query := u.Query()
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || len(sig) == 0 {
+		return "", interfaces.ErrInvalidSignature
+	}
+	query.Del("sig")
+
+	expected, _ := hex.DecodeString(s.signature(u.Path, query))
+	if !hmac.Equal(sig, expected) {
+		return "", interfaces.ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return "", interfaces.ErrInvalidSignature
+	}
+	if time.Now().Unix() > expires {
+		return "", interfaces.ErrSignatureExpired
+	}
+
+	return query.Get("uid"), nil
+}
+
+// signature computes the HMAC of a path and its query parameters (without sig)
+func (s *DownloadAccessService) signature(path string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.cfg.signingKey)
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Allow records a download started by key, and returns how long to wait if over the limit.
+// etag identifies the downloaded file for later resumes, empty if it cannot be resumed.
+func (s *DownloadAccessService) Allow(key, etag string) (bool, time.Duration) {
+	if s.cfg.rateLimit <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-downloadRateWindow)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget idle clients now and then, so the map does not grow with every client seen
+	if now.Sub(s.lastSweep) > downloadRateWindow {
+		for k, times := range s.started {
+			if len(times) == 0 || times[len(times)-1].Before(cutoff) {
+				delete(s.started, k)
+			}
+		}
+		for k, started := range s.admitted {
+			if started.Before(cutoff) {
+				delete(s.admitted, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	times := s.started[key]
+	expired := 0
+	for expired < len(times) && times[expired].Before(cutoff) {
+		expired++
+	}
+	times = times[expired:]
+
+	if len(times) >= s.cfg.rateLimit {
+		s.started[key] = times
+		return false, times[0].Sub(cutoff)
+	}
+	s.started[key] = append(times, now)
+	if etag != "" {
+		s.admitted[key+"|"+etag] = now
+	}
+	return true, 0
+}
+
+// Admitted reports whether key started a download of the file with etag within the rate window,
+// so interrupted downloads can be resumed without counting against the rate limit
+func (s *DownloadAccessService) Admitted(key, etag string) bool {
+	if s.cfg.rateLimit <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	started, ok := s.admitted[key+"|"+etag]
+	return ok && time.Since(started) < downloadRateWindow
+}
+
+// Compile-time check to ensure DownloadAccessService implements interfaces.DownloadAccess
+var _ interfaces.DownloadAccess = (*DownloadAccessService)(nil)
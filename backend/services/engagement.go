@@ -7,7 +7,9 @@ import (
 	"bike-map/entities"
 	"bike-map/interfaces"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 // EngagementService handles all engagement-related operations (ratings, comments)
@@ -49,6 +51,180 @@ func (s *EngagementService) GetEngagementStats(ctx context.Context, trailID stri
 	}, nil
 }
 
+// GetRatingDistribution returns the number of ratings of a trail per star, from 1 to 5
+func (s *EngagementService) GetRatingDistribution(ctx context.Context, trailID string) (map[int]int, error) {
+	var rows []struct {
+		Stars int `db:"stars"`
+		Count int `db:"count"`
+	}
+	err := s.app.DB().
+		Select("CAST(ROUND(rating) AS INTEGER) AS stars", "COUNT(*) AS count").
+		From("trail_ratings").
+		Where(dbx.HashExp{"trail": trailID}).
+		GroupBy("stars").
+		WithContext(ctx).
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rating distribution: %w", err)
+	}
+
+	distribution := map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
+	for _, row := range rows {
+		if _, ok := distribution[row.Stars]; ok {
+			distribution[row.Stars] = row.Count
+		}
+	}
+	return distribution, nil
+}
+
+// GetEngagementStatsBulk gets engagement statistics for the given trails, or for all trails when trailIDs is empty.
+// Trails without ratings or comments are omitted from the result.
+func (s *EngagementService) GetEngagementStatsBulk(ctx context.Context, trailIDs []string) (map[string]*entities.EngagementStats, error) {
+	var ratings []struct {
+		Trail   string  `db:"trail"`
+		Average float64 `db:"average"`
+		Count   int     `db:"count"`
+	}
+	ratingQuery := s.app.DB().Select("trail", "average", "count").From("rating_average").WithContext(ctx)
+	if len(trailIDs) > 0 {
+		ratingQuery.Where(dbx.In("trail", toAnySlice(trailIDs)...))
+	}
+	if err := ratingQuery.All(&ratings); err != nil {
+		return nil, fmt.Errorf("failed to get rating averages: %w", err)
+	}
+
+	var comments []struct {
+		Trail string `db:"trail"`
+		Count int    `db:"count"`
+	}
+	commentQuery := s.app.DB().Select("trail", "COUNT(*) AS count").From("trail_comments").GroupBy("trail").WithContext(ctx)
+	if len(trailIDs) > 0 {
+		commentQuery.Where(dbx.In("trail", toAnySlice(trailIDs)...))
+	}
+	if err := commentQuery.All(&comments); err != nil {
+		return nil, fmt.Errorf("failed to count comments: %w", err)
+	}
+
+	stats := make(map[string]*entities.EngagementStats, len(ratings))
+	get := func(trailID string) *entities.EngagementStats {
+		if st, ok := stats[trailID]; ok {
+			return st
+		}
+		st := &entities.EngagementStats{TrailID: trailID}
+		stats[trailID] = st
+		return st
+	}
+	for _, r := range ratings {
+		st := get(r.Trail)
+		st.RatingAvg = r.Average
+		st.RatingCount = r.Count
+	}
+	for _, c := range comments {
+		get(c.Trail).CommentCount = c.Count
+	}
+
+	return stats, nil
+}
+
+// GetLatestComments returns the most recent comments of each trail, newest first, with their authors' names
+func (s *EngagementService) GetLatestComments(ctx context.Context, trailIDs []string, perTrail int) (map[string][]entities.TrailComment, error) {
+	result := make(map[string][]entities.TrailComment)
+	if len(trailIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		ID       string         `db:"id"`
+		Trail    string         `db:"trail"`
+		User     string         `db:"user"`
+		UserName string         `db:"user_name"`
+		Comment  string         `db:"comment"`
+		Created  types.DateTime `db:"created"`
+		Updated  types.DateTime `db:"updated"`
+	}
+	err := s.app.DB().
+		Select("c.id", "c.trail", "c.user", "COALESCE(u.name, '') AS user_name", "c.comment", "c.created", "c.updated").
+		From("trail_comments c").
+		LeftJoin("users u", dbx.NewExp("u.id = c.user")).
+		Where(dbx.In("c.trail", toAnySlice(trailIDs)...)).
+		OrderBy("c.trail", "c.created DESC").
+		WithContext(ctx).
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest comments: %w", err)
+	}
+
+	for _, row := range rows {
+		if len(result[row.Trail]) >= perTrail {
+			continue
+		}
+		comment := entities.TrailComment{
+			ID:       row.ID,
+			TrailID:  row.Trail,
+			UserID:   row.User,
+			UserName: row.UserName,
+			Comment:  row.Comment,
+			Created:  row.Created.Time(),
+			Updated:  row.Updated.Time(),
+		}
+		// "updated" is only set once a comment is edited
+		if row.Updated.IsZero() {
+			comment.Updated = comment.Created
+		}
+		result[row.Trail] = append(result[row.Trail], comment)
+	}
+
+	return result, nil
+}
+
+// ComputeRatingAverages aggregates trail_ratings per trail, independently of the rating_average collection
+func (s *EngagementService) ComputeRatingAverages(ctx context.Context) (map[string]*entities.RatingAverage, error) {
+	var rows []struct {
+		Trail   string  `db:"trail"`
+		Average float64 `db:"average"`
+		Count   int     `db:"count"`
+	}
+	err := s.app.DB().
+		Select("trail", "AVG(rating) AS average", "COUNT(*) AS count").
+		From("trail_ratings").
+		GroupBy("trail").
+		WithContext(ctx).
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate ratings: %w", err)
+	}
+
+	averages := make(map[string]*entities.RatingAverage, len(rows))
+	for _, r := range rows {
+		averages[r.Trail] = &entities.RatingAverage{TrailID: r.Trail, Average: r.Average, Count: r.Count}
+	}
+	return averages, nil
+}
+
+// GetRatingAverageRecords returns the stored rating_average records keyed by trail ID
+func (s *EngagementService) GetRatingAverageRecords(ctx context.Context) (map[string]*entities.RatingAverage, error) {
+	var rows []struct {
+		ID      string  `db:"id"`
+		Trail   string  `db:"trail"`
+		Average float64 `db:"average"`
+		Count   int     `db:"count"`
+	}
+	err := s.app.DB().
+		Select("id", "trail", "average", "count").
+		From("rating_average").
+		WithContext(ctx).
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rating averages: %w", err)
+	}
+
+	records := make(map[string]*entities.RatingAverage, len(rows))
+	for _, r := range rows {
+		records[r.Trail] = &entities.RatingAverage{ID: r.ID, TrailID: r.Trail, Average: r.Average, Count: r.Count}
+	}
+	return records, nil
+}
+
 // UpdateRatingAverage updates the rating average for a trail
 func (s *EngagementService) UpdateRatingAverage(app core.App, trailId string) error {
 	ctx := context.Background()
@@ -295,5 +471,14 @@ func (s *EngagementService) recordToRatingAverage(record *core.Record) *entities
 	}
 }
 
+// toAnySlice converts string values for use in dbx expressions
+func toAnySlice(values []string) []any {
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
 // Compile-time check to ensure EngagementService implements interfaces.EngagementService
 var _ interfaces.Engagement = (*EngagementService)(nil)
@@ -1,7 +1,6 @@
 package services
 
 import (
-	"context"
 	"encoding/xml"
 	"fmt"
 	"log"
@@ -15,18 +14,21 @@ import (
 
 // HookManagerService manages all PocketBase event hooks with proper decoupling
 type HookManagerService struct {
-	authService          *AuthService
-	orchestrationService *OrchestrationService
+	authService *AuthService
+	syncOutbox  *SyncOutboxService
+	changeLog   *ChangeLogService
 }
 
 // NewHookManagerService creates a new hook manager service
 func NewHookManagerService(
 	authService *AuthService,
-	orchestrationService *OrchestrationService,
+	syncOutbox *SyncOutboxService,
+	changeLog *ChangeLogService,
 ) *HookManagerService {
 	return &HookManagerService{
-		authService:          authService,
-		orchestrationService: orchestrationService,
+		authService: authService,
+		syncOutbox:  syncOutbox,
+		changeLog:   changeLog,
 	}
 }
 
@@ -34,7 +36,7 @@ func NewHookManagerService(
 func (h *HookManagerService) SetupAllHooks(app core.App) {
 	h.setupUserHooks(app)
 	h.setupTrailHooks(app)
-	h.setupEngagementHooks(app)
+	h.setupChangeHooks(app)
 	h.setupFileDownloadHooks(app)
 }
 
@@ -105,76 +107,101 @@ func (h *HookManagerService) setupTrailHooks(app core.App) {
 		}
 		return e.Next()
 	})
-
-	// Trail lifecycle hooks
-	if h.orchestrationService != nil {
-		// After trail creation
-		app.OnRecordAfterCreateSuccess().BindFunc(func(e *core.RecordEvent) error {
-			if e.Record.Collection().Name == "trails" {
-				h.handleTrailCreated(app, e.Record)
-			}
-			return e.Next()
-		})
-
-		// After trail update
-		app.OnRecordAfterUpdateSuccess().BindFunc(func(e *core.RecordEvent) error {
-			if e.Record.Collection().Name == "trails" {
-				h.handleTrailUpdated(app, e.Record)
-			}
-			return e.Next()
-		})
-
-		// After trail deletion
-		app.OnRecordAfterDeleteSuccess().BindFunc(func(e *core.RecordEvent) error {
-			if e.Record.Collection().Name == "trails" {
-				h.handleTrailDeleted(e.Record)
-			}
-			return e.Next()
-		})
-	}
 }
 
-// setupEngagementHooks configures engagement-related hooks (ratings and comments)
-func (h *HookManagerService) setupEngagementHooks(app core.App) {
-	if h.orchestrationService == nil {
+// setupChangeHooks records trail, rating and comment changes in the sync outbox and the change log,
+// in the same transaction as the change itself. The outbox worker applies them to PostGIS with
+// retries, and clients read the change log through the change feed.
+func (h *HookManagerService) setupChangeHooks(app core.App) {
+	if h.syncOutbox == nil && h.changeLog == nil {
 		return
 	}
 
-	// Rating hooks
-	app.OnRecordAfterCreateSuccess().BindFunc(func(e *core.RecordEvent) error {
-		if e.Record.Collection().Name == "trail_ratings" {
-			h.handleRatingCreated(app, e.Record)
+	app.OnRecordCreateExecute().BindFunc(func(e *core.RecordEvent) error {
+		if entityType, ok := changeEntityType(e.Record); ok {
+			return h.recordChange(e, entityType, entities.SyncActionCreated)
 		}
 		return e.Next()
 	})
 
-	app.OnRecordAfterUpdateSuccess().BindFunc(func(e *core.RecordEvent) error {
-		if e.Record.Collection().Name == "trail_ratings" {
-			h.handleRatingUpdated(app, e.Record)
+	app.OnRecordUpdateExecute().BindFunc(func(e *core.RecordEvent) error {
+		if entityType, ok := changeEntityType(e.Record); ok {
+			return h.recordChange(e, entityType, entities.SyncActionUpdated)
 		}
 		return e.Next()
 	})
 
-	app.OnRecordAfterDeleteSuccess().BindFunc(func(e *core.RecordEvent) error {
-		if e.Record.Collection().Name == "trail_ratings" {
-			h.handleRatingDeleted(app, e.Record)
+	app.OnRecordDeleteExecute().BindFunc(func(e *core.RecordEvent) error {
+		if entityType, ok := changeEntityType(e.Record); ok {
+			return h.recordChange(e, entityType, entities.SyncActionDeleted)
 		}
 		return e.Next()
 	})
 
-	// Comment hooks
-	app.OnRecordAfterCreateSuccess().BindFunc(func(e *core.RecordEvent) error {
-		if e.Record.Collection().Name == "trail_comments" {
-			h.handleCommentCreated(app, e.Record)
+	if h.syncOutbox == nil {
+		return
+	}
+
+	// Wake the outbox worker once a change is committed
+	notify := func(e *core.RecordEvent) error {
+		switch e.Record.Collection().Name {
+		case "trails", "trail_ratings", "trail_comments":
+			h.syncOutbox.Notify()
 		}
 		return e.Next()
-	})
+	}
+	app.OnRecordAfterCreateSuccess().BindFunc(notify)
+	app.OnRecordAfterUpdateSuccess().BindFunc(notify)
+	app.OnRecordAfterDeleteSuccess().BindFunc(notify)
+}
 
-	app.OnRecordAfterDeleteSuccess().BindFunc(func(e *core.RecordEvent) error {
-		if e.Record.Collection().Name == "trail_comments" {
-			h.handleCommentDeleted(app, e.Record)
-		}
+// changeEntityType returns the sync entity type of trail, rating and comment records
+func changeEntityType(record *core.Record) (string, bool) {
+	switch record.Collection().Name {
+	case "trails":
+		return entities.SyncEntityTrail, true
+	case "trail_ratings":
+		return entities.SyncEntityRating, true
+	case "trail_comments":
+		return entities.SyncEntityComment, true
+	}
+	return "", false
+}
+
+// recordChange runs the record change and records its sync event and change log entry in a single transaction
+func (h *HookManagerService) recordChange(e *core.RecordEvent, entityType, action string) error {
+	trailID := e.Record.Id
+	if entityType != entities.SyncEntityTrail {
+		trailID = e.Record.GetString("trail")
+	}
+	if trailID == "" {
+		log.Printf("Warning: %s %s without trail ID", entityType, action)
+		return e.Next()
+	}
+
+	// Comment edits leave the engagement stats in PostGIS unchanged
+	enqueue := h.syncOutbox != nil && !(entityType == entities.SyncEntityComment && action == entities.SyncActionUpdated)
+	if !enqueue && h.changeLog == nil {
 		return e.Next()
+	}
+
+	originalApp := e.App
+	return originalApp.RunInTransaction(func(txApp core.App) error {
+		e.App = txApp
+		defer func() { e.App = originalApp }()
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if enqueue {
+			if err := h.syncOutbox.Enqueue(txApp, entityType, action, trailID, e.Record.Id); err != nil {
+				return err
+			}
+		}
+		if h.changeLog != nil {
+			return h.changeLog.Record(txApp, entityType, action, trailID, e.Record.Id)
+		}
+		return nil
 	})
 }
 
@@ -258,82 +285,3 @@ func sanitizeFilename(name string) string {
 	}
 	return sanitized
 }
-
-// Trail event handlers - delegate to OrchestrationService
-
-func (h *HookManagerService) handleTrailCreated(app core.App, record *core.Record) {
-	if err := h.orchestrationService.HandleTrailCreated(context.Background(), app, record.Id); err != nil {
-		log.Printf("Failed to handle trail creation %s: %v", record.Id, err)
-	}
-}
-
-func (h *HookManagerService) handleTrailUpdated(app core.App, record *core.Record) {
-	if err := h.orchestrationService.HandleTrailUpdated(context.Background(), app, record.Id); err != nil {
-		log.Printf("Failed to handle trail update %s: %v", record.Id, err)
-	}
-}
-
-func (h *HookManagerService) handleTrailDeleted(record *core.Record) {
-	if err := h.orchestrationService.HandleTrailDeleted(context.Background(), record.Id); err != nil {
-		log.Printf("Failed to handle trail deletion %s: %v", record.Id, err)
-	}
-}
-
-// Rating event handlers - delegate to OrchestrationService
-
-func (h *HookManagerService) handleRatingCreated(app core.App, record *core.Record) {
-	trailID := record.GetString("trail")
-	if trailID == "" {
-		log.Printf("Warning: Rating created without trail ID")
-		return
-	}
-	if err := h.orchestrationService.HandleRatingCreated(context.Background(), app, trailID); err != nil {
-		log.Printf("Failed to handle rating creation for trail %s: %v", trailID, err)
-	}
-}
-
-func (h *HookManagerService) handleRatingUpdated(app core.App, record *core.Record) {
-	trailID := record.GetString("trail")
-	if trailID == "" {
-		log.Printf("Warning: Rating updated without trail ID")
-		return
-	}
-	if err := h.orchestrationService.HandleRatingUpdated(context.Background(), app, trailID); err != nil {
-		log.Printf("Failed to handle rating update for trail %s: %v", trailID, err)
-	}
-}
-
-func (h *HookManagerService) handleRatingDeleted(app core.App, record *core.Record) {
-	trailID := record.GetString("trail")
-	if trailID == "" {
-		log.Printf("Warning: Rating deleted without trail ID")
-		return
-	}
-	if err := h.orchestrationService.HandleRatingDeleted(context.Background(), app, trailID); err != nil {
-		log.Printf("Failed to handle rating deletion for trail %s: %v", trailID, err)
-	}
-}
-
-// Comment event handlers - delegate to OrchestrationService
-
-func (h *HookManagerService) handleCommentCreated(app core.App, record *core.Record) {
-	trailID := record.GetString("trail")
-	if trailID == "" {
-		log.Printf("Warning: Comment created without trail ID")
-		return
-	}
-	if err := h.orchestrationService.HandleCommentCreated(context.Background(), app, trailID); err != nil {
-		log.Printf("Failed to handle comment creation for trail %s: %v", trailID, err)
-	}
-}
-
-func (h *HookManagerService) handleCommentDeleted(app core.App, record *core.Record) {
-	trailID := record.GetString("trail")
-	if trailID == "" {
-		log.Printf("Warning: Comment deleted without trail ID")
-		return
-	}
-	if err := h.orchestrationService.HandleCommentDeleted(context.Background(), app, trailID); err != nil {
-		log.Printf("Failed to handle comment deletion for trail %s: %v", trailID, err)
-	}
-}
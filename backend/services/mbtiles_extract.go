@@ -0,0 +1,457 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/utils"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Cached file name prefixes
+const (
+	mbtilesExtractPrefix = "extract-"
+	mbtilesDeltaPrefix   = "delta-"
+)
+
+// MBTilesExtractConfig holds regional extract configuration
+type MBTilesExtractConfig struct {
+	snapshotDir   string
+	cacheSize     int   // Extracts kept on disk, least recently used ones are removed first
+	cacheMaxBytes int64 // Total size of the extracts kept on disk
+	regions       map[string]entities.BoundingBox
+	minZoom       int
+	maxZoom       int
+}
+
+// MBTilesExtractService builds MBTiles files from the latest snapshot: regional extracts holding
+// the tiles within a bounding box up to a maximum zoom, and deltas holding the tiles changed since
+// an older snapshot. Both are cached by snapshot and parameters, so repeated downloads are served from disk.
+type MBTilesExtractService struct {
+	cfg      MBTilesExtractConfig
+	cacheDir string
+
+	// Resolves trail bounding boxes, nil while PostGIS is unavailable
+	mu        sync.RWMutex
+	generator interfaces.MVTGenerator
+
+	builds singleflight.Group
+}
+
+// NewMBTilesExtractService creates a new extract service
+func NewMBTilesExtractService(cfg MBTilesExtractConfig) *MBTilesExtractService {
+	return &MBTilesExtractService{
+		cfg:      cfg,
+		cacheDir: filepath.Join(cfg.snapshotDir, "extracts"),
+	}
+}
+
+// SetGenerator sets the generator used to look up trail bounding boxes
+func (s *MBTilesExtractService) SetGenerator(generator interfaces.MVTGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generator = generator
+}
+
+// Regions returns the named regions available for extracts
+func (s *MBTilesExtractService) Regions() map[string]entities.BoundingBox {
+	return s.cfg.regions
+}
+
+// Extract returns an extract of the latest snapshot, building it unless it is cached
+func (s *MBTilesExtractService) Extract(ctx context.Context, req entities.MBTilesExtractRequest) (*entities.MBTilesExtract, error) {
+	bbox, err := s.resolveBBox(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	maxZoom := s.cfg.maxZoom
+	if req.MaxZoom > 0 {
+		maxZoom = min(max(req.MaxZoom, s.cfg.minZoom), s.cfg.maxZoom)
+	}
+
+	snapshotPath, err := latestSnapshotPath(s.cfg.snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotPath == "" {
+		return nil, interfaces.ErrNoSnapshot
+	}
+
+	return s.extractSnapshot(snapshotPath, bbox, maxZoom)
+}
+
+// extractSnapshot returns an extract of a snapshot, building it unless it is cached.
+// The area is snapped to the tile grid at maxZoom, so requests for areas covered by the same
+// tiles share an extract.
+func (s *MBTilesExtractService) extractSnapshot(snapshotPath string, bbox entities.BoundingBox, maxZoom int) (*entities.MBTilesExtract, error) {
+	tiles, bbox := snapToTiles(bbox, maxZoom)
+
+	// Extracts of an older snapshot are never reused: the snapshot is part of the key
+	key := fmt.Sprintf("%s|%d/%d-%d/%d-%d",
+		filepath.Base(snapshotPath), maxZoom, tiles.MinX, tiles.MaxX, tiles.MinY, tiles.MaxY)
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:8])
+
+	extract := &entities.MBTilesExtract{
+		Path:     filepath.Join(s.cacheDir, mbtilesExtractPrefix+id+".mbtiles"),
+		Filename: fmt.Sprintf("%sextract-%s.mbtiles", entities.MBtilesFilePrefix, id),
+		MaxZoom:  maxZoom,
+	}
+
+	if _, err := os.Stat(extract.Path); err == nil {
+		// Recently used extracts are kept longest
+		now := time.Now()
+		_ = os.Chtimes(extract.Path, now, now)
+		extract.Cached = true
+		return extract, nil
+	}
+
+	// Concurrent requests for the same extract share a single build
+	_, err, _ := s.builds.Do(id, func() (any, error) {
+		return nil, s.build(snapshotPath, extract.Path, bbox, maxZoom)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.pruneCache()
+	return extract, nil
+}
+
+// snapToTiles returns the tiles covering a bounding box at zoom z, and the box of their outer
+// edges. The box is inset by a fraction of a tile so its edges do not reach into neighbouring tiles.
+func snapToTiles(bbox entities.BoundingBox, z int) (utils.TileRange, entities.BoundingBox) {
+	tiles := utils.TileRangeForBBox(bbox, z)
+	nw := utils.TileBounds(entities.TileCoordinates{Z: z, X: tiles.MinX, Y: tiles.MinY})
+	se := utils.TileBounds(entities.TileCoordinates{Z: z, X: tiles.MaxX, Y: tiles.MaxY})
+
+	insetX := (nw.East - nw.West) / 1000
+	insetY := (se.North - se.South) / 1000
+	return tiles, entities.BoundingBox{
+		West:  nw.West + insetX,
+		North: nw.North - insetY,
+		East:  se.East - insetX,
+		South: se.South + insetY,
+	}
+}
+
+// resolveBBox returns the area of an extract request
+func (s *MBTilesExtractService) resolveBBox(ctx context.Context, req entities.MBTilesExtractRequest) (entities.BoundingBox, error) {
+	switch {
+	case req.BBox != nil:
+		return *req.BBox, nil
+
+	case req.Region != "":
+		region, ok := s.cfg.regions[strings.ToLower(req.Region)]
+		if !ok {
+			return entities.BoundingBox{}, interfaces.ErrUnknownRegion
+		}
+		return region, nil
+
+	case req.TrailID != "":
+		s.mu.RLock()
+		generator := s.generator
+		s.mu.RUnlock()
+		if generator == nil {
+			return entities.BoundingBox{}, interfaces.ErrTrailLookupUnavailable
+		}
+
+		bbox, err := generator.GetTrailBBox(ctx, req.TrailID)
+		if err != nil {
+			return entities.BoundingBox{}, err
+		}
+		if bbox == nil {
+			return entities.BoundingBox{}, interfaces.ErrTrailNotFound
+		}
+		return *bbox, nil
+
+	default:
+		return entities.BoundingBox{}, fmt.Errorf("extract area not specified")
+	}
+}
+
+// build writes the extract to path. Its metadata bounds are the requested area clipped to the snapshot bounds.
+func (s *MBTilesExtractService) build(snapshotPath, path string, bbox entities.BoundingBox, maxZoom int) error {
+	return buildFromSnapshot(snapshotPath, path, func(ctx context.Context, conn *sql.Conn) error {
+		// The snapshot bounds hold the trails extent: the extract covers no more than that
+		var snapshotBounds string
+		if err := conn.QueryRowContext(ctx, `SELECT value FROM snapshot.metadata WHERE name = 'bounds'`).Scan(&snapshotBounds); err == nil {
+			if v, err := parseFloats(snapshotBounds, 4); err == nil {
+				clipped := entities.BoundingBox{
+					West:  max(bbox.West, v[0]),
+					South: max(bbox.South, v[1]),
+					East:  min(bbox.East, v[2]),
+					North: min(bbox.North, v[3]),
+				}
+				if clipped.West < clipped.East && clipped.South < clipped.North {
+					bbox = clipped
+				}
+			}
+		}
+
+		tiles := 0
+		for z := s.cfg.minZoom; z <= maxZoom; z++ {
+			r := utils.TileRangeForBBox(bbox, z)
+			result, err := conn.ExecContext(ctx, `
+				INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data)
+				SELECT zoom_level, tile_column, tile_row, tile_data FROM snapshot.tiles
+				WHERE zoom_level = ? AND tile_column BETWEEN ? AND ? AND tile_row BETWEEN ? AND ?`,
+				z, r.MinX, r.MaxX, xyzToTMS(z, r.MaxY), xyzToTMS(z, r.MinY))
+			if err != nil {
+				return fmt.Errorf("failed to copy zoom %d tiles: %w", z, err)
+			}
+			count, _ := result.RowsAffected()
+			tiles += int(count)
+		}
+
+		log.Printf("MBTiles extract built: %s - %d tiles up to z%d", filepath.Base(path), tiles, maxZoom)
+		return writeMBTilesMetadata(conn, mbtilesMetadata(s.cfg.minZoom, maxZoom, &bbox))
+	})
+}
+
+// Delta returns the tiles of the latest snapshot changed since a client's snapshot, identified by
+// the journal_epoch and journal_seq values of its metadata. The delta is an MBTiles file holding
+// the changed tiles, a tombstones table listing deleted tiles, and the metadata of the latest
+// snapshot: clients replace their tiles, delete the tombstones and replace their metadata.
+func (s *MBTilesExtractService) Delta(ctx context.Context, epoch string, since int64) (*entities.MBTilesExtract, error) {
+	snapshotPath, err := latestSnapshotPath(s.cfg.snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotPath == "" {
+		return nil, interfaces.ErrNoSnapshot
+	}
+
+	latestEpoch, latestSeq, err := readSnapshotJournal(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	if epoch != latestEpoch || since < 0 || since > latestSeq {
+		return nil, interfaces.ErrDeltaUnavailable
+	}
+
+	key := fmt.Sprintf("%s|%s|%d", filepath.Base(snapshotPath), epoch, since)
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:8])
+
+	delta := &entities.MBTilesExtract{
+		Path:     filepath.Join(s.cacheDir, mbtilesDeltaPrefix+id+".mbtiles"),
+		Filename: fmt.Sprintf("%sdelta-%d-%d.mbtiles", entities.MBtilesFilePrefix, since, latestSeq),
+		MaxZoom:  s.cfg.maxZoom,
+	}
+
+	if _, err := os.Stat(delta.Path); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(delta.Path, now, now)
+		delta.Cached = true
+		return delta, nil
+	}
+
+	_, err, _ = s.builds.Do(id, func() (any, error) {
+		return nil, buildFromSnapshot(snapshotPath, delta.Path, func(ctx context.Context, conn *sql.Conn) error {
+			if _, err := conn.ExecContext(ctx, `
+				CREATE TABLE tombstones (
+					zoom_level INTEGER,
+					tile_column INTEGER,
+					tile_row INTEGER
+				)`); err != nil {
+				return fmt.Errorf("failed to create tombstones table: %w", err)
+			}
+
+			// Journaled tiles that are missing or empty in the snapshot were deleted
+			changed, err := conn.ExecContext(ctx, `
+				INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data)
+				SELECT t.zoom_level, t.tile_column, t.tile_row, t.tile_data
+				FROM snapshot.tile_journal j
+				JOIN snapshot.tiles t USING (zoom_level, tile_column, tile_row)
+				WHERE j.seq > ? AND length(t.tile_data) > 0`, since)
+			if err != nil {
+				return fmt.Errorf("failed to copy changed tiles: %w", err)
+			}
+			deleted, err := conn.ExecContext(ctx, `
+				INSERT INTO tombstones (zoom_level, tile_column, tile_row)
+				SELECT j.zoom_level, j.tile_column, j.tile_row
+				FROM snapshot.tile_journal j
+				LEFT JOIN snapshot.tiles t USING (zoom_level, tile_column, tile_row)
+				WHERE j.seq > ? AND (t.tile_data IS NULL OR length(t.tile_data) = 0)`, since)
+			if err != nil {
+				return fmt.Errorf("failed to copy deleted tiles: %w", err)
+			}
+
+			if _, err := conn.ExecContext(ctx, `
+				INSERT OR REPLACE INTO metadata (name, value)
+				SELECT name, value FROM snapshot.metadata`); err != nil {
+				return fmt.Errorf("failed to copy metadata: %w", err)
+			}
+
+			changedCount, _ := changed.RowsAffected()
+			deletedCount, _ := deleted.RowsAffected()
+			log.Printf("MBTiles delta built: %s - %d changed, %d deleted tiles since %d",
+				filepath.Base(delta.Path), changedCount, deletedCount, since)
+			return writeMBTilesMetadata(conn, map[string]string{"delta_since_seq": strconv.FormatInt(since, 10)})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.pruneCache()
+	return delta, nil
+}
+
+// readSnapshotJournal reads the change journal position recorded in a snapshot
+func readSnapshotJournal(snapshotPath string) (string, int64, error) {
+	db, err := sql.Open("sqlite", snapshotPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer db.Close()
+
+	var epoch, seq sql.NullString
+	err = db.QueryRow(`
+		SELECT
+			(SELECT value FROM metadata WHERE name = 'journal_epoch'),
+			(SELECT value FROM metadata WHERE name = 'journal_seq')
+	`).Scan(&epoch, &seq)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read snapshot journal position: %w", err)
+	}
+	if !epoch.Valid || !seq.Valid {
+		// Written before the change journal existed
+		return "", 0, interfaces.ErrDeltaUnavailable
+	}
+
+	journalSeq, err := strconv.ParseInt(seq.String, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid journal_seq in snapshot: %w", err)
+	}
+	return epoch.String, journalSeq, nil
+}
+
+// buildFromSnapshot creates an MBTiles file at path, filled by fill on a connection where the
+// snapshot is attached as "snapshot". The file is built aside and renamed into place.
+func buildFromSnapshot(snapshotPath, path string, fill func(ctx context.Context, conn *sql.Conn) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create extract directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".build-*.mbtiles")
+	if err != nil {
+		return fmt.Errorf("failed to create extract file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	db, err := sql.Open("sqlite", tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open extract: %w", err)
+	}
+	defer db.Close()
+
+	if err := createMBTilesSchema(db); err != nil {
+		return err
+	}
+
+	// ATTACH is per connection: run everything on a single one
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS snapshot`, snapshotPath); err != nil {
+		return fmt.Errorf("failed to attach snapshot: %w", err)
+	}
+	if err := fill(ctx, conn); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `DETACH DATABASE snapshot`); err != nil {
+		return fmt.Errorf("failed to detach snapshot: %w", err)
+	}
+
+	conn.Close()
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("failed to close extract: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move extract into the cache: %w", err)
+	}
+	return nil
+}
+
+// pruneCache removes the least recently used extracts, deltas and bundles beyond the cache size
+// or the total size limit. The most recent file is always kept, as it was just built for a download.
+// Downloads in progress keep reading a removed file until they complete.
+func (s *MBTilesExtractService) pruneCache() {
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		log.Printf("Warning: Failed to read extract cache: %v", err)
+		return
+	}
+
+	type cached struct {
+		path    string
+		modTime time.Time
+		size    int64
+	}
+	var files []cached
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isCachedBuild(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{path: filepath.Join(s.cacheDir, entry.Name()), modTime: info.ModTime(), size: info.Size()})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	var total int64
+	for i, f := range files {
+		total += f.size
+		if i == 0 || (i < s.cfg.cacheSize && total <= s.cfg.cacheMaxBytes) {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove cached extract %s: %v", filepath.Base(f.path), err)
+		}
+	}
+}
+
+// isCachedBuild reports whether a file of the cache directory is a complete extract, delta or bundle
+func isCachedBuild(name string) bool {
+	switch {
+	case strings.HasPrefix(name, mbtilesExtractPrefix), strings.HasPrefix(name, mbtilesDeltaPrefix):
+		return strings.HasSuffix(name, ".mbtiles")
+	case strings.HasPrefix(name, offlineBundlePrefix):
+		return strings.HasSuffix(name, ".zip")
+	}
+	return false
+}
+
+// Compile-time check to ensure MBTilesExtractService implements interfaces.MBTilesExtractor
+var _ interfaces.MBTilesExtractor = (*MBTilesExtractService)(nil)
@@ -2,11 +2,18 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,10 +22,20 @@ import (
 
 	"bike-map/entities"
 	"bike-map/interfaces"
+	"bike-map/metrics"
 
 	_ "modernc.org/sqlite"
 )
 
+// MBTilesBackupConfig holds MBTiles backup and snapshot configuration
+type MBTilesBackupConfig struct {
+	snapshotDir  string
+	pmtiles      bool               // Also export each snapshot as a PMTiles archive
+	retainCount  int                // Snapshots kept, 0 for no limit
+	retainMaxAge time.Duration      // Age after which snapshots are removed, 0 for no limit
+	downloads    *SnapshotDownloads // Snapshots being downloaded are never removed
+}
+
 // MVTBackupMBTiles implements MVTBackup using in-memory SQLite with snapshot capability
 type MVTBackupMBTiles struct {
 	db          *sql.DB
@@ -26,17 +43,34 @@ type MVTBackupMBTiles struct {
 	maxZoom     int
 	mu          sync.RWMutex
 	snapshotDir string
+	pmtiles     bool
 	dirty       atomic.Bool
+	source      entities.SnapshotSource // Trail data the tiles are generated from, empty until known
+	loaded      string                  // Trail revision of the snapshot loaded at startup, empty if unknown
+
+	retainCount  int
+	retainMaxAge time.Duration
+	downloads    *SnapshotDownloads
+
+	// Change journal: the sequence number of the last change of each tile, so offline clients
+	// can fetch the tiles changed since their snapshot. A new epoch starts with an empty backup.
+	journalEpoch string
+	journalSeq   int64
 }
 
 // NewMVTBackupMBTiles creates a new in-memory MBTiles backup with snapshot capability
-func NewMVTBackupMBTiles(snapshotDir string) (*MVTBackupMBTiles, error) {
+func NewMVTBackupMBTiles(cfg MBTilesBackupConfig) (*MVTBackupMBTiles, error) {
 	// Open IN-MEMORY SQLite database (zero disk I/O during tile generation)
 	db, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open in-memory database: %w", err)
 	}
 
+	// Every connection to ":memory:" opens its own empty database: keep a single one
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
 	// Test connection
 	if err := db.Ping(); err != nil {
 		db.Close()
@@ -44,10 +78,15 @@ func NewMVTBackupMBTiles(snapshotDir string) (*MVTBackupMBTiles, error) {
 	}
 
 	m := &MVTBackupMBTiles{
-		db:          db,
-		minZoom:     6,
-		maxZoom:     18,
-		snapshotDir: snapshotDir,
+		db:           db,
+		minZoom:      6,
+		maxZoom:      18,
+		snapshotDir:  cfg.snapshotDir,
+		pmtiles:      cfg.pmtiles,
+		retainCount:  cfg.retainCount,
+		retainMaxAge: cfg.retainMaxAge,
+		downloads:    cfg.downloads,
+		journalEpoch: newJournalEpoch(),
 	}
 
 	// Initialize as dirty (will snapshot on first completion)
@@ -59,14 +98,54 @@ func NewMVTBackupMBTiles(snapshotDir string) (*MVTBackupMBTiles, error) {
 		return nil, fmt.Errorf("failed to initialize MBTiles schema: %w", err)
 	}
 
-	log.Printf("In-memory MBTiles backup initialized (snapshots to: %s)", snapshotDir)
+	if m.downloads == nil {
+		m.downloads = NewSnapshotDownloads()
+	}
+
+	log.Printf("In-memory MBTiles backup initialized (snapshots to: %s, keep: %d, max age: %s, PMTiles export: %t)",
+		cfg.snapshotDir, cfg.retainCount, cfg.retainMaxAge, cfg.pmtiles)
 	return m, nil
 }
 
 // initSchema creates the MBTiles schema if it doesn't exist
 func (m *MVTBackupMBTiles) initSchema() error {
-	// Create metadata table
+	if err := createMBTilesSchema(m.db); err != nil {
+		return err
+	}
+
+	// Change journal, copied into snapshots along with the tiles
 	_, err := m.db.Exec(`
+		CREATE TABLE IF NOT EXISTS tile_journal (
+			zoom_level INTEGER,
+			tile_column INTEGER,
+			tile_row INTEGER,
+			seq INTEGER NOT NULL,
+			PRIMARY KEY (zoom_level, tile_column, tile_row)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create tile journal table: %w", err)
+	}
+
+	_, err = m.db.Exec(`CREATE INDEX IF NOT EXISTS tile_journal_seq ON tile_journal (seq)`)
+	if err != nil {
+		return fmt.Errorf("failed to create tile journal index: %w", err)
+	}
+
+	return writeMBTilesMetadata(m.db, mbtilesMetadata(m.minZoom, m.maxZoom, nil))
+}
+
+// newJournalEpoch returns a random change journal epoch
+func newJournalEpoch() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// createMBTilesSchema creates the MBTiles tables and indexes if they don't exist
+func createMBTilesSchema(db *sql.DB) error {
+	// Create metadata table
+	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS metadata (
 			name TEXT,
 			value TEXT
@@ -77,7 +156,7 @@ func (m *MVTBackupMBTiles) initSchema() error {
 	}
 
 	// Create tiles table
-	_, err = m.db.Exec(`
+	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS tiles (
 			zoom_level INTEGER,
 			tile_column INTEGER,
@@ -90,7 +169,7 @@ func (m *MVTBackupMBTiles) initSchema() error {
 	}
 
 	// Create unique index on tiles
-	_, err = m.db.Exec(`
+	_, err = db.Exec(`
 		CREATE UNIQUE INDEX IF NOT EXISTS tile_index
 		ON tiles (zoom_level, tile_column, tile_row)
 	`)
@@ -98,24 +177,131 @@ func (m *MVTBackupMBTiles) initSchema() error {
 		return fmt.Errorf("failed to create tiles index: %w", err)
 	}
 
-	// Insert/update required metadata
-	metadata := map[string]string{
-		"name":    "bike-map-trails",
-		"format":  "pbf",
-		"minzoom": fmt.Sprintf("%d", m.minZoom),
-		"maxzoom": fmt.Sprintf("%d", m.maxZoom),
-		"type":    "overlay",
+	// One row per name, so metadata updates replace the previous value
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS metadata_index ON metadata (name)`)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata index: %w", err)
 	}
 
+	return nil
+}
+
+// Tileset metadata (MBTiles 1.3)
+const (
+	mbtilesName        = "bike-map-trails"
+	mbtilesDescription = "BikeMap mountain bike trails"
+	mbtilesAttribution = "BikeMap contributors"
+)
+
+// mbtilesTrailFields describes the attributes of the trails layer built by generate_mvt_tile
+var mbtilesTrailFields = map[string]string{
+	"id":                     "String",
+	"name":                   "String",
+	"description":            "String",
+	"level":                  "String",
+	"tags":                   "String",
+	"owner_id":               "String",
+	"created_at":             "String",
+	"updated_at":             "String",
+	"gpx_file":               "String",
+	"bbox_west":              "Number",
+	"bbox_south":             "Number",
+	"bbox_east":              "Number",
+	"bbox_north":             "Number",
+	"start_lng":              "Number",
+	"start_lat":              "Number",
+	"end_lng":                "Number",
+	"end_lat":                "Number",
+	"distance_m":             "Number",
+	"elevation_gain_meters":  "Number",
+	"elevation_loss_meters":  "Number",
+	"min_elevation_meters":   "Number",
+	"max_elevation_meters":   "Number",
+	"elevation_start_meters": "Number",
+	"elevation_end_meters":   "Number",
+	"ridden":                 "Boolean",
+}
+
+// mbtilesWorldBounds are the bounds of the Web Mercator world, used when there are no trails
+var mbtilesWorldBounds = entities.BoundingBox{North: 85.0511287798066, South: -85.0511287798066, East: 180, West: -180}
+
+// mbtilesMetadata builds the metadata of a tileset covering bounds (nil meaning the whole world)
+func mbtilesMetadata(minZoom, maxZoom int, bounds *entities.BoundingBox) map[string]string {
+	b := mbtilesWorldBounds
+	if bounds != nil {
+		b = *bounds
+	}
+
+	// Center zoom: the deepest level at which the bounds still fit in a single tile
+	centerZoom := minZoom
+	if width := max(b.East-b.West, b.North-b.South); width > 0 {
+		centerZoom = min(max(int(math.Floor(math.Log2(360/width))), minZoom), maxZoom)
+	}
+
+	layers, _ := json.Marshal(map[string]any{
+		"vector_layers": []map[string]any{{
+			"id":          "trails",
+			"description": "Trail lines with their attributes",
+			"minzoom":     minZoom,
+			"maxzoom":     maxZoom,
+			"fields":      mbtilesTrailFields,
+		}},
+	})
+
+	return map[string]string{
+		"name":        mbtilesName,
+		"format":      "pbf",
+		"type":        "overlay",
+		"version":     "1",
+		"description": mbtilesDescription,
+		"attribution": mbtilesAttribution,
+		"minzoom":     strconv.Itoa(minZoom),
+		"maxzoom":     strconv.Itoa(maxZoom),
+		"bounds":      fmt.Sprintf("%s,%s,%s,%s", formatCoord(b.West), formatCoord(b.South), formatCoord(b.East), formatCoord(b.North)),
+		"center":      fmt.Sprintf("%s,%s,%d", formatCoord((b.West+b.East)/2), formatCoord((b.South+b.North)/2), centerZoom),
+		"json":        string(layers),
+	}
+}
+
+// formatCoord formats a longitude or latitude with at most 6 decimals (about 0.1m)
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e6)/1e6, 'f', -1, 64)
+}
+
+// sqlExecer is implemented by *sql.DB and *sql.Conn
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// writeMBTilesMetadata inserts or replaces metadata values
+func writeMBTilesMetadata(db sqlExecer, metadata map[string]string) error {
 	for name, value := range metadata {
-		_, err = m.db.Exec(`
+		_, err := db.ExecContext(context.Background(), `
 			INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)
 		`, name, value)
 		if err != nil {
 			return fmt.Errorf("failed to set metadata %s: %w", name, err)
 		}
 	}
+	return nil
+}
+
+// SetSource records the trail data the tiles are generated from and updates the bounds and
+// center metadata. Snapshots are only marked dirty if the bounds changed: trail changes
+// affecting the tiles already do.
+func (m *MVTBackupMBTiles) SetSource(source entities.SnapshotSource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bounds, current := source.Bounds, m.source.Bounds
+	if bounds != current && (bounds == nil || current == nil || *bounds != *current) {
+		if err := writeMBTilesMetadata(m.db, mbtilesMetadata(m.minZoom, m.maxZoom, bounds)); err != nil {
+			return err
+		}
+		m.dirty.Store(true)
+	}
 
+	m.source = source
 	return nil
 }
 
@@ -152,21 +338,37 @@ func (m *MVTBackupMBTiles) GetTile(_ context.Context, c entities.TileCoordinates
 	return data, nil
 }
 
-// StoreTile stores a tile in MBTiles storage
+// StoreTile stores a tile in MBTiles storage. Tiles regenerated with unchanged data are not
+// recorded in the change journal and do not trigger a snapshot.
 func (m *MVTBackupMBTiles) StoreTile(c entities.TileCoordinates, data []byte) error {
 	tmsY := xyzToTMS(c.Z, c.Y)
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	_, err := m.db.Exec(`
-		INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data)
+	result, err := m.db.Exec(`
+		INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data)
 		VALUES (?, ?, ?, ?)
+		ON CONFLICT (zoom_level, tile_column, tile_row)
+		DO UPDATE SET tile_data = excluded.tile_data WHERE tile_data IS NOT excluded.tile_data
 	`, c.Z, c.X, tmsY, data)
 
 	if err != nil {
 		return fmt.Errorf("failed to store tile: %w", err)
 	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return nil
+	}
+
+	_, err = m.db.Exec(`
+		INSERT INTO tile_journal (zoom_level, tile_column, tile_row, seq)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (zoom_level, tile_column, tile_row) DO UPDATE SET seq = excluded.seq
+	`, c.Z, c.X, tmsY, m.journalSeq+1)
+	if err != nil {
+		return fmt.Errorf("failed to journal tile change: %w", err)
+	}
+	m.journalSeq++
 
 	// Mark as dirty (tiles have changed)
 	m.dirty.Store(true)
@@ -174,11 +376,59 @@ func (m *MVTBackupMBTiles) StoreTile(c entities.TileCoordinates, data []byte) er
 	return nil
 }
 
-// ClearAllTiles removes all tiles from storage
+// ListTiles returns the tiles held in the zoom range
+func (m *MVTBackupMBTiles) ListTiles(minZoom, maxZoom int) ([]entities.TileCoordinates, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows, err := m.db.Query(`
+		SELECT zoom_level, tile_column, tile_row FROM tiles
+		WHERE zoom_level BETWEEN ? AND ?
+	`, minZoom, maxZoom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tiles: %w", err)
+	}
+	defer rows.Close()
+
+	var tiles []entities.TileCoordinates
+	for rows.Next() {
+		var c entities.TileCoordinates
+		var tmsY int
+		if err := rows.Scan(&c.Z, &c.X, &tmsY); err != nil {
+			return nil, fmt.Errorf("failed to scan tile: %w", err)
+		}
+		c.Y = xyzToTMS(c.Z, tmsY) // The flip is its own inverse
+		tiles = append(tiles, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tiles: %w", err)
+	}
+	return tiles, nil
+}
+
+// LoadedTrailRevision returns the trail revision of the snapshot loaded at startup, empty if
+// none was loaded or it predates trail revisions
+func (m *MVTBackupMBTiles) LoadedTrailRevision() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.loaded
+}
+
+// ClearAllTiles removes all tiles from storage. Removed tiles are journaled as deleted.
 func (m *MVTBackupMBTiles) ClearAllTiles() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	_, err := m.db.Exec(`
+		INSERT INTO tile_journal (zoom_level, tile_column, tile_row, seq)
+		SELECT zoom_level, tile_column, tile_row, ? FROM tiles WHERE true
+		ON CONFLICT (zoom_level, tile_column, tile_row) DO UPDATE SET seq = excluded.seq
+	`, m.journalSeq+1)
+	if err != nil {
+		return fmt.Errorf("failed to journal cleared tiles: %w", err)
+	}
+	m.journalSeq++
+
 	result, err := m.db.Exec(`DELETE FROM tiles`)
 	if err != nil {
 		return fmt.Errorf("failed to clear tiles: %w", err)
@@ -186,6 +436,7 @@ func (m *MVTBackupMBTiles) ClearAllTiles() error {
 
 	count, _ := result.RowsAffected()
 	log.Printf("Cleared %d tiles from MBTiles backup", count)
+	m.dirty.Store(true)
 
 	return nil
 }
@@ -206,13 +457,42 @@ func (m *MVTBackupMBTiles) Close() error {
 	return m.db.Close()
 }
 
-// Snapshot creates a disk snapshot of the in-memory database using VACUUM INTO
+// Snapshot creates a disk snapshot of the in-memory database using VACUUM INTO,
+// along with its manifest
 func (m *MVTBackupMBTiles) Snapshot() error {
 	// Check if snapshot needed
 	if !m.dirty.Load() {
 		return nil
 	}
 
+	targetPath, manifest, err := m.writeSnapshot()
+	if err != nil {
+		metrics.Snapshots.WithLabelValues("failure").Inc()
+		return err
+	}
+
+	// Hashed from the file, outside of the lock
+	if err := writeSnapshotManifest(targetPath, manifest); err != nil {
+		log.Printf("Warning: Failed to write snapshot manifest: %v", err)
+	}
+
+	// Converted from the snapshot file, so the in-memory database stays available meanwhile
+	if m.pmtiles {
+		if err := m.exportPMTiles(targetPath); err != nil {
+			log.Printf("Warning: Failed to export PMTiles archive: %v", err)
+		}
+	}
+
+	if err := m.cleanupOldSnapshots(); err != nil {
+		log.Printf("Warning: Failed to cleanup old snapshots: %v", err)
+	}
+
+	return nil
+}
+
+// writeSnapshot writes the in-memory database to a timestamped file and returns its path
+// and manifest, without checksum
+func (m *MVTBackupMBTiles) writeSnapshot() (string, *entities.SnapshotManifest, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -221,13 +501,14 @@ func (m *MVTBackupMBTiles) Snapshot() error {
 
 	// Create directory if needed
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create snapshot directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create snapshot directory: %w", err)
 	}
 
 	// Generate timestamped filename
-	timestamp := time.Now().Unix()
-	filename := fmt.Sprintf("%s%d.mbtiles", entities.MBtilesFilePrefix, timestamp)
+	now := time.Now()
+	filename := fmt.Sprintf("%s%d.mbtiles", entities.MBtilesFilePrefix, now.Unix())
 	targetPath := targetDir + "/" + filename
+	tmpPath := targetPath + ".tmp"
 
 	// Get tile count for logging
 	var count int
@@ -236,10 +517,26 @@ func (m *MVTBackupMBTiles) Snapshot() error {
 		log.Printf("Warning: Could not count tiles before snapshot: %v", err)
 	}
 
-	// VACUUM INTO creates compact snapshot
-	_, err = m.db.Exec(fmt.Sprintf("VACUUM INTO '%s'", targetPath))
+	// Record the journal position, so deltas can be requested against this snapshot
+	err = writeMBTilesMetadata(m.db, map[string]string{
+		"journal_epoch":  m.journalEpoch,
+		"journal_seq":    strconv.FormatInt(m.journalSeq, 10),
+		"trail_revision": m.source.TrailRevision,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	// VACUUM INTO creates compact snapshot, renamed once complete so partial files are never served
+	os.Remove(tmpPath)
+	_, err = m.db.Exec(fmt.Sprintf("VACUUM INTO '%s'", tmpPath))
 	if err != nil {
-		return fmt.Errorf("failed to create snapshot: %w", err)
+		os.Remove(tmpPath)
+		return "", nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if err := os.Rename(tmpPath, targetPath); err != nil {
+		os.Remove(tmpPath)
+		return "", nil, fmt.Errorf("failed to move snapshot into place: %w", err)
 	}
 
 	// Clear dirty flag
@@ -248,62 +545,242 @@ func (m *MVTBackupMBTiles) Snapshot() error {
 	// Get snapshot file size
 	fileInfo, _ := os.Stat(targetPath)
 	var sizeStr string
+	var sizeBytes int64
 	if fileInfo != nil {
-		sizeMB := float64(fileInfo.Size()) / (1024 * 1024)
+		sizeBytes = fileInfo.Size()
+		sizeMB := float64(sizeBytes) / (1024 * 1024)
 		sizeStr = fmt.Sprintf(" (%.2f MB)", sizeMB)
 	}
+	metrics.ObserveSnapshot(sizeBytes, count)
 
 	log.Printf("Snapshot created: %s - %d tiles%s", filename, count, sizeStr)
 
-	// Cleanup old snapshots (older than 15 minutes)
-	if err := m.cleanupOldSnapshots(targetDir, 15*time.Minute); err != nil {
-		log.Printf("Warning: Failed to cleanup old snapshots: %v", err)
+	manifest := &entities.SnapshotManifest{
+		Filename:      filename,
+		CreatedAt:     now.UTC(),
+		SizeBytes:     sizeBytes,
+		TileCount:     count,
+		MinZoom:       m.minZoom,
+		MaxZoom:       m.maxZoom,
+		Bounds:        m.source.Bounds,
+		TrailCount:    m.source.TrailCount,
+		TrailRevision: m.source.TrailRevision,
+		JournalEpoch:  m.journalEpoch,
+		JournalSeq:    m.journalSeq,
 	}
+	return targetPath, manifest, nil
+}
+
+// writeSnapshotManifest computes the checksum of a snapshot and writes its manifest next to it
+func writeSnapshotManifest(snapshotPath string, manifest *entities.SnapshotManifest) error {
+	f, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash snapshot: %w", err)
+	}
+	manifest.SHA256 = hex.EncodeToString(h.Sum(nil))
 
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	path := filepath.Join(filepath.Dir(snapshotPath), entities.SnapshotManifestFilename(filepath.Base(snapshotPath)))
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move manifest into place: %w", err)
+	}
 	return nil
 }
 
-// cleanupOldSnapshots removes snapshot files older than maxAge
-func (m *MVTBackupMBTiles) cleanupOldSnapshots(dir string, maxAge time.Duration) error {
-	entries, err := os.ReadDir(dir + "/")
+// exportPMTiles converts a snapshot into the PMTiles archive, replacing the previous one.
+// The archive keeps a fixed name so it can be published to static hosting as is.
+func (m *MVTBackupMBTiles) exportPMTiles(snapshotPath string) error {
+	start := time.Now()
+	targetPath := filepath.Join(m.snapshotDir, entities.PMTilesLatestFilename)
+
+	count, err := writePMTiles(snapshotPath, targetPath)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now()
-	cutoff := now.Add(-maxAge)
-	var deletedCount int
+	var sizeMB float64
+	if fileInfo, err := os.Stat(targetPath); err == nil {
+		sizeMB = float64(fileInfo.Size()) / (1024 * 1024)
+	}
+	log.Printf("PMTiles archive exported: %s - %d tiles (%.2f MB) in %s",
+		entities.PMTilesLatestFilename, count, sizeMB, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// LoadLatestSnapshot copies the tiles of the most recent snapshot on disk into the backup,
+// so tiles can be served before they are regenerated (or while PostGIS is unavailable)
+func (m *MVTBackupMBTiles) LoadLatestSnapshot() error {
+	path, err := latestSnapshotPath(m.snapshotDir)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		log.Printf("No MBTiles snapshot found in %s", m.snapshotDir)
+		return nil
+	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// ATTACH is per connection: run everything on the same one
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS snapshot`, path); err != nil {
+		return fmt.Errorf("failed to attach snapshot: %w", err)
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE snapshot`)
+
+	result, err := conn.ExecContext(ctx, `
+		INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data)
+		SELECT zoom_level, tile_column, tile_row, tile_data FROM snapshot.tiles
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load snapshot tiles: %w", err)
+	}
+
+	count, _ := result.RowsAffected()
+	log.Printf("Loaded %d tiles from snapshot %s", count, filepath.Base(path))
+
+	// Trails the tiles were generated from, to tell whether they are still current
+	err = conn.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT value FROM snapshot.metadata WHERE name = 'trail_revision'), '')
+	`).Scan(&m.loaded)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot trail revision: %w", err)
+	}
+
+	// Continue the snapshot's change journal. Snapshots without one start a new epoch.
+	var epoch, seq string
+	err = conn.QueryRowContext(ctx, `
+		SELECT
+			(SELECT value FROM snapshot.metadata WHERE name = 'journal_epoch'),
+			(SELECT value FROM snapshot.metadata WHERE name = 'journal_seq')
+	`).Scan(&epoch, &seq)
+	if err != nil {
+		log.Printf("Snapshot %s has no change journal, starting a new epoch", filepath.Base(path))
+		return nil
+	}
+	journalSeq, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid journal_seq in snapshot: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, `
+		INSERT OR REPLACE INTO tile_journal (zoom_level, tile_column, tile_row, seq)
+		SELECT zoom_level, tile_column, tile_row, seq FROM snapshot.tile_journal
+	`); err != nil {
+		return fmt.Errorf("failed to load snapshot journal: %w", err)
+	}
+	m.journalEpoch, m.journalSeq = epoch, journalSeq
+
+	return nil
+}
+
+// snapshotFile is a snapshot found in the snapshot directory
+type snapshotFile struct {
+	name      string
+	path      string
+	timestamp int64
+}
+
+// listSnapshots returns the snapshots in dir, most recent first
+func listSnapshots(dir string) ([]snapshotFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var snapshots []snapshotFile
 	for _, entry := range entries {
-		// Only process .mbtiles files
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".mbtiles") {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, entities.MBtilesFilePrefix) || !strings.HasSuffix(name, ".mbtiles") {
 			continue
 		}
 
 		// Parse timestamp from filename (bikemap-1703780425.mbtiles)
-		if !strings.HasPrefix(entry.Name(), entities.MBtilesFilePrefix) {
+		timestamp, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, entities.MBtilesFilePrefix), ".mbtiles"), 10, 64)
+		if err != nil {
 			continue
 		}
+		snapshots = append(snapshots, snapshotFile{name: name, path: filepath.Join(dir, name), timestamp: timestamp})
+	}
 
-		timestampStr := strings.TrimPrefix(entry.Name(), entities.MBtilesFilePrefix)
-		timestampStr = strings.TrimSuffix(timestampStr, ".mbtiles")
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].timestamp > snapshots[j].timestamp
+	})
+	return snapshots, nil
+}
 
-		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
-		if err != nil {
-			log.Printf("Warning: Could not parse timestamp from %s: %v", entry.Name(), err)
+// latestSnapshotPath returns the path of the most recent snapshot in dir, or "" if there is none
+func latestSnapshotPath(dir string) (string, error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil || len(snapshots) == 0 {
+		return "", err
+	}
+	return snapshots[0].path, nil
+}
+
+// cleanupOldSnapshots removes the snapshots beyond the retention count or older than the
+// retention age, along with their manifests. The most recent snapshot is always kept, and
+// snapshots being downloaded are left for a later cleanup.
+func (m *MVTBackupMBTiles) cleanupOldSnapshots() error {
+	snapshots, err := listSnapshots(m.snapshotDir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var deletedCount int
+
+	for i, snapshot := range snapshots {
+		if i == 0 {
 			continue
 		}
 
-		fileTime := time.Unix(timestamp, 0)
-		if fileTime.Before(cutoff) {
-			filePath := filepath.Join(dir, entry.Name())
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("Warning: Failed to delete old snapshot %s: %v", entry.Name(), err)
-			} else {
-				deletedCount++
-				log.Printf("Deleted old snapshot: %s (age: %v)", entry.Name(), now.Sub(fileTime).Round(time.Second))
-			}
+		age := now.Sub(time.Unix(snapshot.timestamp, 0))
+		expired := (m.retainCount > 0 && i >= m.retainCount) || (m.retainMaxAge > 0 && age > m.retainMaxAge)
+		if !expired {
+			continue
+		}
+		if m.downloads.InUse(snapshot.name) {
+			log.Printf("Keeping old snapshot %s while it is being downloaded", snapshot.name)
+			continue
 		}
+
+		if err := os.Remove(snapshot.path); err != nil {
+			log.Printf("Warning: Failed to delete old snapshot %s: %v", snapshot.name, err)
+			continue
+		}
+		manifestPath := filepath.Join(m.snapshotDir, entities.SnapshotManifestFilename(snapshot.name))
+		if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to delete manifest of old snapshot %s: %v", snapshot.name, err)
+		}
+
+		deletedCount++
+		log.Printf("Deleted old snapshot: %s (age: %v)", snapshot.name, age.Round(time.Second))
 	}
 
 	if deletedCount > 0 {
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"bike-map/entities"
+)
+
+// snapshotMetadata snapshots backup and returns the metadata table of the snapshot file
+func snapshotMetadata(t *testing.T, backup *MVTBackupMBTiles, dir string) map[string]string {
+	t.Helper()
+
+	if err := backup.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	path, err := latestSnapshotPath(dir)
+	if err != nil || path == "" {
+		t.Fatalf("no snapshot written to %s: %v", dir, err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open snapshot: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	defer rows.Close()
+
+	metadata := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			t.Fatalf("failed to scan metadata: %v", err)
+		}
+		metadata[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	return metadata
+}
+
+func newTestBackup(t *testing.T) (*MVTBackupMBTiles, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	backup, err := NewMVTBackupMBTiles(MBTilesBackupConfig{snapshotDir: dir})
+	if err != nil {
+		t.Fatalf("NewMVTBackupMBTiles: %v", err)
+	}
+	t.Cleanup(func() { backup.Close() })
+	return backup, dir
+}
+
+func TestSnapshotMetadata(t *testing.T) {
+	backup, dir := newTestBackup(t)
+
+	bounds := &entities.BoundingBox{West: 6, South: 46, East: 8, North: 47}
+	if err := backup.SetSource(entities.SnapshotSource{Bounds: bounds, TrailCount: 1}); err != nil {
+		t.Fatalf("SetSource: %v", err)
+	}
+	if err := backup.StoreTile(entities.TileCoordinates{X: 66, Y: 45, Z: 7}, []byte{0x1a, 0x00}); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+
+	metadata := snapshotMetadata(t, backup, dir)
+
+	want := map[string]string{
+		"name":    mbtilesName,
+		"format":  "pbf",
+		"type":    "overlay",
+		"minzoom": "6",
+		"maxzoom": "18",
+		"bounds":  "6,46,8,47",
+		"center":  "7,46.5,7", // 2 degrees wide: the deepest single-tile zoom is 7
+	}
+	for name, value := range want {
+		if got, ok := metadata[name]; !ok {
+			t.Errorf("metadata %s is missing", name)
+		} else if got != value {
+			t.Errorf("metadata %s = %q, want %q", name, got, value)
+		}
+	}
+
+	var layers struct {
+		VectorLayers []struct {
+			ID      string            `json:"id"`
+			MinZoom int               `json:"minzoom"`
+			MaxZoom int               `json:"maxzoom"`
+			Fields  map[string]string `json:"fields"`
+		} `json:"vector_layers"`
+	}
+	if err := json.Unmarshal([]byte(metadata["json"]), &layers); err != nil {
+		t.Fatalf("metadata json is not valid JSON: %v (%q)", err, metadata["json"])
+	}
+	if len(layers.VectorLayers) != 1 || layers.VectorLayers[0].ID != "trails" {
+		t.Fatalf("vector_layers = %+v, want a single trails layer", layers.VectorLayers)
+	}
+
+	trails := layers.VectorLayers[0]
+	if trails.MinZoom != 6 || trails.MaxZoom != 18 {
+		t.Errorf("trails layer zooms = %d-%d, want 6-18", trails.MinZoom, trails.MaxZoom)
+	}
+	for _, field := range []string{"id", "name", "level", "distance_m", "elevation_gain_meters", "ridden"} {
+		if _, ok := trails.Fields[field]; !ok {
+			t.Errorf("trails layer fields are missing %s", field)
+		}
+	}
+	for field, typ := range trails.Fields {
+		if typ != "String" && typ != "Number" && typ != "Boolean" {
+			t.Errorf("trails layer field %s has type %q, want String, Number or Boolean", field, typ)
+		}
+	}
+	if len(trails.Fields) != len(mbtilesTrailFields) {
+		t.Errorf("trails layer has %d fields, want %d", len(trails.Fields), len(mbtilesTrailFields))
+	}
+}
+
+func TestSnapshotMetadataWithoutTrails(t *testing.T) {
+	backup, dir := newTestBackup(t)
+
+	metadata := snapshotMetadata(t, backup, dir)
+
+	if got, want := metadata["bounds"], "-180,-85.051129,180,85.051129"; got != want {
+		t.Errorf("bounds = %q, want %q", got, want)
+	}
+	if got, want := metadata["center"], "0,0,6"; got != want {
+		t.Errorf("center = %q, want %q", got, want)
+	}
+}
+
+func TestLoadLatestSnapshot(t *testing.T) {
+	backup, dir := newTestBackup(t)
+
+	if err := backup.SetSource(entities.SnapshotSource{TrailCount: 1, TrailRevision: "0123456789abcdef"}); err != nil {
+		t.Fatalf("SetSource: %v", err)
+	}
+	tile := entities.TileCoordinates{X: 66, Y: 45, Z: 7}
+	if err := backup.StoreTile(tile, []byte{0x1a, 0x00}); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	snapshotMetadata(t, backup, dir)
+
+	loaded, err := NewMVTBackupMBTiles(MBTilesBackupConfig{snapshotDir: dir})
+	if err != nil {
+		t.Fatalf("NewMVTBackupMBTiles: %v", err)
+	}
+	defer loaded.Close()
+	if err := loaded.LoadLatestSnapshot(); err != nil {
+		t.Fatalf("LoadLatestSnapshot: %v", err)
+	}
+
+	if got, want := loaded.LoadedTrailRevision(), "0123456789abcdef"; got != want {
+		t.Errorf("LoadedTrailRevision = %q, want %q", got, want)
+	}
+	tiles, err := loaded.ListTiles(loaded.GetMinZoom(), loaded.GetMaxZoom())
+	if err != nil {
+		t.Fatalf("ListTiles: %v", err)
+	}
+	if len(tiles) != 1 || tiles[0] != tile {
+		t.Errorf("ListTiles = %v, want [%v]", tiles, tile)
+	}
+}
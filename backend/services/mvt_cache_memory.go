@@ -5,15 +5,19 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	"bike-map/entities"
 	"bike-map/interfaces"
+	"bike-map/metrics"
 )
 
 // cacheEntry represents a cached tile with response data and status
 type cacheEntry struct {
-	data   []byte              // MVT tile data
-	status interfaces.TileStatus
+	coords    entities.TileCoordinates
+	data      []byte // MVT tile data
+	status    interfaces.TileStatus
+	generated bool // Tile data was generated at least once (stale data is meaningful)
 }
 
 // MVTMemoryStorage implements MVTStorage as a memory cache
@@ -22,21 +26,48 @@ type MVTMemoryStorage struct {
 	cacheMutex    sync.RWMutex           // Thread-safe access to cache
 	minZoom       int
 	maxZoom       int
+	serveStale    bool // Serve invalidated tiles while regenerating instead of blocking
 	tileRequester interfaces.TileRequester
+	fallback      interfaces.MVTProvider // Serves tiles missing from the cache (MBTiles backup)
+
+	// Fallback tiles are up to date and served as valid, set once trails are synced at startup
+	fallbackCurrent bool
+
+	// Kept up to date on every change, so metrics scrapes don't scan the cache
+	entries atomic.Int64
+	bytes   atomic.Int64
 }
 
 // NewMVTService creates a new MVT storage instance (memory cache)
-func NewMVTService() *MVTMemoryStorage {
+func NewMVTService(serveStale bool) *MVTMemoryStorage {
 	return &MVTMemoryStorage{
-		cache:   make(map[string]*cacheEntry),
-		minZoom: 6,
-		maxZoom: 18,
+		cache:      make(map[string]*cacheEntry),
+		minZoom:    6,
+		maxZoom:    18,
+		serveStale: serveStale,
 	}
 }
 
-// SetTileRequester sets the tile requester for on-demand generation (breaks circular dependency)
+// SetTileRequester sets the tile requester for on-demand generation (breaks circular dependency).
+// It may be set while serving, once PostGIS becomes reachable.
 func (m *MVTMemoryStorage) SetTileRequester(tr interfaces.TileRequester) {
+	m.cacheMutex.Lock()
 	m.tileRequester = tr
+	m.cacheMutex.Unlock()
+}
+
+// SetFallback sets the provider serving tiles that are not in the cache yet. Call before serving.
+func (m *MVTMemoryStorage) SetFallback(p interfaces.MVTProvider) {
+	m.fallback = p
+}
+
+// SetFallbackCurrent tells whether the fallback tiles are up to date: the snapshot was generated
+// from the current trails, and tiles changed since are invalidated in the cache. Current fallback
+// tiles are served as valid and not regenerated, others are served stale until regenerated.
+func (m *MVTMemoryStorage) SetFallbackCurrent(current bool) {
+	m.cacheMutex.Lock()
+	m.fallbackCurrent = current
+	m.cacheMutex.Unlock()
 }
 
 func (m *MVTMemoryStorage) GetMinZoom() int {
@@ -48,41 +79,105 @@ func (m *MVTMemoryStorage) GetMaxZoom() int {
 }
 
 // GetTile retrieves a tile, requesting generation if needed
-func (m *MVTMemoryStorage) GetTile(_ context.Context, c entities.TileCoordinates) ([]byte, error) {
+func (m *MVTMemoryStorage) GetTile(ctx context.Context, c entities.TileCoordinates) ([]byte, error) {
+	data, _, err := m.ServeTile(ctx, c)
+	return data, err
+}
+
+// ServeTile retrieves a tile for serving, along with the status of the returned data.
+// Invalidated tiles that were generated before are served stale (status TileInvalidated)
+// while regeneration runs in the background; only never-generated tiles block on generation.
+func (m *MVTMemoryStorage) ServeTile(ctx context.Context, c entities.TileCoordinates) ([]byte, interfaces.TileStatus, error) {
 	if c.Z < m.minZoom || c.Z > m.maxZoom {
-		return nil, fmt.Errorf("zoom level %d out of range [%d, %d]", c.Z, m.minZoom, m.maxZoom)
+		return nil, interfaces.TileNotFound, fmt.Errorf("zoom level %d out of range [%d, %d]", c.Z, m.minZoom, m.maxZoom)
+	}
+
+	tileKey := fmt.Sprintf("%d-%d-%d", c.Z, c.X, c.Y)
+
+	m.cacheMutex.RLock()
+	entry, exists := m.cache[tileKey]
+	var data []byte
+	var status interfaces.TileStatus
+	var generated bool
+	if exists {
+		data, status, generated = entry.data, entry.status, entry.generated
 	}
+	tileRequester := m.tileRequester
+	fallbackCurrent := m.fallbackCurrent
+	m.cacheMutex.RUnlock()
 
-	data, status, _ := m.GetTileWithStatus(c)
+	if !exists {
+		// Not generated since startup: serve the snapshot copy, as is if it is current and
+		// generation is available, or as stale until it is regenerated
+		if backupData := m.getFallbackTile(ctx, c); backupData != nil {
+			metrics.TileRequests.WithLabelValues("backup").Inc()
+			if tileRequester == nil {
+				return backupData, interfaces.TileInvalidated, nil
+			}
+			if fallbackCurrent {
+				return backupData, interfaces.TileValid, nil
+			}
+			tileRequester.RequestTileAsync(c)
+			return backupData, interfaces.TileInvalidated, nil
+		}
+		metrics.TileRequests.WithLabelValues("miss").Inc()
+		return nil, interfaces.TileNotFound, nil
+	}
+	metrics.TileRequests.WithLabelValues(tileStatusLabel(status)).Inc()
 
 	switch status {
-	case interfaces.TileValid:
-		return data, nil
-
-	case interfaces.TileEmpty, interfaces.TileNotFound:
-		return nil, nil
+	case interfaces.TileValid, interfaces.TileEmpty:
+		return data, status, nil
 
 	case interfaces.TileInvalidated:
-		// Request priority generation
-		if m.tileRequester != nil {
-			newData, err := m.tileRequester.RequestTile(c)
-			if err != nil {
-				// Timeout - return stale data if available
-				if len(data) > 0 {
-					return data, nil
-				}
-				return nil, nil
+		if tileRequester == nil {
+			// No requester (PostGIS unavailable), return stale data
+			if !generated {
+				data = m.getFallbackTile(ctx, c)
 			}
-			return newData, nil
+			return data, interfaces.TileInvalidated, nil
 		}
-		// No requester, return stale data
-		if len(data) > 0 {
-			return data, nil
+
+		// Stale-while-revalidate: serve the old tile and regenerate asynchronously
+		if generated && m.serveStale {
+			tileRequester.RequestTileAsync(c)
+			return data, interfaces.TileInvalidated, nil
+		}
+
+		// Request priority generation and wait for it
+		newData, err := tileRequester.RequestTile(ctx, c)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Client went away, nobody to serve
+				return nil, interfaces.TileInvalidated, ctx.Err()
+			}
+			// Timeout - return stale data if available
+			return data, interfaces.TileInvalidated, nil
 		}
-		return nil, nil
+		if len(newData) == 0 {
+			return nil, interfaces.TileEmpty, nil
+		}
+		return newData, interfaces.TileValid, nil
+	}
+
+	return nil, status, nil
+}
+
+// getFallbackTile returns the tile from the fallback provider, or nil if unavailable
+func (m *MVTMemoryStorage) getFallbackTile(ctx context.Context, c entities.TileCoordinates) []byte {
+	if m.fallback == nil {
+		return nil
 	}
 
-	return nil, nil
+	data, err := m.fallback.GetTile(ctx, c)
+	if err != nil {
+		log.Printf("Failed to get tile %d/%d/%d from backup: %v", c.Z, c.X, c.Y, err)
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return data
 }
 
 // GetTileWithStatus retrieves a tile and its status from the cache
@@ -114,10 +209,18 @@ func (m *MVTMemoryStorage) StoreTile(c entities.TileCoordinates, data []byte) er
 	}
 
 	m.cacheMutex.Lock()
+	if previous, exists := m.cache[tileKey]; exists {
+		m.bytes.Add(-int64(len(previous.data)))
+	} else {
+		m.entries.Add(1)
+	}
 	m.cache[tileKey] = &cacheEntry{
-		data:   data,
-		status: status,
+		coords:    c,
+		data:      data,
+		status:    status,
+		generated: true,
 	}
+	m.bytes.Add(int64(len(data)))
 	m.cacheMutex.Unlock()
 
 	return nil
@@ -130,11 +233,13 @@ func (m *MVTMemoryStorage) InvalidateTiles(tiles []entities.TileCoordinates) err
 		tileKey := fmt.Sprintf("%d-%d-%d", c.Z, c.X, c.Y)
 		if entry, exists := m.cache[tileKey]; exists {
 			entry.status = interfaces.TileInvalidated
-		}else{
+		} else {
 			m.cache[tileKey] = &cacheEntry{
-				data: []byte{},
+				coords: c,
+				data:   []byte{},
 				status: interfaces.TileInvalidated,
 			}
+			m.entries.Add(1)
 		}
 	}
 	m.cacheMutex.Unlock()
@@ -142,6 +247,39 @@ func (m *MVTMemoryStorage) InvalidateTiles(tiles []entities.TileCoordinates) err
 	return nil
 }
 
+// ListTiles returns the coordinates of all cached tiles within a zoom range
+func (m *MVTMemoryStorage) ListTiles(minZoom, maxZoom int) []entities.TileCoordinates {
+	m.cacheMutex.RLock()
+	defer m.cacheMutex.RUnlock()
+
+	var tiles []entities.TileCoordinates
+	for _, entry := range m.cache {
+		if entry.coords.Z >= minZoom && entry.coords.Z <= maxZoom {
+			tiles = append(tiles, entry.coords)
+		}
+	}
+	return tiles
+}
+
+// Stats returns the number of cached entries and the total size of their tile data
+func (m *MVTMemoryStorage) Stats() (entries int, bytes int64) {
+	return int(m.entries.Load()), m.bytes.Load()
+}
+
+// tileStatusLabel returns the metrics label of a tile status
+func tileStatusLabel(status interfaces.TileStatus) string {
+	switch status {
+	case interfaces.TileValid:
+		return "valid"
+	case interfaces.TileEmpty:
+		return "empty"
+	case interfaces.TileInvalidated:
+		return "invalidated"
+	default:
+		return "miss"
+	}
+}
+
 // ClearAllTiles clears the entire cache
 func (m *MVTMemoryStorage) ClearAllTiles() error {
 	m.cacheMutex.Lock()
@@ -149,6 +287,8 @@ func (m *MVTMemoryStorage) ClearAllTiles() error {
 
 	cacheSize := len(m.cache)
 	m.cache = make(map[string]*cacheEntry)
+	m.entries.Store(0)
+	m.bytes.Store(0)
 	log.Printf("Cleared entire MVT cache (%d tiles)", cacheSize)
 
 	return nil
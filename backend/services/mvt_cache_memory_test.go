@@ -0,0 +1,97 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+)
+
+// stubFallback serves the same tile for any coordinates
+type stubFallback struct {
+	interfaces.MVTProvider
+	data []byte
+}
+
+func (f stubFallback) GetTile(context.Context, entities.TileCoordinates) ([]byte, error) {
+	return f.data, nil
+}
+
+// stubRequester records tile requests, generating data for those waiting for it
+type stubRequester struct {
+	data      []byte
+	mu        sync.Mutex
+	requested []entities.TileCoordinates
+}
+
+func (r *stubRequester) RequestTile(_ context.Context, c entities.TileCoordinates) ([]byte, error) {
+	r.RequestTileAsync(c)
+	return r.data, nil
+}
+
+func (r *stubRequester) RequestTileAsync(c entities.TileCoordinates) {
+	r.mu.Lock()
+	r.requested = append(r.requested, c)
+	r.mu.Unlock()
+}
+
+func TestServeTileFallback(t *testing.T) {
+	tile := entities.TileCoordinates{Z: 12, X: 2138, Y: 1447}
+	backup := []byte{0x1a, 0x00}
+	generated := []byte{0x1a, 0x01}
+
+	tests := []struct {
+		name        string
+		live        bool // A tile requester is set
+		current     bool
+		invalidated bool // The tile was invalidated since the snapshot
+		wantData    []byte
+		wantStatus  interfaces.TileStatus
+		wantRequest bool
+	}{
+		{name: "current snapshot", live: true, current: true,
+			wantData: backup, wantStatus: interfaces.TileValid},
+		{name: "outdated snapshot", live: true,
+			wantData: backup, wantStatus: interfaces.TileInvalidated, wantRequest: true},
+		{name: "PostGIS down", current: true,
+			wantData: backup, wantStatus: interfaces.TileInvalidated},
+		{name: "invalidated range", live: true, current: true, invalidated: true,
+			wantData: generated, wantStatus: interfaces.TileValid, wantRequest: true},
+		{name: "invalidated range with PostGIS down", current: true, invalidated: true,
+			wantData: backup, wantStatus: interfaces.TileInvalidated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewMVTService(true)
+			cache.SetFallback(stubFallback{data: backup})
+			cache.SetFallbackCurrent(tt.current)
+			if tt.invalidated {
+				if err := cache.InvalidateTiles([]entities.TileCoordinates{tile}); err != nil {
+					t.Fatalf("InvalidateTiles: %v", err)
+				}
+			}
+
+			requester := &stubRequester{data: generated}
+			if tt.live {
+				cache.SetTileRequester(requester)
+			}
+
+			data, status, err := cache.ServeTile(context.Background(), tile)
+			if err != nil {
+				t.Fatalf("ServeTile: %v", err)
+			}
+			if !bytes.Equal(data, tt.wantData) {
+				t.Errorf("ServeTile data = %x, want %x", data, tt.wantData)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("ServeTile status = %v, want %v", status, tt.wantStatus)
+			}
+			if requested := len(requester.requested) > 0; requested != tt.wantRequest {
+				t.Errorf("regeneration requested = %v, want %v", requested, tt.wantRequest)
+			}
+		})
+	}
+}
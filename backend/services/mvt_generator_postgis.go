@@ -3,8 +3,11 @@ package services
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"bike-map/config"
 	"bike-map/entities"
@@ -26,26 +29,22 @@ type trailPostgis struct {
 	ElevationJSON string
 	CreatedAt     interface{}
 	UpdatedAt     interface{}
-	RatingAvg     float64
-	RatingCount   int
-	CommentCount  int
 	Ridden        bool
+	SyncHash      string
 }
 
 // MVTGeneratorPostgis handles all PostGIS database operations and implements MVTGenerator
 type MVTGeneratorPostgis struct {
-	db      *sql.DB
-	config  *config.Config
-	minZoom int
-	maxZoom int
+	db           *sql.DB
+	config       *config.Config
+	minZoom      int
+	maxZoom      int
+	queryTimeout time.Duration // Per-query timeout for tile generation
 }
 
 // NewPostGISService creates a new PostGIS service with database connection
 func NewPostGISService(cfg *config.Config) (*MVTGeneratorPostgis, error) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.Database)
-
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", postgresConnString(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostGIS: %w", err)
 	}
@@ -59,14 +58,32 @@ func NewPostGISService(cfg *config.Config) (*MVTGeneratorPostgis, error) {
 		return nil, fmt.Errorf("failed to ping PostGIS: %w", err)
 	}
 
+	// Bring the schema up to date before anything queries it
+	migrator, err := NewPostGISMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := migrator.Migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate PostGIS schema: %w", err)
+	}
+
 	return &MVTGeneratorPostgis{
-		db:      db,
-		config:  cfg,
-		minZoom: 6,
-		maxZoom: 18,
+		db:           db,
+		config:       cfg,
+		minZoom:      6,
+		maxZoom:      18,
+		queryTimeout: time.Duration(cfg.Tiles.QueryTimeoutSeconds) * time.Second,
 	}, nil
 }
 
+// postgresConnString builds the PostGIS connection string from configuration
+func postgresConnString(cfg *config.Config) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.Database)
+}
+
 // GetMinZoom returns the minimum zoom level for MVT tiles
 func (p *MVTGeneratorPostgis) GetMinZoom() int {
 	return p.minZoom
@@ -96,10 +113,8 @@ func (p *MVTGeneratorPostgis) trailToPostgis(trail entities.Trail) trailPostgis
 		ElevationJSON: trail.ElevationJSON,
 		CreatedAt:     trail.CreatedAt,
 		UpdatedAt:     trail.UpdatedAt,
-		RatingAvg:     trail.RatingAvg,
-		RatingCount:   trail.RatingCount,
-		CommentCount:  trail.CommentCount,
 		Ridden:        trail.Ridden,
+		SyncHash:      trail.SyncHash,
 	}
 }
 
@@ -116,8 +131,8 @@ func (p *MVTGeneratorPostgis) UpdateTrail(ctx context.Context, trail entities.Tr
 // insertTrail inserts or updates a trail in PostGIS (internal method)
 func (p *MVTGeneratorPostgis) insertTrail(ctx context.Context, trail trailPostgis) error {
 	query := `
-		INSERT INTO trails (id, name, description, level, tags, owner_id, gpx_file, geom, elevation_data, created_at, updated_at, rating_average, rating_count, comment_count, ridden)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, ST_GeomFromText($8, 4326), $9, $10, $11, $12, $13, $14, $15)
+		INSERT INTO trails (id, name, description, level, tags, owner_id, gpx_file, geom, elevation_data, created_at, updated_at, ridden, sync_hash, geom_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, ST_GeomFromText($8, 4326), $9, $10, $11, $12, $13, md5(ST_AsText(ST_GeomFromText($8, 4326))))
 		ON CONFLICT (id) DO UPDATE SET
 			name = EXCLUDED.name,
 			description = EXCLUDED.description,
@@ -128,10 +143,9 @@ func (p *MVTGeneratorPostgis) insertTrail(ctx context.Context, trail trailPostgi
 			geom = EXCLUDED.geom,
 			elevation_data = EXCLUDED.elevation_data,
 			updated_at = EXCLUDED.updated_at,
-			rating_average = EXCLUDED.rating_average,
-			rating_count = EXCLUDED.rating_count,
-			comment_count = EXCLUDED.comment_count,
-			ridden = EXCLUDED.ridden`
+			ridden = EXCLUDED.ridden,
+			sync_hash = EXCLUDED.sync_hash,
+			geom_hash = EXCLUDED.geom_hash`
 
 	_, err := p.db.ExecContext(ctx, query,
 		trail.ID,
@@ -145,10 +159,8 @@ func (p *MVTGeneratorPostgis) insertTrail(ctx context.Context, trail trailPostgi
 		trail.ElevationJSON,
 		trail.CreatedAt,
 		trail.UpdatedAt,
-		trail.RatingAvg,
-		trail.RatingCount,
-		trail.CommentCount,
 		trail.Ridden,
+		trail.SyncHash,
 	)
 
 	if err != nil {
@@ -191,6 +203,168 @@ func (p *MVTGeneratorPostgis) GetTrailTiles(ctx context.Context, trailID string)
 	return tiles, rows.Err()
 }
 
+// GetTrailSyncHashes returns the sync hash of every trail in PostGIS, keyed by trail ID
+func (p *MVTGeneratorPostgis) GetTrailSyncHashes(ctx context.Context) (map[string]string, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, COALESCE(sync_hash, '') FROM trails`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trail sync hashes: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan trail sync hash: %w", err)
+		}
+		hashes[id] = hash
+	}
+
+	return hashes, rows.Err()
+}
+
+// GetTrailBBox returns the bounding box of a trail, or nil if the trail has no geometry in PostGIS
+func (p *MVTGeneratorPostgis) GetTrailBBox(ctx context.Context, trailID string) (*entities.BoundingBox, error) {
+	var bbox entities.BoundingBox
+	err := p.db.QueryRowContext(ctx, `
+		SELECT ST_YMax(bbox), ST_YMin(bbox), ST_XMax(bbox), ST_XMin(bbox)
+		FROM trails
+		WHERE id = $1 AND bbox IS NOT NULL`, trailID).
+		Scan(&bbox.North, &bbox.South, &bbox.East, &bbox.West)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trail bbox: %w", err)
+	}
+	return &bbox, nil
+}
+
+// GetTrailGeometry returns the distance, bounds, endpoints and elevation data of a trail,
+// or nil if the trail has no geometry in PostGIS
+func (p *MVTGeneratorPostgis) GetTrailGeometry(ctx context.Context, trailID string) (*entities.TrailGeometry, error) {
+	var (
+		g         entities.TrailGeometry
+		elevation []byte
+	)
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COALESCE(distance_m, 0),
+			ST_YMax(bbox), ST_YMin(bbox), ST_XMax(bbox), ST_XMin(bbox),
+			ST_Y(ST_StartPoint(geom)), ST_X(ST_StartPoint(geom)),
+			ST_Y(ST_EndPoint(geom)), ST_X(ST_EndPoint(geom)),
+			ST_NPoints(geom), elevation_data
+		FROM trails
+		WHERE id = $1 AND geom IS NOT NULL`, trailID).
+		Scan(&g.DistanceM,
+			&g.BBox.North, &g.BBox.South, &g.BBox.East, &g.BBox.West,
+			&g.Start.Lat, &g.Start.Lng, &g.End.Lat, &g.End.Lng,
+			&g.PointCount, &elevation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trail geometry: %w", err)
+	}
+
+	if len(elevation) > 0 {
+		var data entities.ElevationData
+		if err := json.Unmarshal(elevation, &data); err != nil {
+			return nil, fmt.Errorf("failed to decode elevation data: %w", err)
+		}
+		g.Elevation = &data
+	}
+	return &g, nil
+}
+
+// GetTrailsExtent returns the bounding box of all trails, or nil if there are none
+func (p *MVTGeneratorPostgis) GetTrailsExtent(ctx context.Context) (*entities.BoundingBox, error) {
+	var north, south, east, west sql.NullFloat64
+	err := p.db.QueryRowContext(ctx, `
+		SELECT ST_YMax(e), ST_YMin(e), ST_XMax(e), ST_XMin(e)
+		FROM (SELECT ST_Extent(bbox) AS e FROM trails) extent`).
+		Scan(&north, &south, &east, &west)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trails extent: %w", err)
+	}
+	if !north.Valid {
+		return nil, nil
+	}
+	return &entities.BoundingBox{North: north.Float64, South: south.Float64, East: east.Float64, West: west.Float64}, nil
+}
+
+// GetTrailIDsInBBox returns the IDs of the trails whose bounding box intersects bbox
+func (p *MVTGeneratorPostgis) GetTrailIDsInBBox(ctx context.Context, bbox entities.BoundingBox) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id FROM trails
+		WHERE bbox && ST_MakeEnvelope($1, $2, $3, $4, 4326)
+		ORDER BY id`,
+		bbox.West, bbox.South, bbox.East, bbox.North)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trails in bbox: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan trail id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// GetTrailStates returns the reconciliation state of every trail in PostGIS, keyed by trail ID
+func (p *MVTGeneratorPostgis) GetTrailStates(ctx context.Context) (map[string]entities.GeneratorTrailState, error) {
+	query := `
+		SELECT t.id,
+			COALESCE(t.sync_hash, ''),
+			t.geom_hash IS NULL OR t.geom_hash = md5(ST_AsText(t.geom)),
+			t.geom IS NOT NULL,
+			(SELECT COUNT(*) FROM trail_tiles tt WHERE tt.trail_id = t.id)
+		FROM trails t`
+
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trail states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]entities.GeneratorTrailState)
+	for rows.Next() {
+		var id string
+		var st entities.GeneratorTrailState
+		if err := rows.Scan(&id, &st.SyncHash, &st.GeometryValid, &st.HasGeometry, &st.TileCount); err != nil {
+			return nil, fmt.Errorf("failed to scan trail state: %w", err)
+		}
+		states[id] = st
+	}
+
+	return states, rows.Err()
+}
+
+// GetAllTiles returns every tile covered by at least one trail
+func (p *MVTGeneratorPostgis) GetAllTiles(ctx context.Context) ([]entities.TileCoordinates, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT DISTINCT z, x, y FROM trail_tiles ORDER BY z, x, y`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trail tiles: %w", err)
+	}
+	defer rows.Close()
+
+	var tiles []entities.TileCoordinates
+	for rows.Next() {
+		var t entities.TileCoordinates
+		if err := rows.Scan(&t.Z, &t.X, &t.Y); err != nil {
+			return nil, fmt.Errorf("failed to scan tile: %w", err)
+		}
+		tiles = append(tiles, t)
+	}
+
+	return tiles, rows.Err()
+}
+
 // ClearAllTrails removes all trails from PostGIS
 func (p *MVTGeneratorPostgis) ClearAllTrails(ctx context.Context) error {
 	query := `DELETE FROM trails`
@@ -208,13 +382,21 @@ func (p *MVTGeneratorPostgis) ClearAllTrails(ctx context.Context) error {
 	return nil
 }
 
-// GetTile retrieves or generates MVT tile data for the given coordinates
+// GetTile retrieves or generates MVT tile data for the given coordinates.
+// The query is cancelled on the server when ctx is done or the query timeout elapses.
 func (p *MVTGeneratorPostgis) GetTile(ctx context.Context, c entities.TileCoordinates) ([]byte, error) {
 	query := `SELECT generate_mvt_tile($1, $2, $3)`
 
+	queryCtx, cancel := context.WithTimeout(ctx, p.queryTimeout)
+	defer cancel()
+
 	var data []byte
-	err := p.db.QueryRowContext(ctx, query, c.Z, c.X, c.Y).Scan(&data)
+	err := p.db.QueryRowContext(queryCtx, query, c.Z, c.X, c.Y).Scan(&data)
 	if err != nil {
+		// Report cancellation and timeout as context errors rather than driver errors
+		if ctxErr := queryCtx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("failed to get tile: %w", ctxErr)
+		}
 		return nil, fmt.Errorf("failed to get tile: %w", err)
 	}
 
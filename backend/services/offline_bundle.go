@@ -0,0 +1,458 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/utils"
+
+	"github.com/pocketbase/pocketbase/core"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	offlineBundlePrefix      = "bundle-"
+	offlineBundleMaxTrails   = 500 // Trails per bundle
+	offlineBundleMaxComments = 50  // Latest comments per trail
+)
+
+// Files of an offline bundle
+const (
+	offlineBundleTilesFile    = "tiles.mbtiles"
+	offlineBundleTrailsFile   = "trails.geojson"
+	offlineBundleCommentsFile = "comments.json"
+	offlineBundleGPXDir       = "gpx/"
+	offlineBundleManifestFile = "manifest.json"
+)
+
+// OfflineBundleService builds ZIP bundles for offline use: an MBTiles extract of the area, the
+// trails as GeoJSON with their full elevation profiles, their latest comments, their GPX files and
+// a manifest. Bundles are versioned by content and cached along with the MBTiles extracts.
+type OfflineBundleService struct {
+	app        core.App
+	extracts   *MBTilesExtractService
+	engagement interfaces.Engagement
+
+	// Finds the trails of an area, nil while PostGIS is unavailable
+	mu        sync.RWMutex
+	generator interfaces.MVTGenerator
+
+	builds singleflight.Group
+}
+
+// offlineBundleContent is the data a bundle is built from
+type offlineBundleContent struct {
+	manifest entities.OfflineBundleManifest
+	extract  *entities.MBTilesExtract
+	trails   []*core.Record
+	stats    map[string]*entities.EngagementStats
+	comments map[string][]entities.TrailComment
+}
+
+// NewOfflineBundleService creates a new offline bundle service
+func NewOfflineBundleService(app core.App, extracts *MBTilesExtractService, engagement interfaces.Engagement) *OfflineBundleService {
+	return &OfflineBundleService{
+		app:        app,
+		extracts:   extracts,
+		engagement: engagement,
+	}
+}
+
+// SetGenerator sets the generator used to find the trails of an area
+func (s *OfflineBundleService) SetGenerator(generator interfaces.MVTGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generator = generator
+}
+
+// Bundle returns the offline bundle of an area, building it unless its current version is cached
+func (s *OfflineBundleService) Bundle(ctx context.Context, req entities.OfflineBundleRequest) (*entities.OfflineBundle, error) {
+	s.mu.RLock()
+	generator := s.generator
+	s.mu.RUnlock()
+	if generator == nil {
+		return nil, interfaces.ErrTrailLookupUnavailable
+	}
+
+	bbox, trailIDs, err := s.resolveArea(ctx, generator, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(trailIDs) > offlineBundleMaxTrails {
+		return nil, interfaces.ErrBundleTooLarge
+	}
+
+	content := offlineBundleContent{
+		stats:    map[string]*entities.EngagementStats{},
+		comments: map[string][]entities.TrailComment{},
+	}
+	if len(trailIDs) > 0 {
+		if content.trails, err = s.app.FindRecordsByIds("trails", trailIDs); err != nil {
+			return nil, fmt.Errorf("failed to get trails: %w", err)
+		}
+		if len(req.TrailIDs) > 0 && len(content.trails) != len(trailIDs) {
+			return nil, interfaces.ErrTrailNotFound
+		}
+		if content.stats, err = s.engagement.GetEngagementStatsBulk(ctx, trailIDs); err != nil {
+			return nil, err
+		}
+		if content.comments, err = s.engagement.GetLatestComments(ctx, trailIDs, offlineBundleMaxComments); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(content.trails, func(i, j int) bool {
+		return content.trails[i].Id < content.trails[j].Id
+	})
+
+	snapshotPath, err := latestSnapshotPath(s.extracts.cfg.snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotPath == "" {
+		return nil, interfaces.ErrNoSnapshot
+	}
+
+	maxZoom := s.extracts.cfg.maxZoom
+	if req.MaxZoom > 0 {
+		maxZoom = min(max(req.MaxZoom, s.extracts.cfg.minZoom), s.extracts.cfg.maxZoom)
+	}
+
+	content.manifest = entities.OfflineBundleManifest{
+		FormatVersion: entities.OfflineBundleFormatVersion,
+		Region:        strings.ToLower(req.Region),
+		BBox:          bbox,
+		MaxZoom:       maxZoom,
+		Snapshot:      filepath.Base(snapshotPath),
+	}
+	content.manifest.Version = offlineBundleVersion(content)
+
+	if epoch, seq, err := readSnapshotJournal(snapshotPath); err == nil {
+		content.manifest.JournalEpoch, content.manifest.JournalSeq = epoch, seq
+	}
+
+	id := content.manifest.Version[:16]
+	bundle := &entities.OfflineBundle{
+		Path:     filepath.Join(s.extracts.cacheDir, offlineBundlePrefix+id+".zip"),
+		Filename: fmt.Sprintf("%sbundle-%s.zip", entities.MBtilesFilePrefix, id),
+	}
+
+	if _, err := os.Stat(bundle.Path); err == nil {
+		manifest, err := readOfflineBundleManifest(bundle.Path)
+		if err == nil {
+			// Recently used bundles are kept longest
+			now := time.Now()
+			_ = os.Chtimes(bundle.Path, now, now)
+			bundle.Manifest = manifest
+			bundle.Cached = true
+			return bundle, nil
+		}
+		log.Printf("Warning: Rebuilding unreadable offline bundle %s: %v", filepath.Base(bundle.Path), err)
+	}
+
+	// Concurrent requests for the same bundle share a single build
+	manifest, err, _ := s.builds.Do(id, func() (any, error) {
+		extract, err := s.extracts.extractSnapshot(snapshotPath, bbox, maxZoom)
+		if err != nil {
+			return nil, err
+		}
+		content.extract = extract
+		return s.build(bundle.Path, content)
+	})
+	if err != nil {
+		return nil, err
+	}
+	bundle.Manifest = manifest.(*entities.OfflineBundleManifest)
+
+	s.extracts.pruneCache()
+	return bundle, nil
+}
+
+// resolveArea returns the bounding box and the trails of a bundle request.
+// The bounding box of a list of trails covers all of them.
+func (s *OfflineBundleService) resolveArea(ctx context.Context, generator interfaces.MVTGenerator, req entities.OfflineBundleRequest) (entities.BoundingBox, []string, error) {
+	if len(req.TrailIDs) > 0 {
+		// Checked before looking up each trail
+		if len(req.TrailIDs) > offlineBundleMaxTrails {
+			return entities.BoundingBox{}, nil, interfaces.ErrBundleTooLarge
+		}
+
+		var bbox *entities.BoundingBox
+		for _, trailID := range req.TrailIDs {
+			trailBBox, err := generator.GetTrailBBox(ctx, trailID)
+			if err != nil {
+				return entities.BoundingBox{}, nil, err
+			}
+			if trailBBox == nil {
+				return entities.BoundingBox{}, nil, interfaces.ErrTrailNotFound
+			}
+			if bbox == nil {
+				bbox = trailBBox
+				continue
+			}
+			bbox.West = min(bbox.West, trailBBox.West)
+			bbox.South = min(bbox.South, trailBBox.South)
+			bbox.East = max(bbox.East, trailBBox.East)
+			bbox.North = max(bbox.North, trailBBox.North)
+		}
+		return *bbox, req.TrailIDs, nil
+	}
+
+	bbox, err := s.extracts.resolveBBox(ctx, entities.MBTilesExtractRequest{BBox: req.BBox, Region: req.Region})
+	if err != nil {
+		return entities.BoundingBox{}, nil, err
+	}
+	trailIDs, err := generator.GetTrailIDsInBBox(ctx, bbox)
+	if err != nil {
+		return entities.BoundingBox{}, nil, err
+	}
+	return bbox, trailIDs, nil
+}
+
+// offlineBundleVersion fingerprints everything a bundle is built from: the area, the snapshot,
+// the trail records and their engagement, and the latest comments
+func offlineBundleVersion(content offlineBundleContent) string {
+	m := content.manifest
+	h := sha256.New()
+	fmt.Fprintf(h, "v%d|%s|%.6f,%.6f,%.6f,%.6f|%d|%s\n",
+		m.FormatVersion, m.Region, m.BBox.West, m.BBox.South, m.BBox.East, m.BBox.North, m.MaxZoom, m.Snapshot)
+
+	for _, trail := range content.trails {
+		fmt.Fprintf(h, "%s|%s", trail.Id, trailSyncHash(trail))
+		if st := content.stats[trail.Id]; st != nil {
+			fmt.Fprintf(h, "|%.4f|%d|%d", st.RatingAvg, st.RatingCount, st.CommentCount)
+		}
+		for _, c := range content.comments[trail.Id] {
+			fmt.Fprintf(h, "|%s@%s", c.ID, c.Updated.Format(time.RFC3339Nano))
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// build writes the bundle ZIP to path and returns its manifest. The ZIP is built aside and renamed into place.
+func (s *OfflineBundleService) build(path string, content offlineBundleContent) (*entities.OfflineBundleManifest, error) {
+	start := time.Now()
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".build-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	manifest := content.manifest
+	manifest.CreatedAt = time.Now().UTC()
+	manifest.Files = []entities.OfflineBundleFile{}
+
+	zw := zip.NewWriter(tmp)
+	add := func(name string, write func(w io.Writer) error) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to bundle: %w", name, err)
+		}
+		h := sha256.New()
+		counter := &countingWriter{w: io.MultiWriter(w, h)}
+		if err := write(counter); err != nil {
+			return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+		}
+		manifest.Files = append(manifest.Files, entities.OfflineBundleFile{
+			Name:      name,
+			SizeBytes: counter.n,
+			SHA256:    hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	}
+
+	err = add(offlineBundleTilesFile, func(w io.Writer) error {
+		f, err := os.Open(content.extract.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// GPX files are written as the trails are read, so they are never all held in memory
+	features := make([]entities.GeoJSONFeature, 0, len(content.trails))
+	comments := make(map[string][]entities.TrailComment, len(content.trails))
+	for _, trail := range content.trails {
+		gpxFile := trail.GetString("file")
+		gpxData, err := readGPXFromPocketBase(s.app, trail, gpxFile)
+		if err != nil {
+			log.Printf("Warning: Offline bundle skips trail %s: %v", trail.Id, err)
+			continue
+		}
+		parsed, err := utils.ParseGPXFile(gpxData)
+		if err != nil {
+			log.Printf("Warning: Offline bundle skips trail %s: %v", trail.Id, err)
+			continue
+		}
+
+		gpxName := offlineBundleGPXDir + trail.Id + ".gpx"
+		if err := add(gpxName, func(w io.Writer) error {
+			_, err := w.Write(gpxData)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+
+		features = append(features, offlineTrailFeature(trail, parsed.Points, parsed.ElevationData, content.stats[trail.Id], gpxName))
+		if trailComments := content.comments[trail.Id]; len(trailComments) > 0 {
+			comments[trail.Id] = trailComments
+			manifest.CommentCount += len(trailComments)
+		}
+	}
+	manifest.TrailCount = len(features)
+
+	if err := add(offlineBundleTrailsFile, writeJSON(entities.GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features})); err != nil {
+		return nil, err
+	}
+	if err := add(offlineBundleCommentsFile, writeJSON(comments)); err != nil {
+		return nil, err
+	}
+
+	// The manifest lists every other file, so it is written last
+	w, err := zw.Create(offlineBundleManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add manifest to bundle: %w", err)
+	}
+	if err := writeJSON(manifest)(w); err != nil {
+		return nil, fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close bundle: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, fmt.Errorf("failed to move bundle into the cache: %w", err)
+	}
+
+	log.Printf("Offline bundle built: %s - %d trails, %d comments in %s",
+		filepath.Base(path), manifest.TrailCount, manifest.CommentCount, time.Since(start).Round(time.Millisecond))
+	return &manifest, nil
+}
+
+// offlineTrailFeature converts a trail record and its parsed GPX track into a GeoJSON feature
+func offlineTrailFeature(
+	trail *core.Record,
+	points []entities.TrackPoint,
+	elevation *entities.ElevationData,
+	stats *entities.EngagementStats,
+	gpxName string,
+) entities.GeoJSONFeature {
+	coordinates := make([][]float64, len(points))
+	for i, p := range points {
+		if p.Elevation != nil {
+			coordinates[i] = []float64{p.Lon, p.Lat, *p.Elevation}
+		} else {
+			coordinates[i] = []float64{p.Lon, p.Lat}
+		}
+	}
+
+	tags := []string{}
+	if err := trail.UnmarshalJSONField("tags", &tags); err != nil || tags == nil {
+		tags = []string{}
+	}
+
+	props := entities.OfflineTrailProperties{
+		ID:          trail.Id,
+		Name:        trail.GetString("name"),
+		Description: trail.GetString("description"),
+		Level:       trail.GetString("level"),
+		Tags:        tags,
+		OwnerID:     trail.GetString("owner"),
+		Ridden:      trail.GetBool("ridden"),
+		Created:     trail.GetDateTime("created").Time(),
+		Updated:     trail.GetDateTime("updated").Time(),
+		GPXFile:     gpxName,
+		Elevation:   elevation,
+	}
+	if elevation != nil && len(elevation.Profile) > 0 {
+		props.DistanceM = elevation.Profile[len(elevation.Profile)-1].Distance
+	}
+	if stats != nil {
+		props.RatingAverage = stats.RatingAvg
+		props.RatingCount = stats.RatingCount
+		props.CommentCount = stats.CommentCount
+	}
+
+	return entities.GeoJSONFeature{
+		Type:       "Feature",
+		ID:         trail.Id,
+		Geometry:   entities.GeoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+		Properties: props,
+	}
+}
+
+// readOfflineBundleManifest reads the manifest of a cached bundle
+func readOfflineBundleManifest(path string) (*entities.OfflineBundleManifest, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	f, err := zr.Open(offlineBundleManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var manifest entities.OfflineBundleManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version == "" {
+		return nil, errors.New("manifest has no version")
+	}
+	return &manifest, nil
+}
+
+// writeJSON returns a function encoding v to a writer
+func writeJSON(v any) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Compile-time check to ensure OfflineBundleService implements interfaces.OfflineBundler
+var _ interfaces.OfflineBundler = (*OfflineBundleService)(nil)
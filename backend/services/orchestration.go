@@ -2,26 +2,53 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"bike-map/entities"
 	"bike-map/interfaces"
+	"bike-map/metrics"
 	"bike-map/utils"
 
 	"github.com/pocketbase/pocketbase/core"
+	"golang.org/x/sync/singleflight"
 )
 
 // TileRequest represents a priority tile generation request
 type TileRequest struct {
+	Ctx      context.Context // Cancelled once nobody waits for the tile anymore
 	Coords   entities.TileCoordinates
-	Response chan []byte
+	Response chan TileResult
+}
+
+// TileResult is the outcome of a priority tile generation
+type TileResult struct {
+	Data []byte
+	Err  error
+}
+
+// tileFlight tracks the waiters sharing one priority generation
+type tileFlight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// TileWorkerConfig holds tile generation behavior configuration
+type TileWorkerConfig struct {
+	workers             int           // Number of concurrent tile workers
+	requestTimeout      time.Duration // Max wait for a priority tile request (queueing + generation)
+	backgroundQueueSize int           // Max distinct tiles pending background generation
 }
 
 // SnapshotConfig holds snapshot behavior configuration
@@ -37,16 +64,33 @@ type OrchestrationService struct {
 	cache             interfaces.MVTCache
 	backup            interfaces.MVTBackup
 	engagementService interfaces.Engagement
+	events            interfaces.TileEventPublisher
+	cluster           interfaces.ClusterCoordinator
 
 	// Tile generation queues
 	priorityQueue   chan TileRequest
-	backgroundQueue chan entities.TileCoordinates
+	backgroundQueue *TileWorkQueue
 	stopChan        chan struct{}
 	wg              sync.WaitGroup
+	workerConfig    TileWorkerConfig
+
+	// Coalesces concurrent priority requests for the same tile into one generation
+	tileGroup singleflight.Group
+	flightsMu sync.Mutex
+	flights   map[string]*tileFlight
+
+	// Cancelled on Stop, aborts in-flight background queries
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Priority request accounting
+	cancelledRequests    atomic.Uint64
+	abandonedGenerations atomic.Uint64
+	timedOutGenerations  atomic.Uint64
 
 	// Queue monitoring for snapshots
 	snapshotConfig       SnapshotConfig
-	lastEmptyTime        atomic.Value  // stores time.Time
+	lastEmptyTime        atomic.Value // stores time.Time
 	queueMonitorStopChan chan struct{}
 }
 
@@ -56,24 +100,38 @@ func NewOrchestrationService(
 	engagementService interfaces.Engagement,
 	cache interfaces.MVTCache,
 	backup interfaces.MVTBackup,
+	events interfaces.TileEventPublisher,
+	cluster interfaces.ClusterCoordinator,
+	workerCfg TileWorkerConfig,
 	cfg SnapshotConfig,
 ) *OrchestrationService {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	o := &OrchestrationService{
 		mvtGenerator:         mvtGenerator,
 		cache:                cache,
 		backup:               backup,
 		engagementService:    engagementService,
+		events:               events,
+		cluster:              cluster,
 		priorityQueue:        make(chan TileRequest, 100000),
-		backgroundQueue:      make(chan entities.TileCoordinates, 1000000),
+		backgroundQueue:      NewTileWorkQueue(workerCfg.backgroundQueueSize),
 		stopChan:             make(chan struct{}),
+		workerConfig:         workerCfg,
+		flights:              make(map[string]*tileFlight),
+		ctx:                  ctx,
+		cancel:               cancel,
 		snapshotConfig:       cfg,
 		queueMonitorStopChan: make(chan struct{}),
 	}
 
 	o.lastEmptyTime.Store(time.Time{})
 
-	o.wg.Add(1)
-	go o.tileWorker()
+	workers := max(workerCfg.workers, 1)
+	for i := 0; i < workers; i++ {
+		o.wg.Add(1)
+		go o.tileWorker(i)
+	}
 
 	// Always start queue monitor if backup available
 	if backup != nil {
@@ -84,10 +142,11 @@ func NewOrchestrationService(
 	return o
 }
 
-// tileWorker processes tile generation requests, prioritizing handler requests
-func (o *OrchestrationService) tileWorker() {
+// tileWorker processes tile generation requests, prioritizing handler requests.
+// Every worker of the pool drains the priority queue before touching background work.
+func (o *OrchestrationService) tileWorker(id int) {
 	defer o.wg.Done()
-	log.Println("Tile worker started")
+	log.Printf("Tile worker %d started", id)
 
 	for {
 		// Priority queue takes precedence
@@ -96,19 +155,24 @@ func (o *OrchestrationService) tileWorker() {
 			o.processPriorityRequest(req)
 			continue
 		case <-o.stopChan:
-			log.Println("Tile worker stopping")
+			log.Printf("Tile worker %d stopping", id)
 			return
 		default:
 		}
 
-		// If no priority requests, process background or wait
+		// If no priority requests, process background
+		if coords, ok := o.backgroundQueue.Pop(); ok {
+			o.processBackgroundTile(coords)
+			continue
+		}
+
+		// Nothing to do, wait for work
 		select {
 		case req := <-o.priorityQueue:
 			o.processPriorityRequest(req)
-		case coords := <-o.backgroundQueue:
-			o.processBackgroundTile(coords)
+		case <-o.backgroundQueue.Ready():
 		case <-o.stopChan:
-			log.Println("Tile worker stopping")
+			log.Printf("Tile worker %d stopping", id)
 			return
 		}
 	}
@@ -116,19 +180,33 @@ func (o *OrchestrationService) tileWorker() {
 
 // processPriorityRequest handles a priority tile generation request
 func (o *OrchestrationService) processPriorityRequest(req TileRequest) {
-	data, err := o.mvtGenerator.GetTile(context.Background(), req.Coords)
+	// Every waiter left while the request was queued, don't spend database time on it
+	if err := req.Ctx.Err(); err != nil {
+		o.abandonedGenerations.Add(1)
+		req.Response <- TileResult{Err: err}
+		return
+	}
+
+	data, err := o.generateTile(req.Ctx, req.Coords, "priority")
 	if err != nil {
-		log.Printf("Failed to generate priority tile %d/%d/%d: %v", req.Coords.Z, req.Coords.X, req.Coords.Y, err)
-		data = nil
+		o.countGenerationError(req.Ctx, err)
+		if req.Ctx.Err() == nil {
+			log.Printf("Failed to generate priority tile %d/%d/%d: %v", req.Coords.Z, req.Coords.X, req.Coords.Y, err)
+		}
+
+		// Tile stays invalidated and will be retried
+		req.Response <- TileResult{Err: err}
+		return
 	}
 
 	// Store in cache
 	if err := o.cache.StoreTile(req.Coords, data); err != nil {
 		log.Printf("Failed to store priority tile: %v", err)
 	}
+	o.events.TilesReady([]entities.TileCoordinates{req.Coords})
 
 	// Send response
-	req.Response <- data
+	req.Response <- TileResult{Data: data}
 }
 
 // processBackgroundTile handles a background tile generation request
@@ -139,8 +217,9 @@ func (o *OrchestrationService) processBackgroundTile(coords entities.TileCoordin
 	if status != interfaces.TileValid {
 		// Generate tile
 		var err error
-		data, err = o.mvtGenerator.GetTile(context.Background(), coords)
+		data, err = o.generateTile(o.ctx, coords, "background")
 		if err != nil {
+			o.countGenerationError(o.ctx, err)
 			log.Printf("Failed to generate background tile %d/%d/%d: %v", coords.Z, coords.X, coords.Y, err)
 			return
 		}
@@ -149,6 +228,7 @@ func (o *OrchestrationService) processBackgroundTile(coords entities.TileCoordin
 		if err := o.cache.StoreTile(coords, data); err != nil {
 			log.Printf("Failed to store background tile in cache: %v", err)
 		}
+		o.events.TilesReady([]entities.TileCoordinates{coords})
 	}
 
 	// Always store to backup (even if tile was already valid in cache)
@@ -159,28 +239,156 @@ func (o *OrchestrationService) processBackgroundTile(coords entities.TileCoordin
 	}
 }
 
-// RequestTile requests priority generation of a tile, blocking until complete or timeout
-func (o *OrchestrationService) RequestTile(coords entities.TileCoordinates) ([]byte, error) {
-	respChan := make(chan []byte, 1)
-	req := TileRequest{Coords: coords, Response: respChan}
+// generateTile generates a tile with the MVTGenerator and records latency and size metrics
+func (o *OrchestrationService) generateTile(ctx context.Context, coords entities.TileCoordinates, queue string) ([]byte, error) {
+	start := time.Now()
+	data, err := o.mvtGenerator.GetTile(ctx, coords)
+	if err != nil {
+		metrics.TileGenerationErrors.WithLabelValues(queue).Inc()
+		return nil, err
+	}
 
-	// Try to queue the request
-	select {
-	case o.priorityQueue <- req:
-		// Request queued
-	case <-time.After(5 * time.Second):
-		return nil, errors.New("timeout queuing tile request")
+	metrics.TileGenerationDuration.WithLabelValues(strconv.Itoa(coords.Z), queue).Observe(time.Since(start).Seconds())
+	metrics.TileSizeBytes.Observe(float64(len(data)))
+	metrics.TileLastGenerated.SetToCurrentTime()
+	return data, nil
+}
+
+// countGenerationError records why a tile generation did not complete
+func (o *OrchestrationService) countGenerationError(ctx context.Context, err error) {
+	switch {
+	case ctx.Err() != nil:
+		o.abandonedGenerations.Add(1)
+	case errors.Is(err, context.DeadlineExceeded):
+		o.timedOutGenerations.Add(1)
 	}
+}
+
+// RequestTile requests priority generation of a tile, blocking until complete, timeout or ctx cancellation.
+// Concurrent requests for the same tile share a single generation, which is cancelled once all of
+// them left. A request that times out stops waiting but keeps the generation alive, so the tile
+// still lands in the cache.
+func (o *OrchestrationService) RequestTile(ctx context.Context, coords entities.TileCoordinates) ([]byte, error) {
+	key := tileKey(coords)
+	flightCtx, release := o.joinFlight(key)
+	done := o.tileGroup.DoChan(key, o.priorityGeneration(flightCtx, coords))
+
+	timeout := time.NewTimer(o.workerConfig.requestTimeout)
+	defer timeout.Stop()
 
-	// Wait for response
 	select {
-	case data := <-respChan:
-		return data, nil
-	case <-time.After(5 * time.Second):
+	case res := <-done:
+		release()
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		release()
+		o.cancelledRequests.Add(1)
+		return nil, ctx.Err()
+	case <-timeout.C:
+		// Stay a waiter until the tile is generated, as RequestTileAsync does
+		go func() {
+			defer release()
+			<-done
+		}()
 		return nil, errors.New("timeout waiting for tile generation")
 	}
 }
 
+// RequestTileAsync queues priority regeneration of a tile without waiting for the result
+func (o *OrchestrationService) RequestTileAsync(coords entities.TileCoordinates) {
+	key := tileKey(coords)
+	flightCtx, release := o.joinFlight(key)
+	done := o.tileGroup.DoChan(key, o.priorityGeneration(flightCtx, coords))
+
+	// Stay a waiter until the tile is generated so the shared generation is not cancelled
+	go func() {
+		defer release()
+		<-done
+	}()
+}
+
+// joinFlight registers a waiter on the tile's shared generation and returns its context.
+// The release function must be called once the waiter no longer needs the tile.
+func (o *OrchestrationService) joinFlight(key string) (context.Context, func()) {
+	o.flightsMu.Lock()
+	defer o.flightsMu.Unlock()
+
+	flight, exists := o.flights[key]
+	if !exists {
+		ctx, cancel := context.WithCancel(o.ctx)
+		flight = &tileFlight{ctx: ctx, cancel: cancel}
+		o.flights[key] = flight
+	}
+	flight.waiters++
+
+	var once sync.Once
+	return flight.ctx, func() {
+		once.Do(func() {
+			o.flightsMu.Lock()
+			defer o.flightsMu.Unlock()
+
+			flight.waiters--
+			if flight.waiters > 0 {
+				return
+			}
+
+			// Last waiter left: abort the generation if still running and let the next request start fresh
+			flight.cancel()
+			delete(o.flights, key)
+			o.tileGroup.Forget(key)
+		})
+	}
+}
+
+// priorityGeneration returns the shared function that queues a priority request and waits for a worker
+func (o *OrchestrationService) priorityGeneration(ctx context.Context, coords entities.TileCoordinates) func() (any, error) {
+	return func() (any, error) {
+		respChan := make(chan TileResult, 1)
+		req := TileRequest{Ctx: ctx, Coords: coords, Response: respChan}
+
+		timeout := time.NewTimer(o.workerConfig.requestTimeout)
+		defer timeout.Stop()
+
+		// Try to queue the request
+		select {
+		case o.priorityQueue <- req:
+			// Request queued
+		case <-timeout.C:
+			return nil, errors.New("timeout queuing tile request")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// Wait for the worker; it always answers once the request is dequeued
+		select {
+		case res := <-respChan:
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			return res.Data, nil
+		case <-o.stopChan:
+			return nil, errors.New("tile workers stopped")
+		}
+	}
+}
+
+// TileRequestStats returns cancellation and timeout counters of priority tile requests
+func (o *OrchestrationService) TileRequestStats() entities.TileRequestStats {
+	return entities.TileRequestStats{
+		Cancelled: o.cancelledRequests.Load(),
+		Abandoned: o.abandonedGenerations.Load(),
+		Timeouts:  o.timedOutGenerations.Load(),
+	}
+}
+
+// tileKey returns the map/group key of a tile
+func tileKey(c entities.TileCoordinates) string {
+	return fmt.Sprintf("%d-%d-%d", c.Z, c.X, c.Y)
+}
+
 // queueMonitor periodically checks queue status and triggers snapshots
 func (o *OrchestrationService) queueMonitor() {
 	defer o.wg.Done()
@@ -202,10 +410,15 @@ func (o *OrchestrationService) queueMonitor() {
 	}
 }
 
-// checkAndSnapshot checks if queues are empty and triggers snapshot if stable
+// checkAndSnapshot checks if queues are empty and triggers snapshot if stable.
+// Only the cluster leader writes snapshots.
 func (o *OrchestrationService) checkAndSnapshot(lastSnapshotTime *time.Time) {
+	if !o.cluster.IsLeader() {
+		return
+	}
+
 	priorityLen := len(o.priorityQueue)
-	backgroundLen := len(o.backgroundQueue)
+	backgroundLen := o.backgroundQueue.Len()
 
 	now := time.Now()
 	bothEmpty := (priorityLen == 0 && backgroundLen == 0)
@@ -224,6 +437,7 @@ func (o *OrchestrationService) checkAndSnapshot(lastSnapshotTime *time.Time) {
 			if emptyDuration >= requiredDuration {
 				// Stable and empty - trigger snapshot
 				if lastSnapshotTime.IsZero() || now.Sub(*lastSnapshotTime) > requiredDuration {
+					o.refreshBackupSource()
 					if err := o.backup.Snapshot(); err != nil {
 						log.Printf("ERROR: Snapshot failed: %v", err)
 					}
@@ -242,6 +456,7 @@ func (o *OrchestrationService) checkAndSnapshot(lastSnapshotTime *time.Time) {
 
 // Stop gracefully shuts down the tile worker
 func (o *OrchestrationService) Stop() {
+	o.cancel()
 	close(o.stopChan)
 	close(o.queueMonitorStopChan)
 	o.wg.Wait()
@@ -265,6 +480,7 @@ func (s *OrchestrationService) HandleTrailCreated(ctx context.Context, app core.
 
 	// Invalidate tiles in all storages and queue for background generation
 	s.invalidateAndQueueTiles(tiles)
+	s.publishTrailChange(trailID, entities.SyncActionCreated, s.trailBBox(ctx, trailID))
 
 	log.Printf("Successfully handled trail creation: %s (queued %d tiles)", trailID, len(tiles))
 	return nil
@@ -274,6 +490,42 @@ func (s *OrchestrationService) HandleTrailCreated(ctx context.Context, app core.
 func (s *OrchestrationService) HandleTrailUpdated(ctx context.Context, app core.App, trailID string) error {
 	log.Printf("Handling trail update: %s", trailID)
 
+	oldBBox := s.trailBBox(ctx, trailID)
+
+	tiles, err := s.resyncTrail(ctx, app, trailID)
+	if err != nil {
+		return err
+	}
+
+	// Invalidate old and new tiles and queue for background generation
+	s.invalidateAndQueueTiles(tiles)
+	s.publishTrailChange(trailID, entities.SyncActionUpdated, unionBBox(oldBBox, s.trailBBox(ctx, trailID)))
+
+	log.Printf("Successfully handled trail update: %s (queued %d tiles)", trailID, len(tiles))
+	return nil
+}
+
+// HandleTrailDeleted handles trail deletion: get tiles, delete from generator, queue tile regeneration
+func (s *OrchestrationService) HandleTrailDeleted(ctx context.Context, trailID string) error {
+	log.Printf("Handling trail deletion: %s", trailID)
+
+	bbox := s.trailBBox(ctx, trailID)
+
+	tiles, err := s.removeTrail(ctx, trailID)
+	if err != nil {
+		return err
+	}
+
+	// Invalidate and queue affected tiles for regeneration
+	s.invalidateAndQueueTiles(tiles)
+	s.publishTrailChange(trailID, entities.SyncActionDeleted, bbox)
+
+	log.Printf("Successfully handled trail deletion: %s (queued %d tiles)", trailID, len(tiles))
+	return nil
+}
+
+// resyncTrail re-imports a trail into the generator and returns the tiles it covered before and after
+func (s *OrchestrationService) resyncTrail(ctx context.Context, app core.App, trailID string) ([]entities.TileCoordinates, error) {
 	// Get tiles for old trail position
 	oldTiles, err := s.mvtGenerator.GetTrailTiles(ctx, trailID)
 	if err != nil {
@@ -283,7 +535,7 @@ func (s *OrchestrationService) HandleTrailUpdated(ctx context.Context, app core.
 
 	// Update trail in generator
 	if err := s.syncTrailFromPBToGenerator(ctx, app, trailID); err != nil {
-		return fmt.Errorf("failed to sync trail to generator: %w", err)
+		return nil, fmt.Errorf("failed to sync trail to generator: %w", err)
 	}
 
 	// Get tiles for new trail position
@@ -293,18 +545,11 @@ func (s *OrchestrationService) HandleTrailUpdated(ctx context.Context, app core.
 		newTiles = nil
 	}
 
-	// Aggregate tiles and queue for background generation
-	allTiles := mergeTiles(oldTiles, newTiles)
-	s.invalidateAndQueueTiles(allTiles)
-
-	log.Printf("Successfully handled trail update: %s (queued %d tiles)", trailID, len(allTiles))
-	return nil
+	return mergeTiles(oldTiles, newTiles), nil
 }
 
-// HandleTrailDeleted handles trail deletion: get tiles, delete from generator, queue tile regeneration
-func (s *OrchestrationService) HandleTrailDeleted(ctx context.Context, trailID string) error {
-	log.Printf("Handling trail deletion: %s", trailID)
-
+// removeTrail deletes a trail from the generator and returns the tiles it covered
+func (s *OrchestrationService) removeTrail(ctx context.Context, trailID string) ([]entities.TileCoordinates, error) {
 	// Get tiles for the trail before deletion
 	tiles, err := s.mvtGenerator.GetTrailTiles(ctx, trailID)
 	if err != nil {
@@ -312,88 +557,70 @@ func (s *OrchestrationService) HandleTrailDeleted(ctx context.Context, trailID s
 		tiles = nil
 	}
 
-	// Delete from generator
 	if err := s.mvtGenerator.DeleteTrail(ctx, trailID); err != nil {
-		return fmt.Errorf("failed to delete trail from generator: %w", err)
+		return nil, fmt.Errorf("failed to delete trail from generator: %w", err)
 	}
 
-	// Invalidate and queue affected tiles for regeneration
-	s.invalidateAndQueueTiles(tiles)
-
-	log.Printf("Successfully handled trail deletion: %s (queued %d tiles)", trailID, len(tiles))
-	return nil
+	return tiles, nil
 }
 
-// HandleRatingCreated handles rating creation: update PocketBase average, sync to generator, regenerate tiles
+// HandleRatingCreated handles rating creation: update PocketBase average.
+// Engagement stats are served separately from tiles, so ratings never touch trail geometry or tiles.
 func (s *OrchestrationService) HandleRatingCreated(ctx context.Context, app core.App, trailID string) error {
 	log.Printf("Handling rating creation for trail: %s", trailID)
-
-	if s.engagementService != nil {
-		if err := s.engagementService.UpdateRatingAverage(app, trailID); err != nil {
-			log.Printf("Failed to update rating average: %v", err)
-		}
-	}
-
-	return s.updateTrailAndRegenerateTiles(ctx, app, trailID)
+	return s.updateRatingAverage(app, trailID)
 }
 
-// HandleRatingUpdated handles rating update: update PocketBase average, sync to generator, regenerate tiles
+// HandleRatingUpdated handles rating update: update PocketBase average
 func (s *OrchestrationService) HandleRatingUpdated(ctx context.Context, app core.App, trailID string) error {
 	log.Printf("Handling rating update for trail: %s", trailID)
-
-	if s.engagementService != nil {
-		if err := s.engagementService.UpdateRatingAverage(app, trailID); err != nil {
-			log.Printf("Failed to update rating average: %v", err)
-		}
-	}
-
-	return s.updateTrailAndRegenerateTiles(ctx, app, trailID)
+	return s.updateRatingAverage(app, trailID)
 }
 
-// HandleRatingDeleted handles rating deletion: update PocketBase average, sync to generator, regenerate tiles
+// HandleRatingDeleted handles rating deletion: update or remove PocketBase average
 func (s *OrchestrationService) HandleRatingDeleted(ctx context.Context, app core.App, trailID string) error {
 	log.Printf("Handling rating deletion for trail: %s", trailID)
 
-	if s.engagementService != nil {
-		if err := s.engagementService.DeleteRatingAverage(app, trailID); err != nil {
-			log.Printf("Failed to delete rating average: %v", err)
-		}
+	if s.engagementService == nil {
+		return nil
 	}
-
-	return s.updateTrailAndRegenerateTiles(ctx, app, trailID)
+	if err := s.engagementService.DeleteRatingAverage(app, trailID); err != nil {
+		return fmt.Errorf("failed to delete rating average: %w", err)
+	}
+	return nil
 }
 
-// HandleCommentCreated handles comment creation: sync to generator, regenerate tiles
+// HandleCommentCreated handles comment creation. Comment counts are read live by the engagement stats endpoint.
 func (s *OrchestrationService) HandleCommentCreated(ctx context.Context, app core.App, trailID string) error {
 	log.Printf("Handling comment creation for trail: %s", trailID)
-	return s.updateTrailAndRegenerateTiles(ctx, app, trailID)
+	return nil
 }
 
-// HandleCommentDeleted handles comment deletion: sync to generator, regenerate tiles
+// HandleCommentDeleted handles comment deletion. Comment counts are read live by the engagement stats endpoint.
 func (s *OrchestrationService) HandleCommentDeleted(ctx context.Context, app core.App, trailID string) error {
 	log.Printf("Handling comment deletion for trail: %s", trailID)
-	return s.updateTrailAndRegenerateTiles(ctx, app, trailID)
+	return nil
 }
 
-// updateTrailAndRegenerateTiles syncs trail to generator and queues tile regeneration
-func (s *OrchestrationService) updateTrailAndRegenerateTiles(ctx context.Context, app core.App, trailID string) error {
-	if err := s.syncTrailFromPBToGenerator(ctx, app, trailID); err != nil {
-		return err
-	}
-
-	// Get tiles for the trail and queue for regeneration
-	tiles, err := s.mvtGenerator.GetTrailTiles(ctx, trailID)
-	if err != nil {
-		log.Printf("Could not get tiles for trail %s: %v", trailID, err)
+// updateRatingAverage recomputes the PocketBase rating average for a trail
+func (s *OrchestrationService) updateRatingAverage(app core.App, trailID string) error {
+	if s.engagementService == nil {
 		return nil
 	}
-
-	s.invalidateAndQueueTiles(tiles)
+	if err := s.engagementService.UpdateRatingAverage(app, trailID); err != nil {
+		return fmt.Errorf("failed to update rating average: %w", err)
+	}
 	return nil
 }
 
-// invalidateAndQueueTiles invalidates tiles in cache and queues them for background generation
+// invalidateAndQueueTiles invalidates tiles on this instance and on the other cluster instances
 func (s *OrchestrationService) invalidateAndQueueTiles(tiles []entities.TileCoordinates) {
+	s.invalidateLocalTiles(tiles)
+	s.cluster.PublishInvalidation(tiles)
+}
+
+// invalidateLocalTiles invalidates tiles in cache, notifies map clients and queues the tiles for background generation
+func (s *OrchestrationService) invalidateLocalTiles(tiles []entities.TileCoordinates) {
 	if len(tiles) == 0 {
 		return
 	}
@@ -403,13 +630,100 @@ func (s *OrchestrationService) invalidateAndQueueTiles(tiles []entities.TileCoor
 		log.Printf("Failed to invalidate tiles: %v", err)
 	}
 
-	// Queue for background generation
-	for _, tile := range tiles {
-		select {
-		case s.backgroundQueue <- tile:
-		default:
-			// Queue full, skip (will be generated on demand)
-		}
+	s.events.TilesInvalidated(tiles)
+	s.queueTiles(tiles)
+}
+
+// queueTiles queues tiles for background generation (tiles already pending are merged)
+func (s *OrchestrationService) queueTiles(tiles []entities.TileCoordinates) {
+	if dropped := s.backgroundQueue.Push(tiles); dropped > 0 {
+		// Dropped tiles stay invalidated in cache and are generated on demand
+		stats := s.backgroundQueue.Stats()
+		log.Printf("Warning: Background tile queue full, dropped %d tiles (pending: %d, dropped total: %d)",
+			dropped, stats.Pending, stats.Dropped)
+	}
+}
+
+// PriorityQueueLen returns the number of priority requests waiting for a worker
+func (s *OrchestrationService) PriorityQueueLen() int {
+	return len(s.priorityQueue)
+}
+
+// BackgroundQueueStats returns the background tile queue counters
+func (s *OrchestrationService) BackgroundQueueStats() entities.TileQueueStats {
+	return s.backgroundQueue.Stats()
+}
+
+// publishTrailChange notifies map clients of this instance and of the other cluster instances
+func (s *OrchestrationService) publishTrailChange(trailID, action string, bbox *entities.BoundingBox) {
+	s.events.TrailChanged(trailID, action, bbox)
+	s.cluster.PublishTrailChange(trailID, action, bbox)
+}
+
+// ApplyRemoteInvalidation invalidates tiles invalidated by another instance. They are regenerated
+// when next requested, only the snapshot leader queues them.
+func (s *OrchestrationService) ApplyRemoteInvalidation(tiles []entities.TileCoordinates) {
+	s.invalidateRemoteTiles(tiles)
+}
+
+// ApplyRemoteTrailChange forwards a trail change handled by another instance to local map clients
+func (s *OrchestrationService) ApplyRemoteTrailChange(trailID, action string, bbox *entities.BoundingBox) {
+	s.events.TrailChanged(trailID, action, bbox)
+}
+
+// ApplyRemoteResync invalidates every cached tile, as invalidations from other instances may have been missed.
+// Tiles are regenerated when next requested, stale tiles being served meanwhile, so a reconnect does not
+// rebuild the whole cache.
+func (s *OrchestrationService) ApplyRemoteResync() {
+	// Backup tiles that are not cached may have missed the invalidations as well
+	s.cache.SetFallbackCurrent(false)
+
+	tiles := s.cache.ListTiles(s.cache.GetMinZoom(), s.cache.GetMaxZoom())
+	if len(tiles) == 0 {
+		return
+	}
+
+	s.invalidateRemoteTiles(tiles)
+}
+
+// invalidateRemoteTiles invalidates tiles on behalf of another instance. Every instance regenerates
+// them lazily on request, except the snapshot leader, which queues them as its MBTiles backup is
+// fed by background generation.
+func (s *OrchestrationService) invalidateRemoteTiles(tiles []entities.TileCoordinates) {
+	if s.backup != nil && s.cluster.IsLeader() {
+		s.invalidateLocalTiles(tiles)
+		return
+	}
+
+	if err := s.cache.InvalidateTiles(tiles); err != nil {
+		log.Printf("Failed to invalidate tiles: %v", err)
+	}
+	s.events.TilesInvalidated(tiles)
+}
+
+// trailBBox returns the bounding box of a trail in the generator, or nil if unknown
+func (s *OrchestrationService) trailBBox(ctx context.Context, trailID string) *entities.BoundingBox {
+	bbox, err := s.mvtGenerator.GetTrailBBox(ctx, trailID)
+	if err != nil {
+		log.Printf("Could not get bbox for trail %s: %v", trailID, err)
+		return nil
+	}
+	return bbox
+}
+
+// unionBBox returns the smallest bounding box containing both boxes (either may be nil)
+func unionBBox(a, b *entities.BoundingBox) *entities.BoundingBox {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &entities.BoundingBox{
+		North: max(a.North, b.North),
+		South: min(a.South, b.South),
+		East:  max(a.East, b.East),
+		West:  min(a.West, b.West),
 	}
 }
 
@@ -465,22 +779,19 @@ func (s *OrchestrationService) syncTrailFromPBToGenerator(ctx context.Context, a
 		return fmt.Errorf("failed to parse GPX: %w", err)
 	}
 
-	// 5. Get engagement data
-	ratingAvg, ratingCount, commentCount := s.getTrailEngagementDataFromPB(app, trailID)
-
-	// 6. Serialize elevation data
+	// 5. Serialize elevation data
 	elevationJSON, err := json.Marshal(parsedGPX.ElevationData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal elevation data: %w", err)
 	}
 
-	// 7. Prepare tags JSON
+	// 6. Prepare tags JSON
 	tagsJSON := trail.GetString("tags")
 	if tagsJSON == "" {
 		tagsJSON = "[]"
 	}
 
-	// 8. Create trail data and update generator
+	// 7. Create trail data and update generator
 	trailData := entities.Trail{
 		ID:            trail.Id,
 		Name:          trail.GetString("name"),
@@ -493,10 +804,8 @@ func (s *OrchestrationService) syncTrailFromPBToGenerator(ctx context.Context, a
 		ElevationJSON: string(elevationJSON),
 		CreatedAt:     trail.GetDateTime("created").Time(),
 		UpdatedAt:     trail.GetDateTime("updated").Time(),
-		RatingAvg:     ratingAvg,
-		RatingCount:   ratingCount,
-		CommentCount:  commentCount,
 		Ridden:        trail.GetBool("ridden"),
+		SyncHash:      trailSyncHash(trail),
 	}
 
 	if err := s.mvtGenerator.UpdateTrail(ctx, trailData); err != nil {
@@ -507,19 +816,172 @@ func (s *OrchestrationService) syncTrailFromPBToGenerator(ctx context.Context, a
 	return nil
 }
 
-// SyncAllTrails synchronizes all trails from PocketBase to generator and generates all tiles
-func (s *OrchestrationService) SyncAllTrails(ctx context.Context, app core.App) error {
-	log.Println("Starting full trail synchronization")
+// trailSyncVersion is part of every sync hash. Bump it when the import itself changes
+// (GPX parsing, stored columns) so the next incremental sync re-imports every trail.
+const trailSyncVersion = 1
+
+// trailSyncHash fingerprints the PocketBase state a trail is imported from.
+// PocketBase renames uploaded files, so a new GPX always changes the file name.
+func trailSyncHash(trail *core.Record) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("v%d|%s|%s",
+		trailSyncVersion,
+		trail.GetDateTime("updated").String(),
+		trail.GetString("file"),
+	)))
+	return hex.EncodeToString(sum[:])
+}
+
+// SyncAllTrails incrementally synchronizes trails from PocketBase to the generator: trails whose
+// sync hash differs are re-imported, trails missing from PocketBase are deleted, and only their
+// tiles are invalidated. Unchanged trails are not touched.
+func (s *OrchestrationService) SyncAllTrails(ctx context.Context, app core.App) (entities.TrailSyncReport, error) {
+	var report entities.TrailSyncReport
+
+	trails, err := app.FindAllRecords("trails")
+	if err != nil {
+		return report, fmt.Errorf("failed to get trails from PocketBase: %w", err)
+	}
+
+	stored, err := s.mvtGenerator.GetTrailSyncHashes(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to get trails from generator: %w", err)
+	}
+
+	report.Total = len(trails)
+
+	// Diff by trail ID and sync hash
+	var changed []string
+	for _, trail := range trails {
+		hash, exists := stored[trail.Id]
+		delete(stored, trail.Id)
+		if exists && hash == trailSyncHash(trail) {
+			report.Unchanged++
+			continue
+		}
+		changed = append(changed, trail.Id)
+	}
+
+	// Whatever is left in stored is no longer in PocketBase
+	orphans := make([]string, 0, len(stored))
+	for trailID := range stored {
+		orphans = append(orphans, trailID)
+	}
+
+	log.Printf("Incremental trail sync: %d trails, %d unchanged, %d to import, %d orphans to delete",
+		report.Total, report.Unchanged, len(changed), len(orphans))
+
+	affected := make(map[string]entities.TileCoordinates)
+	addTiles := func(tiles []entities.TileCoordinates) {
+		for _, tile := range tiles {
+			affected[tileKey(tile)] = tile
+		}
+	}
+
+	for _, trailID := range orphans {
+		tiles, err := s.removeTrail(ctx, trailID)
+		if err != nil {
+			log.Printf("Failed to delete orphan trail %s: %v", trailID, err)
+			report.Failed++
+			continue
+		}
+		addTiles(tiles)
+		report.Deleted++
+	}
+
+	for _, trailID := range changed {
+		tiles, err := s.resyncTrail(ctx, app, trailID)
+		if err != nil {
+			log.Printf("Failed to import trail %s: %v", trailID, err)
+			report.Failed++
+			continue
+		}
+		addTiles(tiles)
+		report.Updated++
+	}
+
+	tiles := make([]entities.TileCoordinates, 0, len(affected))
+	for _, tile := range affected {
+		tiles = append(tiles, tile)
+	}
+	s.invalidateAndQueueTiles(tiles)
+	report.Tiles = len(tiles)
+
+	log.Printf("Completed incremental trail sync: %d imported, %d deleted, %d failed, %d tiles queued",
+		report.Updated, report.Deleted, report.Failed, report.Tiles)
+	return report, nil
+}
+
+// WarmUpTiles queues the tiles covered by a trail that the MBTiles backup does not hold for
+// background generation. The memory cache starts empty and falls back to the backup, which is
+// loaded from the latest snapshot: its tiles only need regenerating if the snapshot is not
+// current, tiles of trails changed since are queued by SyncAllTrails. A nil backup or a
+// snapshot that is not current means every tile is queued.
+func (s *OrchestrationService) WarmUpTiles(ctx context.Context, snapshotCurrent bool) error {
+	tiles, err := s.mvtGenerator.GetAllTiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tiles: %w", err)
+	}
+
+	if s.backup != nil && snapshotCurrent {
+		stored, err := s.backup.ListTiles(s.backup.GetMinZoom(), s.backup.GetMaxZoom())
+		if err != nil {
+			return err
+		}
+		held := make(map[string]bool, len(stored))
+		for _, tile := range stored {
+			held[tileKey(tile)] = true
+		}
+
+		missing := tiles[:0]
+		for _, tile := range tiles {
+			if !held[tileKey(tile)] {
+				missing = append(missing, tile)
+			}
+		}
+		log.Printf("MBTiles snapshot is current, %d of %d tiles missing", len(missing), len(tiles))
+		tiles = missing
+	}
+
+	// Nothing is cached yet, so there is nothing to invalidate or announce to map clients
+	log.Printf("Queueing %d tiles for cache warm-up", len(tiles))
+	s.queueTiles(tiles)
+	return nil
+}
+
+// SnapshotCurrent reports whether the snapshot the MBTiles backup was loaded from was generated
+// from the trails currently in the generator. Call it before syncing trails.
+func (s *OrchestrationService) SnapshotCurrent(ctx context.Context) bool {
+	if s.backup == nil {
+		return false
+	}
+	loaded := s.backup.LoadedTrailRevision()
+	if loaded == "" {
+		return false
+	}
+
+	hashes, err := s.mvtGenerator.GetTrailSyncHashes(ctx)
+	if err != nil {
+		log.Printf("Failed to get trail revision: %v", err)
+		return false
+	}
+	return trailRevision(hashes) == loaded
+}
+
+// RebuildAllTrails clears the generator and cache and re-imports every trail from PocketBase.
+// The map is empty until trails are re-imported: use SyncAllTrails unless the generator data is suspect.
+func (s *OrchestrationService) RebuildAllTrails(ctx context.Context, app core.App) error {
+	log.Println("Starting full trail rebuild")
 
 	// Clear all existing trails from generator first
 	if err := s.mvtGenerator.ClearAllTrails(ctx); err != nil {
 		return fmt.Errorf("failed to clear existing trails: %w", err)
 	}
 
-	// Clear all tiles from cache
+	// Clear all tiles from cache, backup tiles are served stale until regenerated
 	if err := s.cache.ClearAllTiles(); err != nil {
 		log.Printf("Failed to clear cache: %v", err)
 	}
+	s.cache.SetFallbackCurrent(false)
 
 	// Get all trails from PocketBase
 	trails, err := app.FindAllRecords("trails")
@@ -595,39 +1057,10 @@ func (s *OrchestrationService) SyncAllTrails(ctx context.Context, app core.App)
 	log.Printf("Queueing %d unique tiles for background generation", len(uniqueTiles))
 	s.invalidateAndQueueTiles(uniqueTiles)
 
-	log.Println("Completed trail synchronization, tiles queued for background generation")
+	log.Println("Completed full trail rebuild, tiles queued for background generation")
 	return nil
 }
 
-// getTrailEngagementDataFromPB retrieves rating and comment engagement data for a trail from PocketBase
-func (s *OrchestrationService) getTrailEngagementDataFromPB(app core.App, trailId string) (float64, int, int) {
-	var ratingAvg float64 = 0.0
-	var ratingCount int = 0
-	var commentCount int = 0
-
-	// Get rating average and count from rating_average collection
-	ratingAverageCollection, err := app.FindCollectionByNameOrId("rating_average")
-	if err == nil {
-		averageRecords, err := app.FindRecordsByFilter(ratingAverageCollection, fmt.Sprintf("trail = '%s'", trailId), "", 1, 0)
-		if err == nil && len(averageRecords) > 0 {
-			record := averageRecords[0]
-			ratingAvg = record.GetFloat("average")
-			ratingCount = int(record.GetFloat("count"))
-		}
-	}
-
-	// Get comment count from trail_comments collection
-	commentsCollection, err := app.FindCollectionByNameOrId("trail_comments")
-	if err == nil {
-		commentRecords, err := app.FindRecordsByFilter(commentsCollection, fmt.Sprintf("trail = '%s'", trailId), "", 0, 0)
-		if err == nil {
-			commentCount = len(commentRecords)
-		}
-	}
-
-	return ratingAvg, ratingCount, commentCount
-}
-
 // readGPXFromPocketBase reads a GPX file directly from PocketBase storage filesystem
 func readGPXFromPocketBase(app core.App, trail *core.Record, filename string) ([]byte, error) {
 	fileKey := trail.BaseFilesPath() + "/" + filename
@@ -650,3 +1083,134 @@ func readGPXFromPocketBase(app core.App, trail *core.Record, filename string) ([
 // Compile-time check to ensure OrchestrationService implements interfaces.SyncService
 var _ interfaces.SyncTrails = (*OrchestrationService)(nil)
 var _ interfaces.TileRequester = (*OrchestrationService)(nil)
+
+// Admin operations on the tile pipeline
+
+// GetTrailTileStates returns the tiles covered by a trail with their cache status
+func (s *OrchestrationService) GetTrailTileStates(ctx context.Context, trailID string) ([]interfaces.TileState, error) {
+	tiles, err := s.mvtGenerator.GetTrailTiles(ctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]interfaces.TileState, 0, len(tiles))
+	for _, tile := range tiles {
+		data, status, _ := s.cache.GetTileWithStatus(tile)
+		states = append(states, interfaces.TileState{
+			Coords: tile,
+			Status: status,
+			Size:   len(data),
+		})
+	}
+
+	return states, nil
+}
+
+// InvalidateTileRange invalidates and queues the cached tiles within a zoom range, optionally
+// restricted to a bounding box, along with the tiles served from the MBTiles backup that are not
+// cached yet (invalidated as tombstones). It returns the number of tiles queued.
+func (s *OrchestrationService) InvalidateTileRange(bbox *entities.BoundingBox, minZoom, maxZoom int) int {
+	minZoom = max(minZoom, s.cache.GetMinZoom())
+	maxZoom = min(maxZoom, s.cache.GetMaxZoom())
+
+	tiles := s.cache.ListTiles(minZoom, maxZoom)
+	if s.backup != nil {
+		stored, err := s.backup.ListTiles(minZoom, maxZoom)
+		if err != nil {
+			log.Printf("Failed to list backup tiles: %v", err)
+		}
+		tiles = mergeTiles(tiles, stored)
+	}
+
+	if bbox != nil {
+		ranges := make(map[int]utils.TileRange)
+		for z := minZoom; z <= maxZoom; z++ {
+			ranges[z] = utils.TileRangeForBBox(*bbox, z)
+		}
+
+		filtered := tiles[:0]
+		for _, tile := range tiles {
+			if ranges[tile.Z].Contains(tile) {
+				filtered = append(filtered, tile)
+			}
+		}
+		tiles = filtered
+	}
+
+	s.invalidateAndQueueTiles(tiles)
+	log.Printf("Admin invalidated %d tiles (zoom %d-%d)", len(tiles), minZoom, maxZoom)
+	return len(tiles)
+}
+
+// RebuildTrail re-syncs a trail from PocketBase and regenerates its old and new tiles
+func (s *OrchestrationService) RebuildTrail(ctx context.Context, app core.App, trailID string) error {
+	return s.HandleTrailUpdated(ctx, app, trailID)
+}
+
+// TriggerSnapshot writes an MBTiles snapshot now if tiles changed since the last one
+func (s *OrchestrationService) TriggerSnapshot() error {
+	if s.backup == nil {
+		return errors.New("tile backup not available")
+	}
+	if !s.cluster.IsLeader() {
+		return errors.New("snapshots are written by the cluster leader")
+	}
+	s.refreshBackupSource()
+	return s.backup.Snapshot()
+}
+
+// refreshBackupSource writes the current trails extent and revision to the backup, so
+// snapshots advertise bounds matching their content. Failures keep the previous values.
+func (s *OrchestrationService) refreshBackupSource() {
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	bounds, err := s.mvtGenerator.GetTrailsExtent(ctx)
+	if err != nil {
+		log.Printf("Failed to refresh MBTiles bounds: %v", err)
+		return
+	}
+	hashes, err := s.mvtGenerator.GetTrailSyncHashes(ctx)
+	if err != nil {
+		log.Printf("Failed to refresh MBTiles trail revision: %v", err)
+		return
+	}
+
+	source := entities.SnapshotSource{
+		Bounds:        bounds,
+		TrailCount:    len(hashes),
+		TrailRevision: trailRevision(hashes),
+	}
+	if err := s.backup.SetSource(source); err != nil {
+		log.Printf("Failed to refresh MBTiles source: %v", err)
+	}
+}
+
+// trailRevision fingerprints the trails by their sync hashes: it changes whenever a trail is
+// created, updated or deleted
+func trailRevision(hashes map[string]string) string {
+	ids := make([]string, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%s:%s\n", id, hashes[id])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// QueueStatus returns queue depths, counters and the next background tiles to be generated
+func (s *OrchestrationService) QueueStatus(limit int) entities.TileQueueStatus {
+	return entities.TileQueueStatus{
+		PriorityPending: s.PriorityQueueLen(),
+		Background:      s.backgroundQueue.Stats(),
+		Requests:        s.TileRequestStats(),
+		NextTiles:       s.backgroundQueue.Peek(limit),
+	}
+}
+
+var _ interfaces.TilePipelineAdmin = (*OrchestrationService)(nil)
+var _ interfaces.ClusterEventHandler = (*OrchestrationService)(nil)
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+)
+
+// Zoom levels telling priority and background tiles apart in the stub generator
+const (
+	benchPriorityZoom   = 18
+	benchBackgroundZoom = 12
+)
+
+// stubGenerator generates tiles by sleeping, counting the generations by zoom level.
+// Only GetTile is implemented, the other methods are not used by the tile workers.
+type stubGenerator struct {
+	interfaces.MVTGenerator
+	delay time.Duration
+	gate  chan struct{} // When set, generations wait for it to be closed
+
+	priority   atomic.Int64
+	background atomic.Int64
+}
+
+func (g *stubGenerator) GetTile(ctx context.Context, c entities.TileCoordinates) ([]byte, error) {
+	if g.gate != nil {
+		select {
+		case <-g.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case <-time.After(g.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if c.Z == benchPriorityZoom {
+		g.priority.Add(1)
+	} else {
+		g.background.Add(1)
+	}
+	return []byte{0x1a, 0x00}, nil
+}
+
+// stubEvents drops tile events
+type stubEvents struct{}
+
+func (stubEvents) TrailChanged(string, string, *entities.BoundingBox) {}
+func (stubEvents) TilesInvalidated([]entities.TileCoordinates)        {}
+func (stubEvents) TilesReady([]entities.TileCoordinates)              {}
+
+// newBenchOrchestrator starts an orchestrator with workers tile workers and no backup or cluster
+func newBenchOrchestrator(b *testing.B, generator *stubGenerator, workers int) *OrchestrationService {
+	b.Helper()
+
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(output) })
+
+	o := NewOrchestrationService(generator, nil, NewMVTService(false), nil, stubEvents{}, nil,
+		TileWorkerConfig{workers: workers, requestTimeout: 10 * time.Second},
+		SnapshotConfig{})
+	b.Cleanup(o.Stop)
+	return o
+}
+
+// flightWaiters returns the number of waiters on the shared generation of a tile
+func (o *OrchestrationService) flightWaiters(c entities.TileCoordinates) int {
+	o.flightsMu.Lock()
+	defer o.flightsMu.Unlock()
+	if flight, ok := o.flights[tileKey(c)]; ok {
+		return flight.waiters
+	}
+	return 0
+}
+
+// BenchmarkRequestTileWaiters measures n concurrent requests for the same tile, which must share a
+// single generation
+func BenchmarkRequestTileWaiters(b *testing.B) {
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("waiters=%d", n), func(b *testing.B) {
+			generator := &stubGenerator{delay: time.Millisecond}
+			o := newBenchOrchestrator(b, generator, 4)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				coords := entities.TileCoordinates{Z: benchPriorityZoom, X: i, Y: 0}
+
+				// Hold the generation until every waiter joined it
+				generator.gate = make(chan struct{})
+
+				var wg sync.WaitGroup
+				var failed atomic.Int64
+				for w := 0; w < n; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if _, err := o.RequestTile(context.Background(), coords); err != nil {
+							failed.Add(1)
+						}
+					}()
+				}
+				for o.flightWaiters(coords) < n {
+					time.Sleep(10 * time.Microsecond)
+				}
+				close(generator.gate)
+				wg.Wait()
+
+				if failed.Load() > 0 {
+					b.Fatalf("%d of %d requests for tile %d failed", failed.Load(), n, i)
+				}
+			}
+			b.StopTimer()
+
+			generations := generator.priority.Load()
+			b.ReportMetric(float64(generations)/float64(b.N), "generations/op")
+			if generations != int64(b.N) {
+				b.Fatalf("%d generations for %d tiles, want one per tile", generations, b.N)
+			}
+		})
+	}
+}
+
+// BenchmarkRequestTileUnderBackgroundLoad measures priority requests while the background queue
+// holds a large backlog. Priority requests must be served ahead of the backlog: at most the tiles
+// already being generated by the workers run before them.
+func BenchmarkRequestTileUnderBackgroundLoad(b *testing.B) {
+	const backlog = 2000
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			generator := &stubGenerator{delay: 200 * time.Microsecond}
+			o := newBenchOrchestrator(b, generator, workers)
+
+			var seq int
+			refill := func() {
+				tiles := make([]entities.TileCoordinates, backlog)
+				for i := range tiles {
+					tiles[i] = entities.TileCoordinates{Z: benchBackgroundZoom, X: seq, Y: 0}
+					seq++
+				}
+				o.backgroundQueue.Push(tiles)
+			}
+
+			var overtaken int64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if o.backgroundQueue.Len() < backlog/2 {
+					b.StopTimer()
+					refill()
+					b.StartTimer()
+				}
+
+				before := generator.background.Load()
+				pending := o.backgroundQueue.Len()
+
+				coords := entities.TileCoordinates{Z: benchPriorityZoom, X: i, Y: 0}
+				if _, err := o.RequestTile(context.Background(), coords); err != nil {
+					b.Fatalf("priority request %d failed: %v", i, err)
+				}
+
+				generated := generator.background.Load() - before
+				overtaken += generated
+				if generated >= int64(pending) {
+					b.Fatalf("priority request %d waited for the whole background backlog (%d tiles)", i, pending)
+				}
+			}
+			b.StopTimer()
+
+			// Background tiles completed while each priority request was waiting
+			b.ReportMetric(float64(overtaken)/float64(b.N), "background/op")
+			if limit := int64(4 * workers * b.N); overtaken > limit {
+				b.Fatalf("%d background tiles generated during %d priority requests, want at most %d",
+					overtaken, b.N, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PMTiles v3 constants (https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md)
+const (
+	pmtilesHeaderSize       = 127
+	pmtilesMaxRootSize      = 16384 // Header and root directory must fit in the first 16 KiB
+	pmtilesCompressionNone  = 1
+	pmtilesCompressionGzip  = 2
+	pmtilesTileTypeMVT      = 1
+	pmtilesInitialLeafSize  = 4096
+	pmtilesLeafSizeIncrease = 1.2
+)
+
+// pmtilesEntry is a directory entry. A zero run length points to a leaf directory.
+type pmtilesEntry struct {
+	tileID    uint64
+	offset    uint64
+	length    uint32
+	runLength uint32
+}
+
+// pmtilesSections holds the section lengths and tile counts written to the header
+type pmtilesSections struct {
+	rootLength     uint64
+	metadataLength uint64
+	leavesLength   uint64
+	dataLength     uint64
+	addressedTiles uint64
+	tileEntries    uint64
+	tileContents   uint64
+}
+
+// pmtilesTile locates a tile of the source MBTiles, ordered by tile ID
+type pmtilesTile struct {
+	tileID uint64
+	z, x   int
+	tmsY   int
+}
+
+// zxyToTileID maps tile coordinates to their PMTiles ID: tiles of lower zooms come first,
+// then tiles of a zoom level follow the Hilbert curve
+func zxyToTileID(z uint8, x, y uint32) uint64 {
+	var acc uint64
+	for tz := uint8(0); tz < z; tz++ {
+		acc += uint64(1) << (2 * tz)
+	}
+
+	n := uint64(1) << z
+	tx, ty := uint64(x), uint64(y)
+	var d uint64
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+		if tx&s > 0 {
+			rx = 1
+		}
+		if ty&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				tx = s - 1 - tx
+				ty = s - 1 - ty
+			}
+			tx, ty = ty, tx
+		}
+	}
+	return acc + d
+}
+
+// writePMTiles converts an MBTiles file into a clustered PMTiles v3 archive at dstPath.
+// Identical tiles (e.g. the same trail segment crossing many tiles at high zoom) are stored once.
+// The archive is written next to dstPath and renamed into place once complete.
+func writePMTiles(srcPath, dstPath string) (int, error) {
+	src, err := sql.Open("sqlite", srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open MBTiles: %w", err)
+	}
+	defer src.Close()
+
+	metadata, err := readMBTilesMetadata(src)
+	if err != nil {
+		return 0, err
+	}
+
+	tiles, err := listPMTilesTiles(src)
+	if err != nil {
+		return 0, err
+	}
+
+	// Tile data goes to a temporary file first: directories precede it in the archive
+	// but are only known once every tile offset is
+	data, err := os.CreateTemp(filepath.Dir(dstPath), ".pmtiles-data-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary tile data file: %w", err)
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+
+	entries, dataLength, contents, err := writePMTilesData(src, tiles, data)
+	if err != nil {
+		return 0, err
+	}
+
+	root, leaves, err := buildPMTilesDirectories(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	metadataJSON, err := pmtilesMetadataJSON(metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	sections := pmtilesSections{
+		rootLength:     uint64(len(root)),
+		metadataLength: uint64(len(metadataJSON)),
+		leavesLength:   uint64(len(leaves)),
+		dataLength:     dataLength,
+		tileEntries:    uint64(len(entries)),
+		tileContents:   contents,
+	}
+	for _, e := range entries {
+		sections.addressedTiles += uint64(e.runLength)
+	}
+
+	header, err := pmtilesHeader(metadata, sections)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpPath := dstPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create PMTiles file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(out)
+	for _, part := range [][]byte{header, root, metadataJSON, leaves} {
+		if _, err := w.Write(part); err != nil {
+			out.Close()
+			return 0, fmt.Errorf("failed to write PMTiles file: %w", err)
+		}
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("failed to rewind tile data: %w", err)
+	}
+	if _, err := io.Copy(w, data); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("failed to write PMTiles tile data: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("failed to write PMTiles file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close PMTiles file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		return 0, fmt.Errorf("failed to move PMTiles file into place: %w", err)
+	}
+	return int(sections.addressedTiles), nil
+}
+
+// readMBTilesMetadata reads the metadata table of an MBTiles file
+func readMBTilesMetadata(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(`SELECT name, value FROM metadata`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MBTiles metadata: %w", err)
+	}
+	defer rows.Close()
+
+	metadata := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan MBTiles metadata: %w", err)
+		}
+		metadata[name] = value
+	}
+	return metadata, rows.Err()
+}
+
+// listPMTilesTiles lists the non-empty tiles of an MBTiles file, sorted by tile ID
+func listPMTilesTiles(db *sql.DB) ([]pmtilesTile, error) {
+	rows, err := db.Query(`SELECT zoom_level, tile_column, tile_row FROM tiles WHERE length(tile_data) > 0`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list MBTiles tiles: %w", err)
+	}
+	defer rows.Close()
+
+	var tiles []pmtilesTile
+	for rows.Next() {
+		var t pmtilesTile
+		if err := rows.Scan(&t.z, &t.x, &t.tmsY); err != nil {
+			return nil, fmt.Errorf("failed to scan MBTiles tile: %w", err)
+		}
+		// The TMS flip is its own inverse: it also turns TMS rows back into XYZ rows
+		t.tileID = zxyToTileID(uint8(t.z), uint32(t.x), uint32(xyzToTMS(t.z, t.tmsY)))
+		tiles = append(tiles, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].tileID < tiles[j].tileID
+	})
+	return tiles, nil
+}
+
+// writePMTilesData writes tile data in tile ID order and returns the directory entries,
+// the data length and the number of distinct tile contents
+func writePMTilesData(db *sql.DB, tiles []pmtilesTile, out io.Writer) ([]pmtilesEntry, uint64, uint64, error) {
+	stmt, err := db.Prepare(`SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?`)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to prepare tile query: %w", err)
+	}
+	defer stmt.Close()
+
+	w := bufio.NewWriter(out)
+	seen := make(map[[sha256.Size]byte]pmtilesEntry)
+	var entries []pmtilesEntry
+	var offset uint64
+
+	for _, t := range tiles {
+		var tile []byte
+		if err := stmt.QueryRow(t.z, t.x, t.tmsY).Scan(&tile); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to read tile %d/%d/%d: %w", t.z, t.x, t.tmsY, err)
+		}
+
+		hash := sha256.Sum256(tile)
+		if existing, ok := seen[hash]; ok {
+			// Extend the previous run when the same content continues along the curve
+			last := &entries[len(entries)-1]
+			if last.offset == existing.offset && last.tileID+uint64(last.runLength) == t.tileID {
+				last.runLength++
+				continue
+			}
+			entries = append(entries, pmtilesEntry{tileID: t.tileID, offset: existing.offset, length: existing.length, runLength: 1})
+			continue
+		}
+
+		if _, err := w.Write(tile); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to write tile data: %w", err)
+		}
+		entry := pmtilesEntry{tileID: t.tileID, offset: offset, length: uint32(len(tile)), runLength: 1}
+		seen[hash] = entry
+		entries = append(entries, entry)
+		offset += uint64(len(tile))
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to write tile data: %w", err)
+	}
+	return entries, offset, uint64(len(seen)), nil
+}
+
+// serializePMTilesDirectory encodes and gzips directory entries
+func serializePMTilesDirectory(entries []pmtilesEntry) ([]byte, error) {
+	var raw []byte
+	raw = binary.AppendUvarint(raw, uint64(len(entries)))
+
+	var lastID uint64
+	for _, e := range entries {
+		raw = binary.AppendUvarint(raw, e.tileID-lastID)
+		lastID = e.tileID
+	}
+	for _, e := range entries {
+		raw = binary.AppendUvarint(raw, uint64(e.runLength))
+	}
+	for _, e := range entries {
+		raw = binary.AppendUvarint(raw, uint64(e.length))
+	}
+	for i, e := range entries {
+		// Zero means "right after the previous entry"
+		if i > 0 && e.offset == entries[i-1].offset+uint64(entries[i-1].length) {
+			raw = binary.AppendUvarint(raw, 0)
+		} else {
+			raw = binary.AppendUvarint(raw, e.offset+1)
+		}
+	}
+
+	return gzipBytes(raw)
+}
+
+// buildPMTilesDirectories returns the root directory and the leaf directories. Entries are
+// split into leaves, growing them until the root directory fits in the first 16 KiB.
+func buildPMTilesDirectories(entries []pmtilesEntry) ([]byte, []byte, error) {
+	root, err := serializePMTilesDirectory(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(root) <= pmtilesMaxRootSize-pmtilesHeaderSize {
+		return root, nil, nil
+	}
+
+	leafSize := float64(pmtilesInitialLeafSize)
+	for {
+		var rootEntries []pmtilesEntry
+		var leaves bytes.Buffer
+
+		for start := 0; start < len(entries); start += int(leafSize) {
+			chunk := entries[start:min(start+int(leafSize), len(entries))]
+			leaf, err := serializePMTilesDirectory(chunk)
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, pmtilesEntry{
+				tileID: chunk[0].tileID,
+				offset: uint64(leaves.Len()),
+				length: uint32(len(leaf)),
+			})
+			leaves.Write(leaf)
+		}
+
+		root, err := serializePMTilesDirectory(rootEntries)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(root) <= pmtilesMaxRootSize-pmtilesHeaderSize {
+			return root, leaves.Bytes(), nil
+		}
+		leafSize *= pmtilesLeafSizeIncrease
+	}
+}
+
+// pmtilesMetadataJSON converts MBTiles metadata into the gzipped PMTiles JSON metadata.
+// Zooms, bounds and center live in the header.
+func pmtilesMetadataJSON(metadata map[string]string) ([]byte, error) {
+	doc := make(map[string]any)
+	for name, value := range metadata {
+		switch name {
+		case "json":
+			var layers map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(value), &layers); err != nil {
+				return nil, fmt.Errorf("failed to parse MBTiles json metadata: %w", err)
+			}
+			for k, v := range layers {
+				doc[k] = v
+			}
+		case "minzoom", "maxzoom", "bounds", "center", "format":
+		default:
+			doc[name] = value
+		}
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PMTiles metadata: %w", err)
+	}
+	return gzipBytes(raw)
+}
+
+// pmtilesHeader builds the fixed-size header
+func pmtilesHeader(metadata map[string]string, sections pmtilesSections) ([]byte, error) {
+	minZoom, err := strconv.Atoi(metadata["minzoom"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid minzoom metadata: %w", err)
+	}
+	maxZoom, err := strconv.Atoi(metadata["maxzoom"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxzoom metadata: %w", err)
+	}
+	bounds, err := parseFloats(metadata["bounds"], 4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bounds metadata: %w", err)
+	}
+	center, err := parseFloats(metadata["center"], 3)
+	if err != nil {
+		return nil, fmt.Errorf("invalid center metadata: %w", err)
+	}
+
+	h := make([]byte, pmtilesHeaderSize)
+	copy(h[0:7], "PMTiles")
+	h[7] = 3
+
+	rootOffset := uint64(pmtilesHeaderSize)
+	metadataOffset := rootOffset + sections.rootLength
+	leavesOffset := metadataOffset + sections.metadataLength
+	dataOffset := leavesOffset + sections.leavesLength
+
+	le := binary.LittleEndian
+	le.PutUint64(h[8:], rootOffset)
+	le.PutUint64(h[16:], sections.rootLength)
+	le.PutUint64(h[24:], metadataOffset)
+	le.PutUint64(h[32:], sections.metadataLength)
+	le.PutUint64(h[40:], leavesOffset)
+	le.PutUint64(h[48:], sections.leavesLength)
+	le.PutUint64(h[56:], dataOffset)
+	le.PutUint64(h[64:], sections.dataLength)
+	le.PutUint64(h[72:], sections.addressedTiles)
+	le.PutUint64(h[80:], sections.tileEntries)
+	le.PutUint64(h[88:], sections.tileContents)
+
+	h[96] = 1 // Clustered: tile data is ordered by tile ID
+	h[97] = pmtilesCompressionGzip
+	h[98] = pmtilesCompressionNone // ST_AsMVT output is stored uncompressed
+	h[99] = pmtilesTileTypeMVT
+	h[100] = uint8(minZoom)
+	h[101] = uint8(maxZoom)
+	le.PutUint32(h[102:], uint32(e7(bounds[0])))
+	le.PutUint32(h[106:], uint32(e7(bounds[1])))
+	le.PutUint32(h[110:], uint32(e7(bounds[2])))
+	le.PutUint32(h[114:], uint32(e7(bounds[3])))
+	h[118] = uint8(center[2])
+	le.PutUint32(h[119:], uint32(e7(center[0])))
+	le.PutUint32(h[123:], uint32(e7(center[1])))
+
+	return h, nil
+}
+
+// e7 encodes a coordinate as a fixed-point integer with 7 decimals
+func e7(v float64) int32 {
+	return int32(math.Round(v * 1e7))
+}
+
+// parseFloats parses a comma-separated list of n numbers
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d values, got %q", n, s)
+	}
+
+	values := make([]float64, n)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bike-map/config"
+	"bike-map/entities"
+)
+
+// postgisMigrationLockKey serializes migrations of instances starting at the same time
+const postgisMigrationLockKey = 7317208
+
+//go:embed postgis_migrations/*.sql
+var postgisMigrationFiles embed.FS
+
+// postgisMigration is an embedded forward-only migration, named NNNN_description.sql
+type postgisMigration struct {
+	version  int
+	name     string
+	sql      string
+	checksum string
+}
+
+// appliedMigration is a row of the schema_version table
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// PostGISMigrator applies the embedded schema migrations and tracks them in schema_version.
+// Migrations run in order, each in its own transaction, and are never rolled back:
+// changes to functions, triggers or tables are shipped as a new migration.
+type PostGISMigrator struct {
+	db         *sql.DB
+	migrations []postgisMigration
+}
+
+// NewPostGISMigrator creates a migrator for the embedded migrations
+func NewPostGISMigrator(db *sql.DB) (*PostGISMigrator, error) {
+	migrations, err := loadPostGISMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &PostGISMigrator{db: db, migrations: migrations}, nil
+}
+
+// OpenPostGIS opens and checks a PostGIS connection, for tools running outside the server
+func OpenPostGIS(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", postgresConnString(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostGIS: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping PostGIS: %w", err)
+	}
+	return db, nil
+}
+
+// loadPostGISMigrations reads the embedded migrations, sorted by version
+func loadPostGISMigrations() ([]postgisMigration, error) {
+	entries, err := fs.ReadDir(postgisMigrationFiles, "postgis_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	migrations := make([]postgisMigration, 0, len(entries))
+	seen := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q: expected NNNN_description.sql", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, name)
+		}
+		seen[version] = name
+
+		content, err := postgisMigrationFiles.ReadFile(path.Join("postgis_migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		sum := sha256.Sum256(content)
+
+		migrations = append(migrations, postgisMigration{
+			version:  version,
+			name:     name,
+			sql:      string(content),
+			checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// Migrate applies pending migrations and returns how many were applied
+func (m *PostGISMigrator) Migrate(ctx context.Context) (int, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgisMigrationLockKey); err != nil {
+		return 0, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, postgisMigrationLockKey); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	applied, err := queryAppliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	m.warnDrift(applied)
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.version]; ok {
+			continue
+		}
+		if err := applyPostGISMigration(ctx, conn, migration); err != nil {
+			return count, err
+		}
+		log.Printf("Applied PostGIS migration %s", migration.name)
+		count++
+	}
+
+	return count, nil
+}
+
+// Status lists embedded and applied migrations, ordered by version
+func (m *PostGISMigrator) Status(ctx context.Context) ([]entities.SchemaMigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check schema_version table: %w", err)
+	}
+
+	applied := map[int]appliedMigration{}
+	if exists {
+		if applied, err = queryAppliedMigrations(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]entities.SchemaMigrationStatus, 0, len(m.migrations))
+	known := make(map[int]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.version] = true
+		status := entities.SchemaMigrationStatus{Version: migration.version, Name: migration.name}
+		if row, ok := applied[migration.version]; ok {
+			appliedAt := row.appliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.ChecksumMismatch = row.checksum != migration.checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, row := range applied {
+		if known[version] {
+			continue
+		}
+		appliedAt := row.appliedAt
+		statuses = append(statuses, entities.SchemaMigrationStatus{
+			Version:   version,
+			Name:      row.name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Unknown:   true,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// warnDrift logs applied migrations that were edited afterwards or are unknown to this build.
+// Neither blocks startup: the schema is still usable, but needs a look.
+func (m *PostGISMigrator) warnDrift(applied map[int]appliedMigration) {
+	known := make(map[int]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.version] = true
+		if row, ok := applied[migration.version]; ok && row.checksum != migration.checksum {
+			log.Printf("Warning: PostGIS migration %s was modified after being applied", migration.name)
+		}
+	}
+	for version, row := range applied {
+		if !known[version] {
+			log.Printf("Warning: PostGIS migration %d (%s) is applied but unknown to this build", version, row.name)
+		}
+	}
+}
+
+// queryAppliedMigrations reads the schema_version table
+func queryAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var row appliedMigration
+		if err := rows.Scan(&version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_version row: %w", err)
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+// applyPostGISMigration runs a migration and records it in a single transaction
+func applyPostGISMigration(ctx context.Context, conn *sql.Conn, migration postgisMigration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %s: %w", migration.name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.sql); err != nil {
+		return fmt.Errorf("failed to apply migration %s: %w", migration.name, err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_version (version, name, checksum) VALUES ($1, $2, $3)`,
+		migration.version, migration.name, migration.checksum,
+	); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", migration.name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %w", migration.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+-- Hashes used by incremental sync and the consistency reconciler
+
+ALTER TABLE trails
+    ADD COLUMN IF NOT EXISTS sync_hash TEXT, -- Fingerprint of the PocketBase record, used by incremental sync
+    ADD COLUMN IF NOT EXISTS geom_hash TEXT; -- md5 of the geometry as imported, used by the reconciler to detect edits
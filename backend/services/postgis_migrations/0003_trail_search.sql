@@ -0,0 +1,76 @@
+-- Full-text trail search over name, tags and description.
+-- Swiss trails are described in German, French, Italian or English: every text is indexed with
+-- each of their stemmers, plus the simple configuration for unstemmed and prefix matches.
+
+ALTER TABLE trails
+    ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+-- ============================================================================
+-- FUNCTION: Build the search vector of a trail (name A, tags B, description C)
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION trail_search_vector(p_name TEXT, p_description TEXT, p_tags JSONB)
+RETURNS TSVECTOR AS $$
+DECLARE
+    v_tags TEXT := '';
+    v_config REGCONFIG;
+    v_vector TSVECTOR := ''::TSVECTOR;
+BEGIN
+    IF p_tags IS NOT NULL AND jsonb_typeof(p_tags) = 'array' THEN
+        v_tags := array_to_string(ARRAY(SELECT jsonb_array_elements_text(p_tags)), ' ');
+    END IF;
+
+    FOREACH v_config IN ARRAY ARRAY['simple', 'german', 'french', 'italian', 'english']::REGCONFIG[] LOOP
+        v_vector := v_vector
+            || setweight(to_tsvector(v_config, COALESCE(p_name, '')), 'A')
+            || setweight(to_tsvector(v_config, v_tags), 'B')
+            || setweight(to_tsvector(v_config, COALESCE(p_description, '')), 'C');
+    END LOOP;
+
+    RETURN v_vector;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- ============================================================================
+-- FUNCTION: Build the query of a single search term in every language.
+-- p_term is a single word, optionally followed by :* for a prefix match.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION trail_search_term(p_term TEXT)
+RETURNS TSQUERY AS $$
+    SELECT to_tsquery('simple', p_term)
+        || to_tsquery('german', p_term)
+        || to_tsquery('french', p_term)
+        || to_tsquery('italian', p_term)
+        || to_tsquery('english', p_term)
+$$ LANGUAGE sql IMMUTABLE STRICT;
+
+-- ============================================================================
+-- TRIGGER: Keep the search vector up to date
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION trigger_trail_search_vector()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector := trail_search_vector(NEW.name, NEW.description, NEW.tags);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_trail_search_vector ON trails;
+
+CREATE TRIGGER trigger_trail_search_vector
+    BEFORE INSERT OR UPDATE OF name, description, tags ON trails
+    FOR EACH ROW
+    EXECUTE FUNCTION trigger_trail_search_vector();
+
+-- Backfill existing trails without touching updated_at or recomputing their tiles
+ALTER TABLE trails DISABLE TRIGGER trigger_trail_before_change;
+ALTER TABLE trails DISABLE TRIGGER trigger_trail_after_change;
+
+UPDATE trails SET search_vector = trail_search_vector(name, description, tags);
+
+ALTER TABLE trails ENABLE TRIGGER trigger_trail_before_change;
+ALTER TABLE trails ENABLE TRIGGER trigger_trail_after_change;
+
+CREATE INDEX IF NOT EXISTS idx_trails_search_vector ON trails USING GIN (search_vector);
@@ -0,0 +1,6 @@
+-- Spatial indexes for trail queries by area (bbox filters, extracts) and by distance to a point
+
+CREATE INDEX IF NOT EXISTS idx_trails_bbox ON trails USING GIST (bbox);
+
+-- Distances are geodesic: ST_DWithin on geography only uses an index on the same expression
+CREATE INDEX IF NOT EXISTS idx_trails_geog ON trails USING GIST ((geom::geography));
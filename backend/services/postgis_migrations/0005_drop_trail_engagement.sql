@@ -0,0 +1,147 @@
+-- Engagement (ratings and comments) is served by /api/engagement/stats from PocketBase, the
+-- source of truth. The copies stored on trails went stale, so tiles stop carrying them.
+
+-- ============================================================================
+-- FUNCTION: Generate MVT tile for specific coordinates
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION generate_mvt_tile(p_z INTEGER, p_x INTEGER, p_y INTEGER)
+RETURNS BYTEA AS $$
+DECLARE
+    v_tile_env GEOMETRY;
+    v_tolerance FLOAT;
+    v_mvt BYTEA;
+BEGIN
+    -- Get tile envelope in Web Mercator
+    v_tile_env := ST_TileEnvelope(p_z, p_x, p_y);
+    -- Get simplification tolerance
+    v_tolerance := get_simplification_tolerance(p_z);
+
+    IF v_tolerance > 0 THEN
+        SELECT ST_AsMVT(mvt_geom.*, 'trails')
+        INTO v_mvt
+        FROM (
+            SELECT
+                t.id,
+                t.name,
+                t.description,
+                t.level,
+                CASE
+                    WHEN t.tags IS NOT NULL THEN array_to_string(ARRAY(SELECT jsonb_array_elements_text(t.tags)), ',')
+                    ELSE NULL
+                END as tags,
+                t.owner_id,
+                t.created_at,
+                t.updated_at,
+                t.gpx_file,
+                ST_XMin(t.bbox) as bbox_west,
+                ST_YMin(t.bbox) as bbox_south,
+                ST_XMax(t.bbox) as bbox_east,
+                ST_YMax(t.bbox) as bbox_north,
+                ST_X(ST_StartPoint(t.geom)) as start_lng,
+                ST_Y(ST_StartPoint(t.geom)) as start_lat,
+                ST_X(ST_EndPoint(t.geom)) as end_lng,
+                ST_Y(ST_EndPoint(t.geom)) as end_lat,
+                t.distance_m,
+                COALESCE((t.elevation_data->>'gain')::REAL, 0) as elevation_gain_meters,
+                COALESCE((t.elevation_data->>'loss')::REAL, 0) as elevation_loss_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (SELECT MIN((value->>'elevation')::REAL) FROM jsonb_array_elements(t.elevation_data->'profile') AS value)
+                    ELSE NULL
+                END as min_elevation_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (SELECT MAX((value->>'elevation')::REAL) FROM jsonb_array_elements(t.elevation_data->'profile') AS value)
+                    ELSE NULL
+                END as max_elevation_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (t.elevation_data->'profile'->0->>'elevation')::REAL
+                    ELSE NULL
+                END as elevation_start_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (t.elevation_data->'profile'->-1->>'elevation')::REAL
+                    ELSE NULL
+                END as elevation_end_meters,
+                t.ridden,
+                ST_AsMVTGeom(
+                    ST_Transform(ST_Simplify(t.geom, v_tolerance), 3857),
+                    v_tile_env,
+                    4096, 0, true
+                ) AS geom
+            FROM trails t
+            JOIN trail_tiles tt ON t.id = tt.trail_id
+            WHERE tt.z = p_z AND tt.x = p_x AND tt.y = p_y
+        ) AS mvt_geom
+        WHERE geom IS NOT NULL;
+    ELSE
+        SELECT ST_AsMVT(mvt_geom.*, 'trails')
+        INTO v_mvt
+        FROM (
+            SELECT
+                t.id,
+                t.name,
+                t.description,
+                t.level,
+                CASE
+                    WHEN t.tags IS NOT NULL THEN array_to_string(ARRAY(SELECT jsonb_array_elements_text(t.tags)), ',')
+                    ELSE NULL
+                END as tags,
+                t.owner_id,
+                t.created_at,
+                t.updated_at,
+                t.gpx_file,
+                ST_XMin(t.bbox) as bbox_west,
+                ST_YMin(t.bbox) as bbox_south,
+                ST_XMax(t.bbox) as bbox_east,
+                ST_YMax(t.bbox) as bbox_north,
+                ST_X(ST_StartPoint(t.geom)) as start_lng,
+                ST_Y(ST_StartPoint(t.geom)) as start_lat,
+                ST_X(ST_EndPoint(t.geom)) as end_lng,
+                ST_Y(ST_EndPoint(t.geom)) as end_lat,
+                t.distance_m,
+                COALESCE((t.elevation_data->>'gain')::REAL, 0) as elevation_gain_meters,
+                COALESCE((t.elevation_data->>'loss')::REAL, 0) as elevation_loss_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (SELECT MIN((value->>'elevation')::REAL) FROM jsonb_array_elements(t.elevation_data->'profile') AS value)
+                    ELSE NULL
+                END as min_elevation_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (SELECT MAX((value->>'elevation')::REAL) FROM jsonb_array_elements(t.elevation_data->'profile') AS value)
+                    ELSE NULL
+                END as max_elevation_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (t.elevation_data->'profile'->0->>'elevation')::REAL
+                    ELSE NULL
+                END as elevation_start_meters,
+                CASE
+                    WHEN t.elevation_data->'profile' IS NOT NULL AND jsonb_array_length(t.elevation_data->'profile') > 0 THEN
+                        (t.elevation_data->'profile'->-1->>'elevation')::REAL
+                    ELSE NULL
+                END as elevation_end_meters,
+                t.ridden,
+                ST_AsMVTGeom(
+                    ST_Transform(t.geom, 3857),
+                    v_tile_env,
+                    4096, 64, true
+                ) AS geom
+            FROM trails t
+            JOIN trail_tiles tt ON t.id = tt.trail_id
+            WHERE tt.z = p_z AND tt.x = p_x AND tt.y = p_y
+        ) AS mvt_geom
+        WHERE geom IS NOT NULL;
+    END IF;
+
+    RETURN COALESCE(v_mvt, ''::BYTEA);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+ALTER TABLE trails
+    DROP COLUMN IF EXISTS rating_average,
+    DROP COLUMN IF EXISTS rating_count,
+    DROP COLUMN IF EXISTS comment_count;
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"bike-map/config"
+)
+
+// newFreshPostGIS creates an empty database on the PostGIS server of the environment and drops
+// it when the test ends. Tests using it are skipped unless POSTGIS_TEST is set.
+func newFreshPostGIS(t *testing.T) *sql.DB {
+	t.Helper()
+
+	if os.Getenv("POSTGIS_TEST") == "" {
+		t.Skip("set POSTGIS_TEST and the POSTGRES_* variables to run against a PostGIS server")
+	}
+
+	cfg := config.Load()
+	server, err := OpenPostGIS(cfg)
+	if err != nil {
+		t.Fatalf("OpenPostGIS: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	name := fmt.Sprintf("bikemap_test_%d", time.Now().UnixNano())
+	if _, err := server.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+
+	cfg.Database.Database = name
+	db, err := OpenPostGIS(cfg)
+	if err != nil {
+		t.Fatalf("OpenPostGIS: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := server.Exec("DROP DATABASE IF EXISTS " + name); err != nil {
+			t.Logf("failed to drop test database %s: %v", name, err)
+		}
+	})
+	return db
+}
+
+func TestPostGISMigratorStatusOnFreshDatabase(t *testing.T) {
+	db := newFreshPostGIS(t)
+	ctx := context.Background()
+
+	migrator, err := NewPostGISMigrator(db)
+	if err != nil {
+		t.Fatalf("NewPostGISMigrator: %v", err)
+	}
+	migrations, err := loadPostGISMigrations()
+	if err != nil {
+		t.Fatalf("loadPostGISMigrations: %v", err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("Status lists %d migrations, want %d", len(statuses), len(migrations))
+	}
+	for i, status := range statuses {
+		if status.Applied || status.Unknown || status.Version != migrations[i].version {
+			t.Errorf("status %d = %+v, want pending migration %s", i, status, migrations[i].name)
+		}
+	}
+
+	applied, err := migrator.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("Migrate applied %d migrations, want %d", applied, len(migrations))
+	}
+
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.ChecksumMismatch || status.AppliedAt == nil {
+			t.Errorf("status after Migrate = %+v, want applied", status)
+		}
+	}
+
+	if applied, err := migrator.Migrate(ctx); err != nil || applied != 0 {
+		t.Errorf("second Migrate = %d, %v, want 0, nil", applied, err)
+	}
+}
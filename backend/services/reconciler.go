@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/metrics"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const reconcilerJobID = "bikemap_reconcile"
+
+// driftKinds lists every drift kind, so metrics of kinds that disappeared are reset to zero
+var driftKinds = []string{
+	entities.DriftMissingInPostGIS,
+	entities.DriftOrphanInPostGIS,
+	entities.DriftStaleImport,
+	entities.DriftGeometryMismatch,
+	entities.DriftMissingTileIndex,
+	entities.DriftRatingAverageMismatch,
+}
+
+// ReconcilerConfig holds consistency reconciler configuration
+type ReconcilerConfig struct {
+	schedule   string // Cron expression, empty disables scheduled runs
+	autoRepair bool   // Repair drift found by scheduled runs
+}
+
+// ReconcilerService periodically compares PocketBase (source of truth) with PostGIS and the
+// rating_average records, reports drift and optionally repairs it
+type ReconcilerService struct {
+	app          core.App
+	orchestrator *OrchestrationService
+	outbox       *SyncOutboxService
+	engagement   interfaces.Engagement
+	generator    interfaces.MVTGenerator
+	cfg          ReconcilerConfig
+
+	running    sync.Mutex // Held for the duration of a run
+	mu         sync.RWMutex
+	lastReport *entities.DriftReport
+}
+
+// driftFinding is a detected issue with the action that repairs it
+type driftFinding struct {
+	issue  entities.DriftIssue
+	repair func() error
+}
+
+// NewReconcilerService creates a new reconciler service
+func NewReconcilerService(
+	app core.App,
+	orchestrator *OrchestrationService,
+	outbox *SyncOutboxService,
+	engagement interfaces.Engagement,
+	generator interfaces.MVTGenerator,
+	cfg ReconcilerConfig,
+) *ReconcilerService {
+	return &ReconcilerService{
+		app:          app,
+		orchestrator: orchestrator,
+		outbox:       outbox,
+		engagement:   engagement,
+		generator:    generator,
+		cfg:          cfg,
+	}
+}
+
+// Schedule registers the periodic reconciliation with the PocketBase cron scheduler
+func (r *ReconcilerService) Schedule() error {
+	if r.cfg.schedule == "" {
+		log.Println("Consistency reconciler schedule disabled")
+		return nil
+	}
+
+	err := r.app.Cron().Add(reconcilerJobID, r.cfg.schedule, func() {
+		if _, err := r.Run(context.Background(), r.cfg.autoRepair); err != nil && !errors.Is(err, interfaces.ErrReconcileRunning) {
+			log.Printf("Scheduled reconciliation failed: %v", err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule reconciler: %w", err)
+	}
+
+	log.Printf("Consistency reconciler scheduled (%s, auto-repair: %t)", r.cfg.schedule, r.cfg.autoRepair)
+	return nil
+}
+
+// LastReport returns the report of the last completed run, or nil if none ran yet
+func (r *ReconcilerService) LastReport() *entities.DriftReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastReport
+}
+
+// Run compares PocketBase with PostGIS and the rating_average records, and repairs drift if requested
+func (r *ReconcilerService) Run(ctx context.Context, repair bool) (*entities.DriftReport, error) {
+	if !r.running.TryLock() {
+		return nil, interfaces.ErrReconcileRunning
+	}
+	defer r.running.Unlock()
+
+	report := &entities.DriftReport{
+		StartedAt:  time.Now(),
+		AutoRepair: repair,
+		Counts:     make(map[string]int),
+		Issues:     []entities.DriftIssue{},
+	}
+
+	findings, err := r.detect(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, finding := range findings {
+		issue := finding.issue
+		if repair {
+			if err := finding.repair(); err != nil {
+				issue.RepairError = err.Error()
+				report.RepairFailed++
+			} else {
+				issue.Repaired = true
+				report.Repaired++
+			}
+		}
+		report.Counts[issue.Kind]++
+		report.Issues = append(report.Issues, issue)
+	}
+
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+
+	for _, kind := range driftKinds {
+		metrics.DriftIssues.WithLabelValues(kind).Set(float64(report.Counts[kind]))
+	}
+
+	r.mu.Lock()
+	r.lastReport = report
+	r.mu.Unlock()
+
+	log.Printf("Reconciliation complete in %dms: %d issues (%d repaired, %d failed)",
+		report.DurationMs, len(report.Issues), report.Repaired, report.RepairFailed)
+	return report, nil
+}
+
+// detect collects drift between PocketBase and PostGIS, and between the rating_average
+// records and trail_ratings. Trails with sync events still pending in the outbox are skipped.
+func (r *ReconcilerService) detect(ctx context.Context, report *entities.DriftReport) ([]driftFinding, error) {
+	trails, err := r.app.FindAllRecords("trails")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trails: %w", err)
+	}
+
+	states, err := r.generator.GetTrailStates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get generator trail states: %w", err)
+	}
+
+	ratings, err := r.engagement.ComputeRatingAverages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	averages, err := r.engagement.GetRatingAverageRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read last: changes made before the reads above are still pending or already applied
+	pending, err := r.outbox.PendingTrailIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report.TrailsPB = len(trails)
+	report.TrailsPostGIS = len(states)
+
+	var findings []driftFinding
+	inPB := make(map[string]bool, len(trails))
+
+	for _, trail := range trails {
+		trailID := trail.Id
+		inPB[trailID] = true
+
+		// The outbox has yet to apply the trail's latest changes, they are not drift
+		if pending[trailID] {
+			report.SkippedPending++
+			continue
+		}
+
+		truth := ratings[trailID]
+		if finding, ok := r.checkRatingAverage(trailID, truth, averages[trailID]); ok {
+			findings = append(findings, finding)
+		}
+
+		state, exists := states[trailID]
+		resync := func() error { return r.orchestrator.HandleTrailUpdated(ctx, r.app, trailID) }
+
+		switch {
+		case !exists:
+			findings = append(findings, driftFinding{
+				issue:  entities.DriftIssue{Kind: entities.DriftMissingInPostGIS, TrailID: trailID},
+				repair: resync,
+			})
+		case state.SyncHash != trailSyncHash(trail):
+			findings = append(findings, driftFinding{
+				issue:  entities.DriftIssue{Kind: entities.DriftStaleImport, TrailID: trailID},
+				repair: resync,
+			})
+		case !state.GeometryValid:
+			findings = append(findings, driftFinding{
+				issue:  entities.DriftIssue{Kind: entities.DriftGeometryMismatch, TrailID: trailID},
+				repair: resync,
+			})
+		case state.HasGeometry && state.TileCount == 0:
+			findings = append(findings, driftFinding{
+				issue:  entities.DriftIssue{Kind: entities.DriftMissingTileIndex, TrailID: trailID},
+				repair: resync,
+			})
+		}
+	}
+
+	for trailID := range states {
+		if inPB[trailID] || pending[trailID] {
+			continue
+		}
+		findings = append(findings, driftFinding{
+			issue:  entities.DriftIssue{Kind: entities.DriftOrphanInPostGIS, TrailID: trailID},
+			repair: func() error { return r.orchestrator.HandleTrailDeleted(ctx, trailID) },
+		})
+	}
+
+	// rating_average records of trails that no longer exist
+	for trailID := range averages {
+		if inPB[trailID] || pending[trailID] {
+			continue
+		}
+		findings = append(findings, driftFinding{
+			issue: entities.DriftIssue{
+				Kind:    entities.DriftRatingAverageMismatch,
+				TrailID: trailID,
+				Detail:  "rating_average record for a missing trail",
+			},
+			repair: func() error { return r.engagement.DeleteRatingAverage(r.app, trailID) },
+		})
+	}
+
+	return findings, nil
+}
+
+// checkRatingAverage compares a trail's rating_average record with its trail_ratings
+func (r *ReconcilerService) checkRatingAverage(trailID string, truth, stored *entities.RatingAverage) (driftFinding, bool) {
+	var detail string
+	switch {
+	case truth != nil && stored == nil:
+		detail = fmt.Sprintf("missing record, expected avg=%.2f count=%d", truth.Average, truth.Count)
+	case truth == nil && stored != nil:
+		detail = fmt.Sprintf("record avg=%.2f count=%d for a trail without ratings", stored.Average, stored.Count)
+	case truth != nil && (math.Abs(truth.Average-stored.Average) > 1e-9 || truth.Count != stored.Count):
+		detail = fmt.Sprintf("record avg=%.2f count=%d, expected avg=%.2f count=%d",
+			stored.Average, stored.Count, truth.Average, truth.Count)
+	default:
+		return driftFinding{}, false
+	}
+
+	return driftFinding{
+		issue: entities.DriftIssue{Kind: entities.DriftRatingAverageMismatch, TrailID: trailID, Detail: detail},
+		// Recalculates the record from trail_ratings, or removes it when none are left
+		repair: func() error { return r.engagement.DeleteRatingAverage(r.app, trailID) },
+	}, true
+}
+
+// Compile-time check to ensure ReconcilerService implements interfaces.ConsistencyReconciler
+var _ interfaces.ConsistencyReconciler = (*ReconcilerService)(nil)
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"sync"
+
+	"bike-map/interfaces"
+)
+
+// SnapshotDownloads counts the downloads in progress per snapshot file, so retention
+// cleanup skips snapshots that are still being streamed to clients
+type SnapshotDownloads struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// NewSnapshotDownloads creates a new download tracker
+func NewSnapshotDownloads() *SnapshotDownloads {
+	return &SnapshotDownloads{
+		active: make(map[string]int),
+	}
+}
+
+// Begin registers a download of filename. The returned function ends it.
+func (d *SnapshotDownloads) Begin(filename string) func() {
+	d.mu.Lock()
+	d.active[filename]++
+	d.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.active[filename]--; d.active[filename] <= 0 {
+				delete(d.active, filename)
+			}
+		})
+	}
+}
+
+// InUse reports whether filename is being downloaded
+func (d *SnapshotDownloads) InUse(filename string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active[filename] > 0
+}
+
+// Compile-time check to ensure SnapshotDownloads implements interfaces.SnapshotDownloadTracker
+var _ interfaces.SnapshotDownloadTracker = (*SnapshotDownloads)(nil)
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/metrics"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	syncOutboxCollection = "sync_outbox"
+	syncOutboxBatchSize  = 100  // Events loaded per worker pass
+	syncOutboxMaxError   = 1000 // Max stored length of an event's last error
+)
+
+// SyncOutboxConfig holds sync outbox worker configuration
+type SyncOutboxConfig struct {
+	pollInterval time.Duration
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+}
+
+// SyncOutboxService is a durable outbox for PocketBase → PostGIS sync events.
+// Events are recorded in the same PocketBase transaction as the record change, then applied
+// by a single worker in creation order, with exponential backoff and dead-lettering.
+type SyncOutboxService struct {
+	app          core.App
+	orchestrator *OrchestrationService
+	cfg          SyncOutboxConfig
+
+	notify   chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewSyncOutboxService creates a new sync outbox service. Events are recorded right away;
+// call Start once the collections exist and PostGIS is reachable to apply them.
+func NewSyncOutboxService(app core.App, cfg SyncOutboxConfig) *SyncOutboxService {
+	return &SyncOutboxService{
+		app:      app,
+		cfg:      cfg,
+		notify:   make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Enqueue records a sync event. txApp should be the transaction the record change runs in,
+// so the event is persisted if and only if the change is.
+func (s *SyncOutboxService) Enqueue(txApp core.App, entityType, action, trailID, recordID string) error {
+	collection, err := txApp.FindCachedCollectionByNameOrId(syncOutboxCollection)
+	if err != nil {
+		return fmt.Errorf("failed to find sync_outbox collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("entity_type", entityType)
+	record.Set("action", action)
+	record.Set("trail_id", trailID)
+	record.Set("record_id", recordID)
+	record.Set("status", entities.SyncStatusPending)
+	record.Set("attempts", 0)
+	record.Set("next_attempt_at", time.Now())
+
+	if err := txApp.Save(record); err != nil {
+		return fmt.Errorf("failed to record sync event: %w", err)
+	}
+	return nil
+}
+
+// Notify wakes the worker to process new events without waiting for the next poll
+func (s *SyncOutboxService) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the outbox worker, applying events through the orchestrator
+func (s *SyncOutboxService) Start(orchestrator *OrchestrationService) {
+	s.orchestrator = orchestrator
+	s.wg.Add(1)
+	go s.run()
+	log.Printf("Sync outbox worker started (poll: %s, max attempts: %d)", s.cfg.pollInterval, s.cfg.maxAttempts)
+}
+
+// Stop stops the outbox worker and waits for the current event to finish
+func (s *SyncOutboxService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+	s.wg.Wait()
+}
+
+// run processes due events on every poll tick or notification
+func (s *SyncOutboxService) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.processDue()
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		case <-s.notify:
+		}
+	}
+}
+
+// processDue applies pending events whose retry time has come, in passes until none is left.
+// Events of a trail are applied in order: each pass only loads the oldest pending event of
+// every trail, and a trail with a dead-lettered event waits until it is retried or discarded.
+func (s *SyncOutboxService) processDue() {
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+
+		records, err := s.dueEvents()
+		if err != nil {
+			log.Printf("Failed to load pending sync events: %v", err)
+			return
+		}
+
+		applied := 0
+		for _, record := range records {
+			select {
+			case <-s.stopChan:
+				return
+			default:
+			}
+
+			event := recordToSyncEvent(record)
+			err := s.apply(event)
+			metrics.ObserveSync(event.EntityType, event.Action, err)
+			if err != nil {
+				s.recordFailure(record, event, err)
+				continue
+			}
+
+			if err := s.app.Delete(record); err != nil {
+				log.Printf("Failed to remove applied sync event %s: %v", event.ID, err)
+				continue
+			}
+			applied++
+		}
+
+		// Only applied events make the next events of their trail due
+		if applied == 0 {
+			return
+		}
+	}
+}
+
+// dueEvents loads the oldest pending event of each trail, if its retry time has come and the
+// trail has no dead-lettered event, longest waiting first
+func (s *SyncOutboxService) dueEvents() ([]*core.Record, error) {
+	var records []*core.Record
+	err := s.app.RecordQuery(syncOutboxCollection).
+		AndWhere(dbx.HashExp{"status": entities.SyncStatusPending}).
+		AndWhere(dbx.NewExp("[[next_attempt_at]] <= {:now}", dbx.Params{"now": types.NowDateTime().String()})).
+		AndWhere(dbx.NewExp(`NOT EXISTS (
+			SELECT 1 FROM {{`+syncOutboxCollection+`}} [[earlier]]
+			WHERE [[earlier.trail_id]] = [[`+syncOutboxCollection+`.trail_id]]
+			AND ([[earlier.status]] = {:dead} OR ([[earlier.status]] = {:pending} AND
+				([[earlier.created]] < [[`+syncOutboxCollection+`.created]] OR
+				([[earlier.created]] = [[`+syncOutboxCollection+`.created]] AND [[earlier.rowid]] < [[`+syncOutboxCollection+`.rowid]]))))
+		)`, dbx.Params{"dead": entities.SyncStatusDead, "pending": entities.SyncStatusPending})).
+		OrderBy("next_attempt_at ASC", "created ASC", "rowid ASC").
+		Limit(syncOutboxBatchSize).
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// apply runs a sync event against the orchestration service
+func (s *SyncOutboxService) apply(event entities.SyncEvent) error {
+	ctx := context.Background()
+
+	// Only deletions of trails apply to a trail that no longer exists. For everything else, the
+	// trail's own deletion event cleans up PostGIS and tiles.
+	if !(event.EntityType == entities.SyncEntityTrail && event.Action == entities.SyncActionDeleted) {
+		exists, err := s.trailExists(event.TrailID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return nil
+		}
+	}
+
+	switch event.EntityType + "/" + event.Action {
+	case entities.SyncEntityTrail + "/" + entities.SyncActionCreated:
+		return s.orchestrator.HandleTrailCreated(ctx, s.app, event.TrailID)
+	case entities.SyncEntityTrail + "/" + entities.SyncActionUpdated:
+		return s.orchestrator.HandleTrailUpdated(ctx, s.app, event.TrailID)
+	case entities.SyncEntityTrail + "/" + entities.SyncActionDeleted:
+		return s.orchestrator.HandleTrailDeleted(ctx, event.TrailID)
+	case entities.SyncEntityRating + "/" + entities.SyncActionCreated:
+		return s.orchestrator.HandleRatingCreated(ctx, s.app, event.TrailID)
+	case entities.SyncEntityRating + "/" + entities.SyncActionUpdated:
+		return s.orchestrator.HandleRatingUpdated(ctx, s.app, event.TrailID)
+	case entities.SyncEntityRating + "/" + entities.SyncActionDeleted:
+		return s.orchestrator.HandleRatingDeleted(ctx, s.app, event.TrailID)
+	case entities.SyncEntityComment + "/" + entities.SyncActionCreated:
+		return s.orchestrator.HandleCommentCreated(ctx, s.app, event.TrailID)
+	case entities.SyncEntityComment + "/" + entities.SyncActionDeleted:
+		return s.orchestrator.HandleCommentDeleted(ctx, s.app, event.TrailID)
+	default:
+		return fmt.Errorf("unknown sync event: %s %s", event.EntityType, event.Action)
+	}
+}
+
+// trailExists checks whether a trail is still present in PocketBase
+func (s *SyncOutboxService) trailExists(trailID string) (bool, error) {
+	_, err := s.app.FindRecordById("trails", trailID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to find trail: %w", err)
+	}
+	return true, nil
+}
+
+// recordFailure schedules a retry of a failed event, or dead-letters it after the last attempt
+func (s *SyncOutboxService) recordFailure(record *core.Record, event entities.SyncEvent, syncErr error) {
+	attempts := event.Attempts + 1
+	message := syncErr.Error()
+	if len(message) > syncOutboxMaxError {
+		message = message[:syncOutboxMaxError]
+	}
+
+	record.Set("attempts", attempts)
+	record.Set("last_error", message)
+
+	if attempts >= s.cfg.maxAttempts {
+		record.Set("status", entities.SyncStatusDead)
+		log.Printf("Sync event %s (%s %s, trail %s) dead-lettered after %d attempts: %v",
+			event.ID, event.EntityType, event.Action, event.TrailID, attempts, syncErr)
+	} else {
+		delay := s.backoff(attempts)
+		record.Set("next_attempt_at", time.Now().Add(delay))
+		log.Printf("Sync event %s (%s %s, trail %s) failed (attempt %d/%d), retrying in %s: %v",
+			event.ID, event.EntityType, event.Action, event.TrailID, attempts, s.cfg.maxAttempts, delay, syncErr)
+	}
+
+	if err := s.app.Save(record); err != nil {
+		log.Printf("Failed to record failure of sync event %s: %v", event.ID, err)
+	}
+}
+
+// backoff returns the delay before the next attempt: the base delay doubled per failed attempt, capped
+func (s *SyncOutboxService) backoff(attempts int) time.Duration {
+	delay := s.cfg.baseBackoff
+	for i := 1; i < attempts && delay < s.cfg.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.maxBackoff)
+}
+
+// ListEvents returns outbox events with the given status (all when empty), oldest first
+func (s *SyncOutboxService) ListEvents(status string, limit int) ([]entities.SyncEvent, error) {
+	query := s.app.RecordQuery(syncOutboxCollection).
+		OrderBy("created ASC", "rowid ASC").
+		Limit(int64(limit))
+	if status != "" {
+		query.AndWhere(dbx.HashExp{"status": status})
+	}
+
+	var records []*core.Record
+	if err := query.All(&records); err != nil {
+		return nil, fmt.Errorf("failed to list sync events: %w", err)
+	}
+
+	events := make([]entities.SyncEvent, 0, len(records))
+	for _, record := range records {
+		events = append(events, recordToSyncEvent(record))
+	}
+	return events, nil
+}
+
+// RetryEvent resets an event's attempts and makes it due immediately
+func (s *SyncOutboxService) RetryEvent(id string) error {
+	record, err := s.app.FindRecordById(syncOutboxCollection, id)
+	if err != nil {
+		return fmt.Errorf("failed to find sync event: %w", err)
+	}
+
+	record.Set("status", entities.SyncStatusPending)
+	record.Set("attempts", 0)
+	record.Set("next_attempt_at", time.Now())
+
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to reschedule sync event: %w", err)
+	}
+
+	s.Notify()
+	return nil
+}
+
+// DiscardEvent removes an event from the outbox without applying it
+func (s *SyncOutboxService) DiscardEvent(id string) error {
+	record, err := s.app.FindRecordById(syncOutboxCollection, id)
+	if err != nil {
+		return fmt.Errorf("failed to find sync event: %w", err)
+	}
+
+	if err := s.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to discard sync event: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the number of pending and dead events
+func (s *SyncOutboxService) Stats() (entities.SyncOutboxStats, error) {
+	pending, err := s.app.CountRecords(syncOutboxCollection, dbx.HashExp{"status": entities.SyncStatusPending})
+	if err != nil {
+		return entities.SyncOutboxStats{}, fmt.Errorf("failed to count pending sync events: %w", err)
+	}
+
+	dead, err := s.app.CountRecords(syncOutboxCollection, dbx.HashExp{"status": entities.SyncStatusDead})
+	if err != nil {
+		return entities.SyncOutboxStats{}, fmt.Errorf("failed to count dead sync events: %w", err)
+	}
+
+	return entities.SyncOutboxStats{Pending: int(pending), Dead: int(dead)}, nil
+}
+
+// PendingTrailIDs returns the trails with events waiting to be applied
+func (s *SyncOutboxService) PendingTrailIDs(ctx context.Context) (map[string]bool, error) {
+	var trailIDs []string
+	err := s.app.DB().
+		Select("trail_id").
+		Distinct(true).
+		From(syncOutboxCollection).
+		Where(dbx.HashExp{"status": entities.SyncStatusPending}).
+		WithContext(ctx).
+		Column(&trailIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trails with pending sync events: %w", err)
+	}
+
+	pending := make(map[string]bool, len(trailIDs))
+	for _, trailID := range trailIDs {
+		pending[trailID] = true
+	}
+	return pending, nil
+}
+
+// recordToSyncEvent converts a PocketBase record to a SyncEvent entity
+func recordToSyncEvent(record *core.Record) entities.SyncEvent {
+	return entities.SyncEvent{
+		ID:            record.Id,
+		EntityType:    record.GetString("entity_type"),
+		Action:        record.GetString("action"),
+		TrailID:       record.GetString("trail_id"),
+		RecordID:      record.GetString("record_id"),
+		Status:        record.GetString("status"),
+		Attempts:      record.GetInt("attempts"),
+		NextAttemptAt: record.GetDateTime("next_attempt_at").Time(),
+		LastError:     record.GetString("last_error"),
+		Created:       record.GetDateTime("created").Time(),
+	}
+}
+
+// Compile-time check to ensure SyncOutboxService implements interfaces.SyncOutboxAdmin
+var _ interfaces.SyncOutboxAdmin = (*SyncOutboxService)(nil)
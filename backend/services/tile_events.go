@@ -0,0 +1,247 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/utils"
+)
+
+const (
+	tileEventBufferSize    = 64          // Events buffered per subscriber before it is disconnected
+	tileEventMaxTiles      = 1000        // Tiles listed per event, larger batches are sent as their bbox
+	tileEventMaxStale      = 200000      // Invalidated tiles tracked for ready notifications
+	tileEventFlushInterval = time.Second // Tile events are batched over this interval
+
+	// Invalidated tiles not regenerated within tileEventStaleMaxAge, dropped from the background
+	// queue or never requested, are no longer tracked, checked every tileEventStaleSweepInterval
+	tileEventStaleMaxAge        = 15 * time.Minute
+	tileEventStaleSweepInterval = time.Minute
+)
+
+// staleTile is an invalidated tile waiting for regeneration
+type staleTile struct {
+	coords        entities.TileCoordinates
+	invalidatedAt time.Time
+}
+
+// TileEventBroker fans out tile invalidation events to connected map clients.
+// Regenerated tiles are only announced if they were invalidated before, so warm-up and
+// on-demand generation of untouched tiles stay silent. Tile events are coalesced into at most
+// one invalidated and one ready event per flush, so bulk changes do not overflow client buffers.
+type TileEventBroker struct {
+	mu          sync.Mutex
+	seq         uint64
+	nextID      uint64
+	subscribers map[uint64]chan entities.TileEvent
+	stale       map[string]staleTile                // Invalidated, waiting for regeneration
+	swept       time.Time                           // Last removal of expired stale tiles
+	invalidated map[string]entities.TileCoordinates // Invalidated since the last flush
+	ready       []entities.TileCoordinates          // Regenerated since the last flush
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewTileEventBroker creates a tile event broker and starts its ready batching loop
+func NewTileEventBroker() *TileEventBroker {
+	b := &TileEventBroker{
+		subscribers: make(map[uint64]chan entities.TileEvent),
+		stale:       make(map[string]staleTile),
+		invalidated: make(map[string]entities.TileCoordinates),
+		stopChan:    make(chan struct{}),
+	}
+
+	b.wg.Add(1)
+	go b.flushLoop()
+
+	return b
+}
+
+// Subscribe registers a client. The channel is closed when the client falls too far behind
+// (it should reconnect and reload its tiles) or the broker stops.
+func (b *TileEventBroker) Subscribe() (<-chan entities.TileEvent, func()) {
+	ch := make(chan entities.TileEvent, tileEventBufferSize)
+
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	b.subscribers[id] = ch
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if sub, ok := b.subscribers[id]; ok {
+			delete(b.subscribers, id)
+			close(sub)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// SubscriberCount returns the number of connected clients
+func (b *TileEventBroker) SubscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// TrailChanged announces a trail creation, update or deletion
+func (b *TileEventBroker) TrailChanged(trailID, action string, bbox *entities.BoundingBox) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publishLocked(entities.TileEvent{
+		Type:    entities.TileEventTrailChanged,
+		TrailID: trailID,
+		Action:  action,
+		BBox:    bbox,
+	})
+}
+
+// TilesInvalidated records stale tiles, announced with the next flush, and remembers them for
+// the ready notification
+func (b *TileEventBroker) TilesInvalidated(tiles []entities.TileCoordinates) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range tiles {
+		key := tileKey(c)
+		b.invalidated[key] = c
+		if _, ok := b.stale[key]; ok || len(b.stale) < tileEventMaxStale {
+			b.stale[key] = staleTile{coords: c, invalidatedAt: now}
+		}
+	}
+}
+
+// TilesReady records regenerated tiles. Previously invalidated ones are announced with the next flush.
+func (b *TileEventBroker) TilesReady(tiles []entities.TileCoordinates) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range tiles {
+		key := tileKey(c)
+		if _, ok := b.stale[key]; !ok {
+			continue
+		}
+		delete(b.stale, key)
+		b.ready = append(b.ready, c)
+	}
+}
+
+// Stop stops the batching loop and disconnects all clients
+func (b *TileEventBroker) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopChan)
+	})
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, ch := range b.subscribers {
+		delete(b.subscribers, id)
+		close(ch)
+	}
+}
+
+// flushLoop periodically announces the tiles invalidated and regenerated since the last flush
+func (b *TileEventBroker) flushLoop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(tileEventFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stopChan:
+			return
+		case now := <-ticker.C:
+			b.flush()
+			b.sweepStale(now)
+		}
+	}
+}
+
+// flush publishes the pending invalidated tiles, then the pending ready tiles
+func (b *TileEventBroker) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.invalidated) > 0 {
+		tiles := make([]entities.TileCoordinates, 0, len(b.invalidated))
+		for _, c := range b.invalidated {
+			tiles = append(tiles, c)
+		}
+		b.publishTilesLocked(entities.TileEventInvalidated, tiles)
+		clear(b.invalidated)
+	}
+
+	if len(b.ready) > 0 {
+		b.publishTilesLocked(entities.TileEventReady, b.ready)
+		b.ready = nil
+	}
+}
+
+// sweepStale stops tracking tiles invalidated more than tileEventStaleMaxAge before now, at most
+// once per tileEventStaleSweepInterval. Otherwise tiles that are never regenerated would fill
+// the stale map up to its cap, and no tile would be announced ready anymore.
+func (b *TileEventBroker) sweepStale(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.swept) < tileEventStaleSweepInterval {
+		return
+	}
+	b.swept = now
+
+	for key, tile := range b.stale {
+		if now.Sub(tile.invalidatedAt) > tileEventStaleMaxAge {
+			delete(b.stale, key)
+		}
+	}
+}
+
+// publishTilesLocked publishes a single tile event: the tiles themselves, or their bbox if
+// there are more than tileEventMaxTiles
+func (b *TileEventBroker) publishTilesLocked(eventType string, tiles []entities.TileCoordinates) {
+	if len(tiles) <= tileEventMaxTiles {
+		b.publishLocked(entities.TileEvent{Type: eventType, Tiles: tiles})
+		return
+	}
+
+	var bbox *entities.BoundingBox
+	for _, c := range tiles {
+		bounds := utils.TileBounds(c)
+		bbox = unionBBox(bbox, &bounds)
+	}
+	b.publishLocked(entities.TileEvent{Type: eventType, BBox: bbox})
+}
+
+// publishLocked sends an event to every subscriber without blocking.
+// Subscribers whose buffer is full are disconnected rather than silently missing events.
+func (b *TileEventBroker) publishLocked(event entities.TileEvent) {
+	b.seq++
+	event.Seq = b.seq
+
+	for id, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Tile event subscriber %d too slow, disconnecting", id)
+			delete(b.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+// Compile-time checks to ensure TileEventBroker implements the tile event interfaces
+var (
+	_ interfaces.TileEventPublisher = (*TileEventBroker)(nil)
+	_ interfaces.TileEventSource    = (*TileEventBroker)(nil)
+)
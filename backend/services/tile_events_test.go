@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"bike-map/entities"
+)
+
+// newTestTileEventBroker creates a broker without its flush loop, flushed by the tests
+func newTestTileEventBroker(t *testing.T) (*TileEventBroker, <-chan entities.TileEvent) {
+	t.Helper()
+
+	b := &TileEventBroker{
+		subscribers: make(map[uint64]chan entities.TileEvent),
+		stale:       make(map[string]staleTile),
+		invalidated: make(map[string]entities.TileCoordinates),
+		stopChan:    make(chan struct{}),
+	}
+	t.Cleanup(b.Stop)
+
+	events, _ := b.Subscribe()
+	return b, events
+}
+
+// flushReady flushes the broker and returns the tiles announced ready
+func flushReady(b *TileEventBroker, events <-chan entities.TileEvent) []entities.TileCoordinates {
+	b.flush()
+
+	var ready []entities.TileCoordinates
+	for {
+		select {
+		case event := <-events:
+			if event.Type == entities.TileEventReady {
+				ready = append(ready, event.Tiles...)
+			}
+		default:
+			return ready
+		}
+	}
+}
+
+func TestTileEventsStaleCap(t *testing.T) {
+	b, events := newTestTileEventBroker(t)
+
+	tiles := make([]entities.TileCoordinates, tileEventMaxStale)
+	for i := range tiles {
+		tiles[i] = entities.TileCoordinates{Z: 18, X: i, Y: 0}
+	}
+	b.TilesInvalidated(tiles)
+
+	// Beyond the cap, invalidated tiles are not tracked and not announced ready
+	extra := entities.TileCoordinates{Z: 18, X: 0, Y: 1}
+	b.TilesInvalidated([]entities.TileCoordinates{extra})
+	b.TilesReady([]entities.TileCoordinates{extra})
+	if ready := flushReady(b, events); len(ready) != 0 {
+		t.Fatalf("ready = %v over the cap, want none", ready)
+	}
+
+	// Tracked tiles invalidated again are kept
+	b.TilesInvalidated(tiles[:1])
+	b.TilesReady(tiles[:1])
+	if ready := flushReady(b, events); len(ready) != 1 || ready[0] != tiles[0] {
+		t.Fatalf("ready = %v, want [%v]", ready, tiles[0])
+	}
+
+	// Once the tiles that were never regenerated expire, tracking resumes
+	b.sweepStale(time.Now().Add(tileEventStaleMaxAge + time.Second))
+	if len(b.stale) != 0 {
+		t.Fatalf("%d stale tiles after expiry, want 0", len(b.stale))
+	}
+	b.TilesInvalidated([]entities.TileCoordinates{extra})
+	b.TilesReady([]entities.TileCoordinates{extra})
+	if ready := flushReady(b, events); len(ready) != 1 || ready[0] != extra {
+		t.Fatalf("ready = %v after expiry, want [%v]", ready, extra)
+	}
+}
+
+func TestTileEventsStaleExpiry(t *testing.T) {
+	b, events := newTestTileEventBroker(t)
+
+	expired := entities.TileCoordinates{Z: 12, X: 2138, Y: 1447}
+	recent := entities.TileCoordinates{Z: 12, X: 2139, Y: 1447}
+	start := time.Now()
+
+	b.TilesInvalidated([]entities.TileCoordinates{expired})
+	b.stale[tileKey(expired)] = staleTile{coords: expired, invalidatedAt: start.Add(-tileEventStaleMaxAge)}
+	b.TilesInvalidated([]entities.TileCoordinates{recent})
+
+	// Sweeps are throttled: a sweep right after the previous one does nothing
+	b.sweepStale(start)
+	b.sweepStale(start.Add(time.Second))
+	if len(b.stale) != 2 {
+		t.Fatalf("%d stale tiles before expiry, want 2", len(b.stale))
+	}
+
+	b.sweepStale(start.Add(tileEventStaleSweepInterval))
+	if _, ok := b.stale[tileKey(expired)]; ok {
+		t.Errorf("tile %v not expired", expired)
+	}
+	if _, ok := b.stale[tileKey(recent)]; !ok {
+		t.Errorf("tile %v expired too early", recent)
+	}
+
+	b.TilesReady([]entities.TileCoordinates{expired, recent})
+	if ready := flushReady(b, events); len(ready) != 1 || ready[0] != recent {
+		t.Errorf("ready = %v, want [%v]", ready, recent)
+	}
+}
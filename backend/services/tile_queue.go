@@ -0,0 +1,242 @@
+package services
+
+import (
+	"container/heap"
+	"sync"
+
+	"bike-map/entities"
+)
+
+// tileQueueItem is a pending tile in the work queue
+type tileQueueItem struct {
+	coords     entities.TileCoordinates
+	seq        uint64 // Insertion order, keeps FIFO within a zoom level
+	index      int    // Position in the work heap
+	evictIndex int    // Position in the eviction heap
+}
+
+// before reports whether a is processed before b: low zoom first, then insertion order
+func (a *tileQueueItem) before(b *tileQueueItem) bool {
+	if a.coords.Z != b.coords.Z {
+		return a.coords.Z < b.coords.Z
+	}
+	return a.seq < b.seq
+}
+
+// tileHeap orders pending tiles in processing order, or in reverse for eviction
+type tileHeap struct {
+	items   []*tileQueueItem
+	reverse bool // Lowest priority tile first, tracked in evictIndex
+}
+
+func (h *tileHeap) Len() int { return len(h.items) }
+
+func (h *tileHeap) Less(i, j int) bool {
+	if h.reverse {
+		return h.items[j].before(h.items[i])
+	}
+	return h.items[i].before(h.items[j])
+}
+
+func (h *tileHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.setIndex(i)
+	h.setIndex(j)
+}
+
+func (h *tileHeap) Push(x any) {
+	h.items = append(h.items, x.(*tileQueueItem))
+	h.setIndex(len(h.items) - 1)
+}
+
+func (h *tileHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	return item
+}
+
+// setIndex records the position of the item at i
+func (h *tileHeap) setIndex(i int) {
+	if h.reverse {
+		h.items[i].evictIndex = i
+	} else {
+		h.items[i].index = i
+	}
+}
+
+// TileWorkQueue is a set-based background work queue: a tile is pending at most once,
+// and low zoom tiles (covering the most area) are handed out first
+type TileWorkQueue struct {
+	mu       sync.Mutex
+	pending  map[string]*tileQueueItem
+	heap     tileHeap // Processing order
+	evict    tileHeap // Eviction order, the same items
+	capacity int
+	seq      uint64
+	ready    chan struct{} // Signals idle workers that work is available
+
+	queued    uint64
+	coalesced uint64
+	dropped   uint64
+}
+
+// NewTileWorkQueue creates a work queue holding at most capacity distinct tiles
+func NewTileWorkQueue(capacity int) *TileWorkQueue {
+	return &TileWorkQueue{
+		pending:  make(map[string]*tileQueueItem),
+		evict:    tileHeap{reverse: true},
+		capacity: capacity,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// Push adds tiles to the queue, merging tiles that are already pending. When the queue is full,
+// the lowest priority tile is dropped: the pending one with the highest zoom level if it comes after
+// the new tile, else the new tile. It returns the number of tiles dropped.
+func (q *TileWorkQueue) Push(tiles []entities.TileCoordinates) int {
+	q.mu.Lock()
+	dropped := 0
+	for _, c := range tiles {
+		key := tileKey(c)
+		if _, exists := q.pending[key]; exists {
+			q.coalesced++
+			continue
+		}
+
+		item := &tileQueueItem{coords: c, seq: q.seq + 1}
+		if q.capacity > 0 && q.heap.Len() >= q.capacity {
+			dropped++
+			last := q.evict.items[0]
+			if !item.before(last) {
+				continue
+			}
+			q.remove(last)
+		}
+
+		q.seq++
+		heap.Push(&q.heap, item)
+		heap.Push(&q.evict, item)
+		q.pending[key] = item
+		q.queued++
+	}
+	q.dropped += uint64(dropped)
+	hasWork := q.heap.Len() > 0
+	q.mu.Unlock()
+
+	if hasWork {
+		q.signal()
+	}
+	return dropped
+}
+
+// remove takes a pending tile out of the queue
+func (q *TileWorkQueue) remove(item *tileQueueItem) {
+	heap.Remove(&q.heap, item.index)
+	heap.Remove(&q.evict, item.evictIndex)
+	delete(q.pending, tileKey(item.coords))
+}
+
+// Pop removes the highest priority tile, without blocking
+func (q *TileWorkQueue) Pop() (entities.TileCoordinates, bool) {
+	q.mu.Lock()
+	if q.heap.Len() == 0 {
+		q.mu.Unlock()
+		return entities.TileCoordinates{}, false
+	}
+
+	item := q.heap.items[0]
+	q.remove(item)
+	hasMore := q.heap.Len() > 0
+	q.mu.Unlock()
+
+	// Wake another idle worker for the remaining tiles
+	if hasMore {
+		q.signal()
+	}
+	return item.coords, true
+}
+
+// Ready returns a channel that receives when tiles may be available
+func (q *TileWorkQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Len returns the number of pending tiles
+func (q *TileWorkQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.heap.Len()
+}
+
+// Stats returns pending and lifetime counters
+func (q *TileWorkQueue) Stats() entities.TileQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return entities.TileQueueStats{
+		Pending:   q.heap.Len(),
+		Queued:    q.queued,
+		Coalesced: q.coalesced,
+		Dropped:   q.dropped,
+	}
+}
+
+// Peek returns up to limit pending tiles in processing order, without removing them.
+// The heap is walked best first from its root, so only about limit items are compared.
+func (q *TileWorkQueue) Peek(limit int) []entities.TileCoordinates {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := q.heap.items
+	tiles := make([]entities.TileCoordinates, 0, min(limit, len(items)))
+	if len(items) == 0 {
+		return tiles
+	}
+
+	// Heap positions whose parent was already returned, best first
+	frontier := &heapPositions{items: items, positions: []int{0}}
+	for len(tiles) < limit && frontier.Len() > 0 {
+		i := heap.Pop(frontier).(int)
+		tiles = append(tiles, items[i].coords)
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(items) {
+				heap.Push(frontier, child)
+			}
+		}
+	}
+	return tiles
+}
+
+// heapPositions is a heap of positions in a tileHeap, ordered by their items
+type heapPositions struct {
+	items     []*tileQueueItem
+	positions []int
+}
+
+func (h *heapPositions) Len() int { return len(h.positions) }
+
+func (h *heapPositions) Less(i, j int) bool {
+	return h.items[h.positions[i]].before(h.items[h.positions[j]])
+}
+
+func (h *heapPositions) Swap(i, j int) {
+	h.positions[i], h.positions[j] = h.positions[j], h.positions[i]
+}
+
+func (h *heapPositions) Push(x any) { h.positions = append(h.positions, x.(int)) }
+
+func (h *heapPositions) Pop() any {
+	n := len(h.positions)
+	i := h.positions[n-1]
+	h.positions = h.positions[:n-1]
+	return i
+}
+
+// signal wakes one idle worker without blocking
+func (q *TileWorkQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"bike-map/entities"
+)
+
+// queueTile returns the coordinates of a test tile, named by its zoom level and x
+func queueTile(z, x int) entities.TileCoordinates {
+	return entities.TileCoordinates{Z: z, X: x, Y: 0}
+}
+
+// checkTileQueue fails if the heaps, their position bookkeeping or the pending set are inconsistent
+func checkTileQueue(t *testing.T, q *TileWorkQueue) {
+	t.Helper()
+
+	if q.heap.Len() != len(q.pending) || q.evict.Len() != len(q.pending) {
+		t.Fatalf("heap has %d items, eviction heap %d, pending set %d", q.heap.Len(), q.evict.Len(), len(q.pending))
+	}
+	for _, h := range []*tileHeap{&q.heap, &q.evict} {
+		for i, item := range h.items {
+			position := item.index
+			if h.reverse {
+				position = item.evictIndex
+			}
+			if position != i {
+				t.Fatalf("item %v at %d records position %d (reverse %v)", item.coords, i, position, h.reverse)
+			}
+			if q.pending[tileKey(item.coords)] != item {
+				t.Fatalf("item %v is not the pending item for its tile", item.coords)
+			}
+			if i > 0 && h.Less(i, (i-1)/2) {
+				t.Fatalf("item %v at %d comes before its parent (reverse %v)", item.coords, i, h.reverse)
+			}
+		}
+	}
+}
+
+// popAll pops every pending tile, checking the queue after each pop
+func popAll(t *testing.T, q *TileWorkQueue) []entities.TileCoordinates {
+	t.Helper()
+
+	var tiles []entities.TileCoordinates
+	for {
+		c, ok := q.Pop()
+		if !ok {
+			return tiles
+		}
+		checkTileQueue(t, q)
+		tiles = append(tiles, c)
+	}
+}
+
+func TestTileWorkQueue(t *testing.T) {
+	tests := []struct {
+		name          string
+		capacity      int
+		pushes        [][]entities.TileCoordinates
+		wantDropped   []int // Per push
+		wantCoalesced uint64
+		wantOrder     []entities.TileCoordinates
+	}{
+		{
+			name:      "low zoom first, then FIFO",
+			pushes:    [][]entities.TileCoordinates{{queueTile(14, 1), queueTile(12, 1), queueTile(14, 2)}, {queueTile(12, 2), queueTile(10, 1)}},
+			wantOrder: []entities.TileCoordinates{queueTile(10, 1), queueTile(12, 1), queueTile(12, 2), queueTile(14, 1), queueTile(14, 2)},
+		},
+		{
+			name:          "duplicates coalesced",
+			pushes:        [][]entities.TileCoordinates{{queueTile(12, 1), queueTile(12, 2), queueTile(12, 1)}, {queueTile(12, 2), queueTile(12, 3)}},
+			wantCoalesced: 2,
+			wantOrder:     []entities.TileCoordinates{queueTile(12, 1), queueTile(12, 2), queueTile(12, 3)},
+		},
+		{
+			name:        "full queue evicts the highest zoom tile",
+			capacity:    3,
+			pushes:      [][]entities.TileCoordinates{{queueTile(12, 1), queueTile(16, 1), queueTile(14, 1)}, {queueTile(10, 1)}},
+			wantDropped: []int{0, 1},
+			wantOrder:   []entities.TileCoordinates{queueTile(10, 1), queueTile(12, 1), queueTile(14, 1)},
+		},
+		{
+			name:        "full queue evicts the newest tile of the highest zoom",
+			capacity:    3,
+			pushes:      [][]entities.TileCoordinates{{queueTile(14, 1), queueTile(12, 1), queueTile(14, 2)}, {queueTile(12, 2)}},
+			wantDropped: []int{0, 1},
+			wantOrder:   []entities.TileCoordinates{queueTile(12, 1), queueTile(12, 2), queueTile(14, 1)},
+		},
+		{
+			name:        "full queue rejects a tile ranking after every pending tile",
+			capacity:    2,
+			pushes:      [][]entities.TileCoordinates{{queueTile(12, 1), queueTile(14, 1)}, {queueTile(14, 2), queueTile(16, 1)}},
+			wantDropped: []int{0, 2},
+			wantOrder:   []entities.TileCoordinates{queueTile(12, 1), queueTile(14, 1)},
+		},
+		{
+			name:          "full queue still coalesces pending tiles",
+			capacity:      2,
+			pushes:        [][]entities.TileCoordinates{{queueTile(12, 1), queueTile(14, 1)}, {queueTile(14, 1)}},
+			wantDropped:   []int{0, 0},
+			wantCoalesced: 1,
+			wantOrder:     []entities.TileCoordinates{queueTile(12, 1), queueTile(14, 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewTileWorkQueue(tt.capacity)
+
+			var dropped uint64
+			for i, tiles := range tt.pushes {
+				n := q.Push(tiles)
+				checkTileQueue(t, q)
+				if tt.wantDropped != nil && n != tt.wantDropped[i] {
+					t.Errorf("push %d dropped %d tiles, want %d", i, n, tt.wantDropped[i])
+				}
+				dropped += uint64(n)
+			}
+
+			stats := q.Stats()
+			if stats.Coalesced != tt.wantCoalesced {
+				t.Errorf("Coalesced = %d, want %d", stats.Coalesced, tt.wantCoalesced)
+			}
+			if stats.Dropped != dropped {
+				t.Errorf("Dropped = %d, want %d", stats.Dropped, dropped)
+			}
+			if stats.Pending != len(tt.wantOrder) {
+				t.Errorf("Pending = %d, want %d", stats.Pending, len(tt.wantOrder))
+			}
+
+			if order := popAll(t, q); !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("pop order = %v, want %v", order, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestTileWorkQueuePeek(t *testing.T) {
+	// Zoom levels interleaved so the heap is not already sorted
+	var tiles []entities.TileCoordinates
+	for x := 0; x < 20; x++ {
+		tiles = append(tiles, queueTile(18-(x*7)%13, x))
+	}
+
+	for _, limit := range []int{0, 1, 7, len(tiles), len(tiles) + 5} {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			q := NewTileWorkQueue(0)
+			q.Push(tiles)
+
+			peeked := q.Peek(limit)
+			if q.Len() != len(tiles) {
+				t.Fatalf("Peek removed tiles: %d pending, want %d", q.Len(), len(tiles))
+			}
+			checkTileQueue(t, q)
+
+			popped := popAll(t, q)
+			want := popped[:min(limit, len(popped))]
+			if len(peeked) != len(want) || (len(want) > 0 && !reflect.DeepEqual(peeked, want)) {
+				t.Errorf("Peek(%d) = %v, want %v", limit, peeked, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+	"bike-map/utils"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// TrailDetailsService combines a PocketBase trail record with the stats computed by PostGIS
+// (distance, bounds, endpoints, elevation) and its engagement, so clients get everything the
+// MVT feature attributes carry and more in a single request.
+type TrailDetailsService struct {
+	app        core.App
+	engagement interfaces.Engagement
+
+	// Computes the geometry stats, nil while PostGIS is unavailable
+	mu        sync.RWMutex
+	generator interfaces.MVTGenerator
+}
+
+// NewTrailDetailsService creates a new trail details service
+func NewTrailDetailsService(app core.App, engagement interfaces.Engagement) *TrailDetailsService {
+	return &TrailDetailsService{
+		app:        app,
+		engagement: engagement,
+	}
+}
+
+// SetGenerator sets the generator the geometry stats are read from
+func (s *TrailDetailsService) SetGenerator(generator interfaces.MVTGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generator = generator
+}
+
+// GetTrailDetails returns the details of a trail, with its elevation profile reduced to at most
+// profilePoints points. Stats are left out while PostGIS is unavailable or the trail is not synced yet.
+func (s *TrailDetailsService) GetTrailDetails(ctx context.Context, trailID string, profilePoints int) (*entities.TrailDetails, error) {
+	record, err := s.app.FindRecordById("trails", trailID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, interfaces.ErrTrailNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find trail: %w", err)
+	}
+
+	details := &entities.TrailDetails{
+		Record:           record.PublicExport(),
+		ElevationProfile: []entities.ElevationPoint{},
+	}
+
+	stats, err := s.engagement.GetEngagementStats(ctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+	distribution, err := s.engagement.GetRatingDistribution(ctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+	details.Ratings = entities.RatingSummary{
+		Average:      stats.RatingAvg,
+		Count:        stats.RatingCount,
+		Distribution: distribution,
+	}
+	details.CommentCount = stats.CommentCount
+
+	s.mu.RLock()
+	generator := s.generator
+	s.mu.RUnlock()
+	if generator == nil {
+		return details, nil
+	}
+
+	geometry, err := generator.GetTrailGeometry(ctx, trailID)
+	if err != nil {
+		// The PocketBase data is still useful without the computed stats
+		log.Printf("Failed to get geometry stats of trail %s: %v", trailID, err)
+		return details, nil
+	}
+	if geometry == nil {
+		return details, nil
+	}
+
+	details.Stats = trailStats(geometry)
+	if geometry.Elevation != nil && len(geometry.Elevation.Profile) > 0 {
+		details.ElevationProfile = utils.DownsampleProfile(geometry.Elevation.Profile, profilePoints)
+	}
+	return details, nil
+}
+
+// trailStats derives the stats of a trail from its geometry data, as generate_mvt_tile does
+func trailStats(geometry *entities.TrailGeometry) *entities.TrailStats {
+	stats := &entities.TrailStats{
+		DistanceM:  geometry.DistanceM,
+		BBox:       geometry.BBox,
+		Start:      geometry.Start,
+		End:        geometry.End,
+		PointCount: geometry.PointCount,
+	}
+	if geometry.Elevation == nil {
+		return stats
+	}
+
+	stats.ElevationGain = geometry.Elevation.Gain
+	stats.ElevationLoss = geometry.Elevation.Loss
+
+	profile := geometry.Elevation.Profile
+	if len(profile) == 0 {
+		return stats
+	}
+	minElevation, maxElevation := profile[0].Elevation, profile[0].Elevation
+	for _, p := range profile[1:] {
+		minElevation = min(minElevation, p.Elevation)
+		maxElevation = max(maxElevation, p.Elevation)
+	}
+	startElevation, endElevation := profile[0].Elevation, profile[len(profile)-1].Elevation
+	stats.MinElevation = &minElevation
+	stats.MaxElevation = &maxElevation
+	stats.StartElevation = &startElevation
+	stats.EndElevation = &endElevation
+
+	return stats
+}
+
+// Compile-time check to ensure TrailDetailsService implements interfaces.TrailDetails
+var _ interfaces.TrailDetails = (*TrailDetailsService)(nil)
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+)
+
+// TrailQueryService answers trail searches and area queries from PostGIS with the ratings of PocketBase.
+// PostGIS holds trail geometry and attributes only: the rating_average records are the
+// authoritative ratings, so they are passed to the queries and filled into the results.
+type TrailQueryService struct {
+	search     interfaces.TrailSearch
+	spatial    interfaces.TrailSpatial
+	engagement interfaces.Engagement
+}
+
+// NewTrailQueryService creates a new trail query service
+func NewTrailQueryService(search interfaces.TrailSearch, spatial interfaces.TrailSpatial, engagement interfaces.Engagement) *TrailQueryService {
+	return &TrailQueryService{
+		search:     search,
+		spatial:    spatial,
+		engagement: engagement,
+	}
+}
+
+// SearchTrails runs a trail search with the current ratings
+func (s *TrailQueryService) SearchTrails(ctx context.Context, query entities.TrailSearchQuery) (*entities.TrailSearchResults, error) {
+	// Ratings order equally ranked matches
+	if query.MinRating != nil || len(query.Terms) > 0 {
+		averages, err := s.ratingAverages(ctx)
+		if err != nil {
+			return nil, err
+		}
+		query.RatingAverages = averages
+	}
+
+	results, err := s.search.SearchTrails(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	trailIDs := make([]string, len(results.Results))
+	for i, r := range results.Results {
+		trailIDs[i] = r.ID
+	}
+	stats, err := s.engagementStats(ctx, trailIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results.Results {
+		if st := stats[results.Results[i].ID]; st != nil {
+			results.Results[i].RatingAverage = st.RatingAvg
+			results.Results[i].RatingCount = st.RatingCount
+		}
+	}
+
+	return results, nil
+}
+
+// FindTrails runs an area query with the current ratings
+func (s *TrailQueryService) FindTrails(ctx context.Context, query entities.TrailAreaQuery) (*entities.TrailSummaries, error) {
+	if query.MinRating != nil {
+		averages, err := s.ratingAverages(ctx)
+		if err != nil {
+			return nil, err
+		}
+		query.RatingAverages = averages
+	}
+
+	results, err := s.spatial.FindTrails(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	trailIDs := make([]string, len(results.Results))
+	for i, t := range results.Results {
+		trailIDs[i] = t.ID
+	}
+	stats, err := s.engagementStats(ctx, trailIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results.Results {
+		if st := stats[results.Results[i].ID]; st != nil {
+			results.Results[i].RatingAverage = st.RatingAvg
+			results.Results[i].RatingCount = st.RatingCount
+		}
+	}
+
+	return results, nil
+}
+
+// ratingAverages returns the rating average of every rated trail
+func (s *TrailQueryService) ratingAverages(ctx context.Context) (map[string]float64, error) {
+	records, err := s.engagement.GetRatingAverageRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	averages := make(map[string]float64, len(records))
+	for trailID, record := range records {
+		averages[trailID] = record.Average
+	}
+	return averages, nil
+}
+
+// engagementStats returns the engagement stats of a page of trails
+func (s *TrailQueryService) engagementStats(ctx context.Context, trailIDs []string) (map[string]*entities.EngagementStats, error) {
+	// An empty list would load the stats of every trail
+	if len(trailIDs) == 0 {
+		return map[string]*entities.EngagementStats{}, nil
+	}
+	return s.engagement.GetEngagementStatsBulk(ctx, trailIDs)
+}
+
+// Compile-time checks to ensure TrailQueryService implements the trail query interfaces
+var (
+	_ interfaces.TrailSearch  = (*TrailQueryService)(nil)
+	_ interfaces.TrailSpatial = (*TrailQueryService)(nil)
+)
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bike-map/entities"
+	"bike-map/interfaces"
+
+	"github.com/lib/pq"
+)
+
+// SearchTrails runs a full-text search over trail names, tags and descriptions, combined with
+// the query filters. Every term must match in at least one language, the last one as a prefix
+// so partial input can be completed. Without terms, matching trails are listed by name.
+// Equally ranked matches are ordered by query.RatingAverages; results carry no ratings.
+func (p *MVTGeneratorPostgis) SearchTrails(ctx context.Context, query entities.TrailSearchQuery) (*entities.TrailSearchResults, error) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	from := "trails t"
+	rank := "0"
+	order := "t.name, t.id"
+	if len(query.Terms) > 0 {
+		parts := make([]string, len(query.Terms))
+		for i, term := range query.Terms {
+			if i == len(query.Terms)-1 {
+				term += ":*"
+			}
+			parts[i] = "trail_search_term(" + arg(term) + ")"
+		}
+		from = "trails t" + ratingJoin(query.RatingAverages, arg) + ", (SELECT " + strings.Join(parts, " && ") + " AS query) q"
+		where = append(where, "t.search_vector @@ q.query")
+		rank = "ts_rank_cd(t.search_vector, q.query)"
+		order = "rank DESC, COALESCE(r.average, 0) DESC, t.name, t.id"
+	}
+
+	where = append(where, trailFilterConditions(query.TrailFilters, arg)...)
+
+	sqlQuery := `
+		SELECT t.id, t.name, t.level, COALESCE(t.tags, '[]'::jsonb),
+			COALESCE(t.distance_m, 0),
+			COALESCE((t.elevation_data->>'gain')::REAL, 0),
+			COALESCE((t.elevation_data->>'loss')::REAL, 0),
+			COALESCE(t.ridden, false),
+			` + rank + ` AS rank,
+			ST_YMax(t.bbox), ST_YMin(t.bbox), ST_XMax(t.bbox), ST_XMin(t.bbox),
+			COUNT(*) OVER () AS total
+		FROM ` + from
+	if len(where) > 0 {
+		sqlQuery += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	sqlQuery += "\n\t\tORDER BY " + order + "\n\t\tLIMIT " + arg(query.Limit) + " OFFSET " + arg(query.Offset)
+
+	rows, err := p.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search trails: %w", err)
+	}
+	defer rows.Close()
+
+	results := &entities.TrailSearchResults{Results: []entities.TrailSearchResult{}}
+	for rows.Next() {
+		var (
+			r                        entities.TrailSearchResult
+			tags                     []byte
+			north, south, east, west sql.NullFloat64
+		)
+		err := rows.Scan(&r.ID, &r.Name, &r.Level, &tags,
+			&r.DistanceM, &r.ElevationGain, &r.ElevationLoss,
+			&r.Ridden, &r.Rank,
+			&north, &south, &east, &west, &results.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+
+		r.Tags = parseTrailTags(tags)
+		if north.Valid {
+			r.BBox = &entities.BoundingBox{North: north.Float64, South: south.Float64, East: east.Float64, West: west.Float64}
+		}
+		results.Results = append(results.Results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// FindTrails returns the trails within a radius of a point and/or matching the filters.
+// Distances to the point are geodesic, in meters. Trails without geometry are left out.
+// Results carry no ratings.
+func (p *MVTGeneratorPostgis) FindTrails(ctx context.Context, query entities.TrailAreaQuery) (*entities.TrailSummaries, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"t.geom IS NOT NULL"}
+	from := "trails t"
+	distances := "NULL::FLOAT8, NULL::FLOAT8, NULL::FLOAT8, NULL::FLOAT8"
+	order := "t.name, t.id"
+	if query.Point != nil {
+		from = "trails t, (SELECT ST_SetSRID(ST_MakePoint(" + arg(query.Point.Lng) + ", " + arg(query.Point.Lat) + "), 4326) AS pt) ref"
+		distances = `ST_Distance(t.geom::geography, ref.pt::geography) AS distance_to_trail,
+			ST_Distance(ST_StartPoint(t.geom)::geography, ref.pt::geography) AS distance_to_trailhead,
+			ST_Y(ST_ClosestPoint(t.geom, ref.pt)), ST_X(ST_ClosestPoint(t.geom, ref.pt))`
+		if query.RadiusM > 0 {
+			where = append(where, "ST_DWithin(t.geom::geography, ref.pt::geography, "+arg(query.RadiusM)+")")
+		}
+		order = "distance_to_trail, t.id"
+		if query.SortByTrailhead {
+			order = "distance_to_trailhead, t.id"
+		}
+	}
+
+	where = append(where, trailFilterConditions(query.TrailFilters, arg)...)
+
+	sqlQuery := `
+		SELECT t.id, t.name, t.level,
+			COALESCE(t.distance_m, 0),
+			COALESCE((t.elevation_data->>'gain')::REAL, 0),
+			ST_Y(ST_StartPoint(t.geom)), ST_X(ST_StartPoint(t.geom)),
+			` + distances + `,
+			COUNT(*) OVER () AS total
+		FROM ` + from + `
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ` + order + `
+		LIMIT ` + arg(query.Limit) + ` OFFSET ` + arg(query.Offset)
+
+	rows, err := p.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find trails: %w", err)
+	}
+	defer rows.Close()
+
+	results := &entities.TrailSummaries{Results: []entities.TrailSummary{}}
+	for rows.Next() {
+		var (
+			t                                      entities.TrailSummary
+			startLat, startLng                     float64
+			toTrail, toTrailhead, nearLat, nearLng sql.NullFloat64
+		)
+		err := rows.Scan(&t.ID, &t.Name, &t.Level,
+			&t.DistanceM, &t.ElevationGain,
+			&startLat, &startLng,
+			&toTrail, &toTrailhead, &nearLat, &nearLng,
+			&results.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trail summary: %w", err)
+		}
+
+		t.Trailhead = &entities.GeoPoint{Lat: startLat, Lng: startLng}
+		if toTrail.Valid {
+			t.DistanceToTrailM = &toTrail.Float64
+			t.DistanceToTrailheadM = &toTrailhead.Float64
+			t.NearestPoint = &entities.GeoPoint{Lat: nearLat.Float64, Lng: nearLng.Float64}
+		}
+		results.Results = append(results.Results, t)
+	}
+
+	return results, rows.Err()
+}
+
+// trailFilterConditions returns the SQL conditions of the filters on trails aliased as t.
+// MinRating is matched against filters.RatingAverages. arg binds a value and returns its placeholder.
+func trailFilterConditions(filters entities.TrailFilters, arg func(v any) string) []string {
+	var where []string
+	if len(filters.Levels) > 0 {
+		where = append(where, "t.level = ANY("+arg(pq.Array(filters.Levels))+")")
+	}
+	if filters.MinDistance != nil {
+		where = append(where, "t.distance_m >= "+arg(*filters.MinDistance))
+	}
+	if filters.MaxDistance != nil {
+		where = append(where, "t.distance_m <= "+arg(*filters.MaxDistance))
+	}
+	if filters.MinGain != nil {
+		where = append(where, "COALESCE((t.elevation_data->>'gain')::REAL, 0) >= "+arg(*filters.MinGain))
+	}
+	if filters.MaxGain != nil {
+		where = append(where, "COALESCE((t.elevation_data->>'gain')::REAL, 0) <= "+arg(*filters.MaxGain))
+	}
+	if filters.MinRating != nil {
+		trailIDs := []string{}
+		for trailID, average := range filters.RatingAverages {
+			if average >= *filters.MinRating {
+				trailIDs = append(trailIDs, trailID)
+			}
+		}
+		where = append(where, "t.id = ANY("+arg(pq.Array(trailIDs))+")")
+	}
+	if filters.BBox != nil {
+		where = append(where, fmt.Sprintf("t.bbox && ST_MakeEnvelope(%s, %s, %s, %s, 4326)",
+			arg(filters.BBox.West), arg(filters.BBox.South), arg(filters.BBox.East), arg(filters.BBox.North)))
+	}
+
+	return where
+}
+
+// ratingJoin joins the rating averages to trails aliased as t, as r.average (NULL for unrated trails)
+func ratingJoin(averages map[string]float64, arg func(v any) string) string {
+	trailIDs := make([]string, 0, len(averages))
+	values := make([]float64, 0, len(averages))
+	for trailID, average := range averages {
+		trailIDs = append(trailIDs, trailID)
+		values = append(values, average)
+	}
+	return " LEFT JOIN unnest(" + arg(pq.Array(trailIDs)) + "::TEXT[], " + arg(pq.Array(values)) + "::FLOAT8[]) AS r(trail_id, average)" +
+		" ON r.trail_id = t.id"
+}
+
+// parseTrailTags decodes the JSON tag array of a trail, ignoring malformed tags
+func parseTrailTags(data []byte) []string {
+	tags := []string{}
+	if err := json.Unmarshal(data, &tags); err != nil || tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// Compile-time checks to ensure MVTGeneratorPostgis implements the trail query interfaces
+var (
+	_ interfaces.TrailSearch  = (*MVTGeneratorPostgis)(nil)
+	_ interfaces.TrailSpatial = (*MVTGeneratorPostgis)(nil)
+)
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"math"
+
+	"bike-map/entities"
+)
+
+// DownsampleProfile reduces an elevation profile to at most maxPoints points with the
+// largest-triangle-three-buckets algorithm, which keeps the first and last points and the
+// peaks and valleys that shape the chart. Profiles that are already small enough are returned as is.
+func DownsampleProfile(profile []entities.ElevationPoint, maxPoints int) []entities.ElevationPoint {
+	if maxPoints < 3 || len(profile) <= maxPoints {
+		return profile
+	}
+
+	sampled := make([]entities.ElevationPoint, 0, maxPoints)
+	sampled = append(sampled, profile[0])
+
+	// The points between the first and last one are split into maxPoints-2 buckets
+	bucketSize := float64(len(profile)-2) / float64(maxPoints-2)
+	selected := 0
+
+	for i := 0; i < maxPoints-2; i++ {
+		start := int(float64(i)*bucketSize) + 1
+		end := int(float64(i+1)*bucketSize) + 1
+
+		// Average of the next bucket, the third corner of the triangles
+		nextStart, nextEnd := end, min(int(float64(i+2)*bucketSize)+1, len(profile))
+		if i == maxPoints-3 {
+			nextStart, nextEnd = len(profile)-1, len(profile)
+		}
+		var avgDistance, avgElevation float64
+		for _, p := range profile[nextStart:nextEnd] {
+			avgDistance += p.Distance
+			avgElevation += p.Elevation
+		}
+		n := float64(nextEnd - nextStart)
+		avgDistance /= n
+		avgElevation /= n
+
+		// Keep the point forming the largest triangle with the last kept point and the next average
+		a := profile[selected]
+		maxArea := -1.0
+		for j := start; j < end; j++ {
+			p := profile[j]
+			area := math.Abs((a.Distance-avgDistance)*(p.Elevation-a.Elevation) - (a.Distance-p.Distance)*(avgElevation-a.Elevation))
+			if area > maxArea {
+				maxArea = area
+				selected = j
+			}
+		}
+		sampled = append(sampled, profile[selected])
+	}
+
+	return append(sampled, profile[len(profile)-1])
+}
@@ -13,6 +13,7 @@ import (
 type parsedGPXData struct {
 	LineStringWKT string
 	ElevationData *entities.ElevationData
+	Points        []entities.TrackPoint // Points of the first track, all segments joined
 }
 
 // parseGPXFile parses GPX data and returns structured data ready for PostGIS insertion
@@ -48,6 +49,7 @@ func ParseGPXFile(data []byte) (*parsedGPXData, error) {
 	return &parsedGPXData{
 		LineStringWKT: lineString,
 		ElevationData: elevationData,
+		Points:        allPoints,
 	}, nil
 }
 
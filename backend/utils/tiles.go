@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"math"
+
+	"bike-map/entities"
+)
+
+// TileRange is an inclusive range of XYZ tile columns and rows at one zoom level
+type TileRange struct {
+	Z          int
+	MinX, MaxX int
+	MinY, MaxY int
+}
+
+// Contains reports whether the tile lies within the range
+func (r TileRange) Contains(c entities.TileCoordinates) bool {
+	return c.Z == r.Z && c.X >= r.MinX && c.X <= r.MaxX && c.Y >= r.MinY && c.Y <= r.MaxY
+}
+
+// Count returns the number of tiles in the range
+func (r TileRange) Count() int {
+	return (r.MaxX - r.MinX + 1) * (r.MaxY - r.MinY + 1)
+}
+
+// TileRangeForBBox returns the tiles covering a WGS84 bounding box at zoom level z
+func TileRangeForBBox(bbox entities.BoundingBox, z int) TileRange {
+	minX, minY := LonLatToTile(bbox.West, bbox.North, z)
+	maxX, maxY := LonLatToTile(bbox.East, bbox.South, z)
+	return TileRange{Z: z, MinX: minX, MaxX: maxX, MinY: minY, MaxY: maxY}
+}
+
+// LonLatToTile returns the XYZ tile containing a WGS84 point at zoom level z
+func LonLatToTile(lon, lat float64, z int) (x, y int) {
+	n := float64(int(1) << z)
+
+	// Clamp to the Web Mercator validity range
+	lat = math.Max(math.Min(lat, 85.0511), -85.0511)
+	lon = math.Max(math.Min(lon, 180), -180)
+
+	latRad := lat * math.Pi / 180
+	x = int(math.Floor((lon + 180) / 360 * n))
+	y = int(math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n))
+
+	maxIndex := int(n) - 1
+	return min(max(x, 0), maxIndex), min(max(y, 0), maxIndex)
+}
+
+// TileBounds returns the WGS84 bounding box of an XYZ tile
+func TileBounds(c entities.TileCoordinates) entities.BoundingBox {
+	n := float64(int(1) << c.Z)
+	return entities.BoundingBox{
+		West:  float64(c.X)/n*360 - 180,
+		East:  float64(c.X+1)/n*360 - 180,
+		North: tileRowToLat(c.Y, n),
+		South: tileRowToLat(c.Y+1, n),
+	}
+}
+
+// tileRowToLat returns the latitude of the top edge of a tile row
+func tileRowToLat(y int, n float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+}
@@ -71,6 +71,7 @@ const AppContent: React.FC = () => {
     error,
     mapMoveEndTrigger,
     mvtRefreshTrigger,
+    engagementRefreshTrigger,
     
     // Methods
     updateVisibleTrails,
@@ -89,7 +90,8 @@ const AppContent: React.FC = () => {
     getGpxContent,
     getPreviousGpxContent,
     clearError,
-    incrementMapMoveTrigger
+    incrementMapMoveTrigger,
+    applyEngagementStats
   } = useAppContext();
 
   // Handle trail creation
@@ -297,6 +299,8 @@ const AppContent: React.FC = () => {
         onTrailsLoaded={updateVisibleTrailsFromMVT}
         onMapMoveEnd={handleMapMoveEnd}
         refreshTrigger={mvtRefreshTrigger}
+        engagementRefreshTrigger={engagementRefreshTrigger}
+        onEngagementStatsLoaded={applyEngagementStats}
         fitBoundsTarget={fitBoundsTarget}
         isDrawingActive={isDrawingActive}
         onRouteComplete={drawingMode === 'edit' ? handleEditRouteComplete : handleRouteComplete}
@@ -1,7 +1,7 @@
 import React, { useEffect, useCallback, useRef } from 'react';
 import { MapContainer, TileLayer, useMap } from 'react-leaflet';
 import L from 'leaflet';
-import { MapBounds, MVTTrail } from '../types';
+import { MapBounds, MVTTrail, EngagementStats } from '../types';
 import { setupLeafletCompatibility } from '../utils/browserCompat';
 import { MVTTrailService } from '../services/mvtTrails';
 import RouteDrawer from './RouteDrawer';
@@ -26,6 +26,8 @@ interface MapProps {
   onTrailsLoaded?: (trails: MVTTrail[]) => void;
   onMapMoveEnd?: () => void;
   refreshTrigger?: number; // Increment this to trigger MVT refresh
+  engagementRefreshTrigger?: number; // Increment this to refetch engagement stats only
+  onEngagementStatsLoaded?: (stats: Record<string, EngagementStats>) => void;
   fitBoundsTarget?: MapBounds | null; // Bounds to fit the map to
   isDrawingActive?: boolean;
   onRouteComplete?: (gpxContent: string) => void;
@@ -149,13 +151,17 @@ function MVTTrailLayer({
   onTrailClick,
   onTrailsLoaded,
   isDrawingActive,
-  refreshTrigger
+  refreshTrigger,
+  engagementRefreshTrigger,
+  onEngagementStatsLoaded
 }: {
   selectedTrail: MVTTrail | null;
   onTrailClick: (trail: MVTTrail | null) => void;
   onTrailsLoaded?: (trails: MVTTrail[]) => void;
   isDrawingActive?: boolean;
   refreshTrigger?: number;
+  engagementRefreshTrigger?: number;
+  onEngagementStatsLoaded?: (stats: Record<string, EngagementStats>) => void;
 }) {
   const map = useMap();
   const mvtServiceRef = useRef<MVTTrailService | null>(null);
@@ -167,6 +173,7 @@ function MVTTrailLayer({
       mvtServiceRef.current.setEvents({
         onTrailClick: onTrailClick,
         onTrailsLoaded: onTrailsLoaded,
+        onEngagementStatsLoaded: onEngagementStatsLoaded,
       });
     }
 
@@ -198,6 +205,13 @@ function MVTTrailLayer({
     }
   }, [refreshTrigger]);
 
+  // Refetch engagement stats without reloading tiles
+  useEffect(() => {
+    if (engagementRefreshTrigger && mvtServiceRef.current) {
+      mvtServiceRef.current.refreshEngagementStats();
+    }
+  }, [engagementRefreshTrigger]);
+
   return null;
 }
 
@@ -208,6 +222,8 @@ export default function Map({
   onTrailsLoaded,
   onMapMoveEnd,
   refreshTrigger,
+  engagementRefreshTrigger,
+  onEngagementStatsLoaded,
   fitBoundsTarget,
   isDrawingActive = false,
   onRouteComplete,
@@ -269,6 +285,8 @@ export default function Map({
         onTrailsLoaded={onTrailsLoaded}
         isDrawingActive={isDrawingActive}
         refreshTrigger={refreshTrigger}
+        engagementRefreshTrigger={engagementRefreshTrigger}
+        onEngagementStatsLoaded={onEngagementStatsLoaded}
       />
 
       {/* Route drawer */}
@@ -22,8 +22,8 @@ export const RatingsCommentsModal: React.FC<RatingsCommentsModalProps> = ({
   const [comments, setComments] = useState<TrailCommentWithUser[]>([]);
   const [ratingStats, setRatingStats] = useState<RatingStats>({ count: 0, average: 0 });
   
-  // Access app context for MVT refresh
-  const { refreshMVTLayer } = useAppContext();
+  // Access app context for engagement stats refresh
+  const { refreshEngagementStats } = useAppContext();
   const [userRating, setUserRating] = useState<number>(0);
   const [newComment, setNewComment] = useState('');
   const [editingComment, setEditingComment] = useState<string | null>(null);
@@ -76,8 +76,8 @@ export const RatingsCommentsModal: React.FC<RatingsCommentsModalProps> = ({
       await PocketBaseService.upsertTrailRating(trail.id, rating);
       await loadData(false); // Refresh data without loading state
       
-      // Refresh MVT layer to update engagement data
-      refreshMVTLayer();
+      // Refresh engagement stats (tiles are not regenerated for ratings or comments)
+      refreshEngagementStats();
     } catch (error) {
       console.error('Failed to update rating:', error);
     } finally {
@@ -95,8 +95,8 @@ export const RatingsCommentsModal: React.FC<RatingsCommentsModalProps> = ({
       setNewComment('');
       await loadData(false); // Refresh data without loading state
       
-      // Refresh MVT layer to update engagement data
-      refreshMVTLayer();
+      // Refresh engagement stats (tiles are not regenerated for ratings or comments)
+      refreshEngagementStats();
     } catch (error) {
       console.error('Failed to create comment:', error);
     } finally {
@@ -119,8 +119,8 @@ export const RatingsCommentsModal: React.FC<RatingsCommentsModalProps> = ({
       setEditingCommentText('');
       await loadData(false); // Refresh data without loading state
       
-      // Refresh MVT layer to update engagement data
-        refreshMVTLayer();
+      // Refresh engagement stats (tiles are not regenerated for ratings or comments)
+        refreshEngagementStats();
     } catch (error) {
       console.error('Failed to update comment:', error);
     } finally {
@@ -135,8 +135,8 @@ export const RatingsCommentsModal: React.FC<RatingsCommentsModalProps> = ({
         await PocketBaseService.deleteTrailComment(commentId);
         await loadData(false); // Refresh data without loading state
         
-        // Refresh MVT layer to update engagement data
-        refreshMVTLayer();
+        // Refresh engagement stats (tiles are not regenerated for ratings or comments)
+        refreshEngagementStats();
       } catch (error) {
         console.error('Failed to delete comment:', error);
       } finally {
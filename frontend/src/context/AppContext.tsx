@@ -4,7 +4,13 @@ import React, {
   useCallback,
   useEffect,
 } from "react";
-import { User, MapBounds, Trail, MVTTrail } from "../types";
+import {
+  User,
+  MapBounds,
+  Trail,
+  MVTTrail,
+  EngagementStats,
+} from "../types";
 import { PocketBaseService } from "../services/pocketbase";
 import { handleApiError, getErrorMessage } from "../utils/errorHandling";
 
@@ -33,6 +39,7 @@ interface AppState {
   error: string;
   mapMoveEndTrigger: number;
   mvtRefreshTrigger: number;
+  engagementRefreshTrigger: number;
 }
 
 type AppAction =
@@ -45,6 +52,7 @@ type AppAction =
   | { type: "SET_SELECTED_TRAIL"; payload: MVTTrail | null }
   | { type: "SET_MAP_BOUNDS"; payload: MapBounds | null }
   | { type: "FIT_MAP_TO_BOUNDS"; payload: MapBounds | null }
+  | { type: "APPLY_ENGAGEMENT_STATS"; payload: Record<string, EngagementStats> }
 
   // UI actions
   | { type: "SET_UPLOAD_PANEL_VISIBLE"; payload: boolean }
@@ -64,7 +72,8 @@ type AppAction =
   | { type: "SET_ERROR"; payload: string }
   | { type: "CLEAR_ERROR" }
   | { type: "INCREMENT_MAP_MOVE_TRIGGER" }
-  | { type: "INCREMENT_MVT_REFRESH_TRIGGER" };
+  | { type: "INCREMENT_MVT_REFRESH_TRIGGER" }
+  | { type: "INCREMENT_ENGAGEMENT_REFRESH_TRIGGER" };
 
 const initialState: AppState = {
   // Auth state
@@ -91,6 +100,7 @@ const initialState: AppState = {
   error: "",
   mapMoveEndTrigger: 0,
   mvtRefreshTrigger: 0,
+  engagementRefreshTrigger: 0,
 };
 
 function appReducer(state: AppState, action: AppAction): AppState {
@@ -101,6 +111,21 @@ function appReducer(state: AppState, action: AppAction): AppState {
     case "SET_AUTH_LOADING":
       return { ...state, isAuthLoading: action.payload };
 
+    case "APPLY_ENGAGEMENT_STATS": {
+      // Engagement stats change without tile reloads, patch trails in place
+      const stats = action.payload;
+      const patch = (trail: MVTTrail): MVTTrail =>
+        stats[trail.id] ? { ...trail, ...stats[trail.id] } : trail;
+
+      return {
+        ...state,
+        visibleTrails: state.visibleTrails.map(patch),
+        selectedTrail: state.selectedTrail
+          ? patch(state.selectedTrail)
+          : null,
+      };
+    }
+
     case "SET_VISIBLE_TRAILS": {
       // Optimize: only update if trail IDs actually changed
       const currentIds = new Set(state.visibleTrails.map((t) => t.id));
@@ -171,6 +196,11 @@ function appReducer(state: AppState, action: AppAction): AppState {
       return { ...state, mapMoveEndTrigger: state.mapMoveEndTrigger + 1 };
     case "INCREMENT_MVT_REFRESH_TRIGGER":
       return { ...state, mvtRefreshTrigger: state.mvtRefreshTrigger + 1 };
+    case "INCREMENT_ENGAGEMENT_REFRESH_TRIGGER":
+      return {
+        ...state,
+        engagementRefreshTrigger: state.engagementRefreshTrigger + 1,
+      };
 
     default:
       return state;
@@ -210,6 +240,8 @@ interface AppContextValue extends AppState {
   clearError: () => void;
   incrementMapMoveTrigger: () => void;
   refreshMVTLayer: () => void;
+  refreshEngagementStats: () => void;
+  applyEngagementStats: (stats: Record<string, EngagementStats>) => void;
 }
 
 export const AppContext = createContext<AppContextValue | undefined>(undefined);
@@ -481,6 +513,17 @@ export const AppProvider: React.FC<{ children: React.ReactNode }> = ({
     dispatch({ type: "INCREMENT_MVT_REFRESH_TRIGGER" });
   }, []);
 
+  const refreshEngagementStats = useCallback(() => {
+    dispatch({ type: "INCREMENT_ENGAGEMENT_REFRESH_TRIGGER" });
+  }, []);
+
+  const applyEngagementStats = useCallback(
+    (stats: Record<string, EngagementStats>) => {
+      dispatch({ type: "APPLY_ENGAGEMENT_STATS", payload: stats });
+    },
+    [],
+  );
+
   const contextValue: AppContextValue = {
     ...state,
     login,
@@ -506,6 +549,8 @@ export const AppProvider: React.FC<{ children: React.ReactNode }> = ({
     clearError,
     incrementMapMoveTrigger,
     refreshMVTLayer,
+    refreshEngagementStats,
+    applyEngagementStats,
   };
 
   return (
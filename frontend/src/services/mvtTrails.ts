@@ -1,14 +1,27 @@
 import L from "leaflet";
 import "leaflet.vectorgrid";
-import { MVTTrailProperties, MVTTrail, MapBounds } from "../types";
+import {
+  MVTTrailProperties,
+  MVTTrail,
+  MapBounds,
+  EngagementStats,
+  TileEvent,
+} from "../types";
 import { getLevelColor } from "../utils/colors";
 
 export interface MVTTrailEvents {
   onTrailClick?: (trail: MVTTrail) => void;
   onTrailsLoaded?: (trails: MVTTrail[]) => void;
   onTileLoad?: () => void;
+  onEngagementStatsLoaded?: (stats: Record<string, EngagementStats>) => void;
 }
 
+const EMPTY_ENGAGEMENT_STATS: EngagementStats = {
+  rating_average: 0,
+  rating_count: 0,
+  comment_count: 0,
+};
+
 // Convert MVT properties to MVTTrail interface
 export function convertMVTPropertiesToTrail(
   props: MVTTrailProperties,
@@ -51,10 +64,8 @@ export function convertMVTPropertiesToTrail(
       lng: props.end_lng,
     },
 
-    // Engagement data
-    rating_average: props.rating_average,
-    rating_count: props.rating_count,
-    comment_count: props.comment_count,
+    // Engagement data is not in tiles, it is overlaid from the engagement stats
+    ...EMPTY_ENGAGEMENT_STATS,
 
     // Ridden status
     ridden: props.ridden,
@@ -66,10 +77,15 @@ export class MVTTrailService {
   private mvtLayer: any | null = null; // L.Layer
   private selectedTrailId: string | null = null; // Track currently selected trail
   private loadedTrails = new Map<string, MVTTrail>();
+  private engagementStats = new Map<string, EngagementStats>(); // Served separately from tiles
+  private engagementStatsLoaded = false;
   private trailMarkers = new Map<string, { start: any; end: any }>(); // L.Marker
   private events: MVTTrailEvents = {};
   private baseUrl: string;
   private cacheVersion: string = ""; // Persistent cache version for all requests
+  private tileVersions = new Map<string, string>(); // Per-tile cache versions for regenerated tiles ("z/x/y")
+  private tileEvents: EventSource | null = null;
+  private tileEventsFailed = false; // Events may have been missed while disconnected
   private updateMarkersTimeout: number | null = null; // Debounce timeout
 
   constructor(map: any, baseUrl?: string) {
@@ -94,6 +110,7 @@ export class MVTTrailService {
   // Generate a new cache version for cache busting
   private generateCacheVersion(): void {
     this.cacheVersion = `v${Date.now()}`;
+    this.tileVersions.clear();
   }
 
   // Calculate marker size and visibility based on zoom level
@@ -138,14 +155,16 @@ export class MVTTrailService {
   }
 
   createMVTLayer(): any {
-    // Add cache version to all tile requests
-    const url = `${this.baseUrl}/api/tiles/{z}/{x}/{y}.mvt?cache=${this.cacheVersion}`;
+    // Add cache version to all tile requests, {cacheKey} is resolved per tile below
+    const url = `${this.baseUrl}/api/tiles/{z}/{x}/{y}.mvt?cache={cacheKey}`;
 
     const layer = L.vectorGrid.protobuf(url, {
       vectorTileLayerStyles: {
         trails: (properties: MVTTrailProperties) => {
           // Convert and store trail data
-          const trail = convertMVTPropertiesToTrail(properties);
+          const trail = this.withEngagementStats(
+            convertMVTPropertiesToTrail(properties),
+          );
           this.loadedTrails.set(trail.id, trail);
 
           // Create markers for this trail (must be done in styling function)
@@ -171,12 +190,19 @@ export class MVTTrailService {
       interactive: true,
       maxZoom: 18,
       attribution: "",
+      // Leaflet calls function values of the URL template with the tile coordinates:
+      // give each tile its own version so regenerated tiles bypass the HTTP cache
+      cacheKey: (data: { x: number; y: number; z: number }) =>
+        this.tileVersions.get(`${data.z}/${data.x}/${data.y}`) ||
+        this.cacheVersion,
     });
 
     // Handle trail clicks
     (layer as any).on("click", (e: any) => {
       if (e.layer && e.layer.properties) {
-        const trail = convertMVTPropertiesToTrail(e.layer.properties);
+        const trail = this.withEngagementStats(
+          convertMVTPropertiesToTrail(e.layer.properties),
+        );
         this.events.onTrailClick?.(trail);
       }
     });
@@ -196,6 +222,54 @@ export class MVTTrailService {
     return layer;
   }
 
+  // Overlay the latest engagement stats on a trail decoded from tiles
+  private withEngagementStats(trail: MVTTrail): MVTTrail {
+    // Until stats are loaded, trails show no ratings or comments
+    if (!this.engagementStatsLoaded) {
+      return trail;
+    }
+    return { ...trail, ...this.getEngagementStats(trail.id) };
+  }
+
+  private getEngagementStats(trailId: string): EngagementStats {
+    // Trails missing from the stats response have no ratings or comments
+    return this.engagementStats.get(trailId) || EMPTY_ENGAGEMENT_STATS;
+  }
+
+  // Fetch engagement stats for all trails. Ratings and comments no longer regenerate
+  // tiles, so this is how they reach the map without reloading the tile layer.
+  async refreshEngagementStats(): Promise<void> {
+    try {
+      const response = await fetch(`${this.baseUrl}/api/engagement/stats`);
+      if (!response.ok) {
+        throw new Error(`HTTP ${response.status}`);
+      }
+      const body: { stats: Record<string, EngagementStats> } =
+        await response.json();
+
+      this.engagementStats.clear();
+      Object.entries(body.stats || {}).forEach(([trailId, stats]) => {
+        this.engagementStats.set(trailId, {
+          rating_average: stats.rating_average,
+          rating_count: stats.rating_count,
+          comment_count: stats.comment_count,
+        });
+      });
+      this.engagementStatsLoaded = true;
+
+      const updated: Record<string, EngagementStats> = {};
+      this.loadedTrails.forEach((trail, trailId) => {
+        const stats = this.getEngagementStats(trailId);
+        this.loadedTrails.set(trailId, { ...trail, ...stats });
+        updated[trailId] = stats;
+      });
+
+      this.events.onEngagementStatsLoaded?.(updated);
+    } catch (error) {
+      console.warn("Failed to load engagement stats:", error);
+    }
+  }
+
   private createTrailMarkers(trail: MVTTrail) {
     // Prevent duplicate markers
     if (this.trailMarkers.has(trail.id)) {
@@ -241,7 +315,8 @@ export class MVTTrailService {
 
     // Add click handlers to markers for trail selection
     const handleMarkerClick = () => {
-      this.events.onTrailClick?.(trail);
+      // Prefer the loaded copy, which carries the latest engagement stats
+      this.events.onTrailClick?.(this.loadedTrails.get(trail.id) || trail);
     };
 
     startMarker.on("click", handleMarkerClick);
@@ -258,6 +333,8 @@ export class MVTTrailService {
 
     this.mvtLayer = this.createMVTLayer();
     this.map.addLayer(this.mvtLayer);
+    this.refreshEngagementStats();
+    this.connectTileEvents();
   }
 
   removeFromMap(): void {
@@ -265,6 +342,8 @@ export class MVTTrailService {
       this.map.removeLayer(this.mvtLayer);
     }
 
+    this.disconnectTileEvents();
+
     // Remove all markers
     this.trailMarkers.forEach(({ start, end }) => {
       this.map.removeLayer(start);
@@ -284,6 +363,137 @@ export class MVTTrailService {
     this.selectedTrailId = null;
   }
 
+  // Listen for tile invalidations pushed by the server, so edits by other users show up
+  // without reloading the whole layer
+  private connectTileEvents(): void {
+    if (this.tileEvents || typeof EventSource === "undefined") {
+      return;
+    }
+
+    const source = new EventSource(`${this.baseUrl}/api/tiles/events`);
+
+    source.onopen = () => {
+      // Events are not replayed after a disconnect: reload everything once
+      if (this.tileEventsFailed) {
+        this.tileEventsFailed = false;
+        this.refreshMVTLayer();
+      }
+    };
+
+    source.onerror = () => {
+      // EventSource reconnects on its own
+      this.tileEventsFailed = true;
+    };
+
+    source.addEventListener("tiles_ready", (e) => {
+      const event: TileEvent = JSON.parse((e as MessageEvent).data);
+      if (event.bbox) {
+        this.reloadArea(event.bbox);
+      } else {
+        this.reloadTiles(event.tiles || []);
+      }
+    });
+
+    source.addEventListener("trail_changed", (e) => {
+      const event: TileEvent = JSON.parse((e as MessageEvent).data);
+      if (!event.trail_id) {
+        return;
+      }
+      if (event.action === "deleted") {
+        this.forgetTrail(event.trail_id);
+      } else {
+        // Markers are only created once per trail: let reloaded tiles place them again
+        this.removeTrailMarkers(event.trail_id);
+      }
+    });
+
+    this.tileEvents = source;
+  }
+
+  private disconnectTileEvents(): void {
+    this.tileEvents?.close();
+    this.tileEvents = null;
+    this.tileEventsFailed = false;
+  }
+
+  // Refetch the layer if a regenerated tile is currently displayed. Other tiles pick up
+  // their new version the next time they are loaded.
+  private reloadTiles(tiles: { x: number; y: number; z: number }[]): void {
+    const version = `v${Date.now()}`;
+    tiles.forEach(({ x, y, z }) => {
+      this.tileVersions.set(`${z}/${x}/${y}`, version);
+    });
+
+    const visible = this.visibleTileRange();
+    const displayed = tiles.some(
+      ({ x, y, z }) =>
+        z === visible.z &&
+        x >= visible.minX &&
+        x <= visible.maxX &&
+        y >= visible.minY &&
+        y <= visible.maxY,
+    );
+    if (displayed) {
+      this.mvtLayer?.redraw();
+    }
+  }
+
+  // Refetch every tile after a batch of regenerated tiles too large to list, if it
+  // touches the displayed area
+  private reloadArea(bbox: {
+    north: number;
+    south: number;
+    east: number;
+    west: number;
+  }): void {
+    this.generateCacheVersion();
+
+    const bounds = L.latLngBounds(
+      [bbox.south, bbox.west],
+      [bbox.north, bbox.east],
+    );
+    if (bounds.intersects(this.map.getBounds())) {
+      this.mvtLayer?.redraw();
+    }
+  }
+
+  // Range of the 256px tiles covering the map view at the current zoom
+  private visibleTileRange(): {
+    z: number;
+    minX: number;
+    maxX: number;
+    minY: number;
+    maxY: number;
+  } {
+    const pixels = this.map.getPixelBounds();
+    const min = pixels.min ?? L.point(0, 0);
+    const max = pixels.max ?? L.point(0, 0);
+    return {
+      z: Math.round(this.map.getZoom()),
+      minX: Math.floor(min.x / 256),
+      maxX: Math.floor(max.x / 256),
+      minY: Math.floor(min.y / 256),
+      maxY: Math.floor(max.y / 256),
+    };
+  }
+
+  // Drop a deleted trail and its markers
+  private forgetTrail(trailId: string): void {
+    this.removeTrailMarkers(trailId);
+    if (this.loadedTrails.delete(trailId)) {
+      this.events.onTrailsLoaded?.(Array.from(this.loadedTrails.values()));
+    }
+  }
+
+  private removeTrailMarkers(trailId: string): void {
+    const markers = this.trailMarkers.get(trailId);
+    if (markers) {
+      this.map.removeLayer(markers.start);
+      this.map.removeLayer(markers.end);
+      this.trailMarkers.delete(trailId);
+    }
+  }
+
   private cleanupInvisibleTrails(): void {
     const mapBounds = this.map.getBounds();
     const currentBounds = {
@@ -387,6 +597,8 @@ export class MVTTrailService {
     // Reset selection state
     this.selectedTrailId = null;
 
+    this.refreshEngagementStats();
+
     // Add a small delay to ensure cleanup is complete
     setTimeout(() => {
       // Recreate and add the MVT layer with new cache version
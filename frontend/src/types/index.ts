@@ -93,13 +93,25 @@ export interface MVTTrailProperties {
   elevation_start_meters: number;
   elevation_end_meters: number;
 
-  // Engagement data from backend
+  // Ridden status
+  ridden: boolean;
+}
+
+// Engagement stats served separately from tiles (GET /api/engagement/stats)
+export interface EngagementStats {
   rating_average: number;
   rating_count: number;
   comment_count: number;
+}
 
-  // Ridden status
-  ridden: boolean;
+// Tile invalidation event pushed by the server (/api/tiles/events)
+export interface TileEvent {
+  type: "tiles_invalidated" | "tiles_ready" | "trail_changed";
+  seq: number;
+  trail_id?: string;
+  action?: "created" | "updated" | "deleted";
+  bbox?: { north: number; south: number; east: number; west: number };
+  tiles?: { x: number; y: number; z: number }[];
 }
 
 // Simplified trail interface for MVT-based system
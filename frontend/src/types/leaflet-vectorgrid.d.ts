@@ -13,6 +13,8 @@ declare module 'leaflet' {
       attribution?: string;
       maxZoom?: number;
       minZoom?: number;
+      // Other URL template values, functions are called with the tile coordinates
+      [templateKey: string]: any;
     }
 
     interface ProtobufOptions extends VectorGridOptions {